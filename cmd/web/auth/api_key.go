@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// headers of a request signed with an api key
+const (
+	ApiKeyHeader    = "X-Api-Key"
+	TimestampHeader = "X-Timestamp" // unix seconds
+	NonceHeader     = "X-Nonce"     // unique per request, a nonce is accepted only once
+	SignatureHeader = "X-Signature" // hex HMAC-SHA256 of the canonical request
+)
+
+// MaxSignatureAge how far the timestamp of a signed request may be off, nonces are remembered twice as long
+const MaxSignatureAge = 5 * time.Minute
+
+// ApiKeyScopes permissions an api key can be given, partner systems only handle the menu and orders
+var ApiKeyScopes = []string{PermRestaurantMenu, PermRestaurantOrders, PermOrderSearch}
+
+var (
+	ErrInvalidApiKey    = errors.New("invalid api key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrStaleSignature   = errors.New("timestamp outside the allowed window")
+	ErrReplayedRequest  = errors.New("nonce already used")
+)
+
+// ApiKey fields of an active api key needed to verify a request
+type ApiKey struct {
+	Id           primitive.ObjectID
+	RestaurantId primitive.ObjectID
+	Secret       string
+	Scopes       []string
+}
+
+// ApiKeyUsage a request made with an api key
+type ApiKeyUsage struct {
+	ApiKeyId   primitive.ObjectID
+	Method     string
+	Path       string
+	Status     int
+	RemoteAddr string
+	At         time.Time
+}
+
+// ApiKeyStore storage of api keys, nonces and usage
+type ApiKeyStore interface {
+	// GetActiveApiKey key which is neither revoked nor past the grace period of a rotation
+	GetActiveApiKey(ctx context.Context, keyId string) (ApiKey, error)
+	// UseNonce false when the nonce was already used with the key within ttl
+	UseNonce(ctx context.Context, keyId string, nonce string, ttl time.Duration) (bool, error)
+	RecordUsage(ctx context.Context, usage ApiKeyUsage) error
+}
+
+// ApiKeyVerifier authenticates requests signed with an api key
+type ApiKeyVerifier struct {
+	store ApiKeyStore
+}
+
+func NewApiKeyVerifier(store ApiKeyStore) *ApiKeyVerifier {
+	return &ApiKeyVerifier{store: store}
+}
+
+// Sign signature of a request, the canonical request is the method, the path with query, the timestamp,
+// the nonce and the hex SHA-256 of the body, separated by new lines
+func Sign(secret string, method string, requestURI string, timestamp string, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsSigned request carries an api key instead of a token
+func IsSigned(r *http.Request) bool {
+	return r.Header.Get(ApiKeyHeader) != ""
+}
+
+// Verify principal of the api key a request is signed with. The body is read to check the signature
+// and put back for the handlers.
+func (v *ApiKeyVerifier) Verify(r *http.Request) (Principal, error) {
+	keyId := r.Header.Get(ApiKeyHeader)
+	timestamp := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	signature, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if keyId == "" || timestamp == "" || nonce == "" || err != nil || len(signature) == 0 {
+		return Principal{}, ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Principal{}, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > MaxSignatureAge || age < -MaxSignatureAge {
+		return Principal{}, ErrStaleSignature
+	}
+
+	ctx := r.Context()
+	key, err := v.store.GetActiveApiKey(ctx, keyId)
+	if err != nil {
+		return Principal{}, ErrInvalidApiKey
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return Principal{}, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	expected, _ := hex.DecodeString(Sign(key.Secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+	if !hmac.Equal(signature, expected) {
+		return Principal{}, ErrInvalidSignature
+	}
+
+	// checked last, so requests with a wrong signature can not burn nonces
+	fresh, err := v.store.UseNonce(ctx, keyId, nonce, 2*MaxSignatureAge)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !fresh {
+		return Principal{}, ErrReplayedRequest
+	}
+
+	return Principal{Id: key.Id, Type: ApiKeyPrincipal, RestaurantId: key.RestaurantId, Scopes: key.Scopes}, nil
+}
+
+// RecordUsage keeps the request in the usage log of the api key
+func (v *ApiKeyVerifier) RecordUsage(r *http.Request, principal Principal, status int, remoteAddr string) error {
+	return v.store.RecordUsage(r.Context(), ApiKeyUsage{
+		ApiKeyId:   principal.Id,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Status:     status,
+		RemoteAddr: remoteAddr,
+		At:         time.Now(),
+	})
+}
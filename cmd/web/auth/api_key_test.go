@@ -0,0 +1,122 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"food-eats/cmd/web/auth"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeApiKeyStore struct {
+	keys   map[string]auth.ApiKey
+	nonces map[string]bool
+	usage  []auth.ApiKeyUsage
+}
+
+func (s *fakeApiKeyStore) GetActiveApiKey(_ context.Context, keyId string) (auth.ApiKey, error) {
+	key, ok := s.keys[keyId]
+	if !ok {
+		return auth.ApiKey{}, errors.New("no such entry")
+	}
+	return key, nil
+}
+
+func (s *fakeApiKeyStore) UseNonce(_ context.Context, keyId string, nonce string, _ time.Duration) (bool, error) {
+	if s.nonces[keyId+nonce] {
+		return false, nil
+	}
+	s.nonces[keyId+nonce] = true
+	return true, nil
+}
+
+func (s *fakeApiKeyStore) RecordUsage(_ context.Context, usage auth.ApiKeyUsage) error {
+	s.usage = append(s.usage, usage)
+	return nil
+}
+
+func signedRequest(keyId string, secret string, at time.Time, nonce string, body string) *http.Request {
+	r := httptest.NewRequest("POST", "/v1/restaurant/menu/edit?x=1", strings.NewReader(body))
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	r.Header.Set(auth.ApiKeyHeader, keyId)
+	r.Header.Set(auth.TimestampHeader, timestamp)
+	r.Header.Set(auth.NonceHeader, nonce)
+	r.Header.Set(auth.SignatureHeader, auth.Sign(secret, "POST", "/v1/restaurant/menu/edit?x=1", timestamp, nonce, []byte(body)))
+	return r
+}
+
+func TestApiKeyVerify(t *testing.T) {
+	key := auth.ApiKey{
+		Id:           primitive.NewObjectID(),
+		RestaurantId: primitive.NewObjectID(),
+		Secret:       "secret",
+		Scopes:       []string{auth.PermRestaurantMenu},
+	}
+	store := &fakeApiKeyStore{keys: map[string]auth.ApiKey{"fe_1": key}, nonces: map[string]bool{}}
+	tm := auth.NewTokenManager("secret")
+	tm.EnableApiKeys(auth.NewApiKeyVerifier(store))
+
+	r := signedRequest("fe_1", "secret", time.Now(), "n1", `{"items":[]}`)
+	got, err := tm.Authenticate(r, auth.RestaurantPrincipal, auth.ApiKeyPrincipal)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.ApiKeyPrincipal, got.Type)
+	assert.Equal(t, key.RestaurantId, got.ActingRestaurant())
+	assert.True(t, got.Can(auth.PermRestaurantMenu))
+	assert.False(t, got.Can(auth.PermRestaurantOrders))
+
+	// the body is still there for the handler
+	body, err := io.ReadAll(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"items":[]}`, string(body))
+
+	// same nonce again
+	r = signedRequest("fe_1", "secret", time.Now(), "n1", `{"items":[]}`)
+	_, err = tm.Authenticate(r, auth.ApiKeyPrincipal)
+	assert.ErrorIs(t, err, auth.ErrReplayedRequest)
+
+	// body changed after signing
+	r = signedRequest("fe_1", "secret", time.Now(), "n2", `{"items":[]}`)
+	r.Body = httptest.NewRequest("POST", "/", strings.NewReader(`{"items":[1]}`)).Body
+	_, err = tm.Authenticate(r, auth.ApiKeyPrincipal)
+	assert.ErrorIs(t, err, auth.ErrInvalidSignature)
+
+	// a failed signature does not use up the nonce
+	r = signedRequest("fe_1", "secret", time.Now(), "n2", `{"items":[]}`)
+	_, err = tm.Authenticate(r, auth.ApiKeyPrincipal)
+	assert.NoError(t, err)
+
+	r = signedRequest("fe_1", "secret", time.Now().Add(-2*auth.MaxSignatureAge), "n3", "")
+	_, err = tm.Authenticate(r, auth.ApiKeyPrincipal)
+	assert.ErrorIs(t, err, auth.ErrStaleSignature)
+
+	r = signedRequest("fe_2", "secret", time.Now(), "n4", "")
+	_, err = tm.Authenticate(r, auth.ApiKeyPrincipal)
+	assert.ErrorIs(t, err, auth.ErrInvalidApiKey)
+
+	r = signedRequest("fe_1", "other", time.Now(), "n5", "")
+	_, err = tm.Authenticate(r, auth.ApiKeyPrincipal)
+	assert.ErrorIs(t, err, auth.ErrInvalidSignature)
+
+	// routes which do not take api keys
+	r = signedRequest("fe_1", "secret", time.Now(), "n6", "")
+	_, err = tm.Authenticate(r, auth.RestaurantPrincipal)
+	assert.ErrorIs(t, err, auth.ErrWrongType)
+
+	// api keys not enabled
+	r = signedRequest("fe_1", "secret", time.Now(), "n7", "")
+	_, err = auth.NewTokenManager("secret").Authenticate(r, auth.ApiKeyPrincipal)
+	assert.ErrorIs(t, err, auth.ErrInvalidApiKey)
+
+	assert.NoError(t, tm.ApiKeys().RecordUsage(r, got, http.StatusOK, "127.0.0.1"))
+	assert.Len(t, store.usage, 1)
+	assert.Equal(t, key.Id, store.usage[0].ApiKeyId)
+	assert.Equal(t, "/v1/restaurant/menu/edit", store.usage[0].Path)
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"context"
+)
+
+type principalKey struct{}
+
+// WithPrincipal context of an authenticated request, the principal is also added to the log meta information
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+	ctx = context.WithValue(ctx, "principal_id", principal.Id.Hex())
+	ctx = context.WithValue(ctx, "principal_type", principal.Type)
+	ctx = context.WithValue(ctx, "principal_role", principal.Role)
+	return ctx
+}
+
+// PrincipalFromContext principal of an authenticated request, false on public routes
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginChecker CheckOrigin of the websocket upgrader allowing only the configured origins.
+// Requests without an Origin header come from native apps and are allowed, browsers always send one.
+// An origin of "*" allows every origin.
+func OriginChecker(allowedOrigins []string) func(r *http.Request) bool {
+	allowed := map[string]bool{}
+	for _, origin := range allowedOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if allowed["*"] || allowed[strings.ToLower(origin)] {
+			return true
+		}
+		// same origin as the server
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host)
+	}
+}
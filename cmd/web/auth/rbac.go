@@ -0,0 +1,87 @@
+package auth
+
+// roles, every principal has exactly one
+const (
+	RoleCustomer          = "CUSTOMER"
+	RoleRider             = "RIDER"
+	RoleRestaurantOwner   = "RESTAURANT_OWNER"
+	RoleRestaurantManager = "RESTAURANT_MANAGER"
+	RoleRestaurantKitchen = "RESTAURANT_KITCHEN"
+	RoleSupportAgent      = "SUPPORT_AGENT"
+	RoleAdmin             = "ADMIN"
+)
+
+// permissions checked per route
+const (
+	PermAccountPhone      = "account:phone" // verify the phone number of the own account
+	PermUserProfile       = "user:profile"
+	PermRiderProfile      = "rider:profile"
+	PermRestaurantProfile = "restaurant:profile" // name, contact and address of the restaurant
+	PermRestaurantMenu    = "restaurant:menu"
+	PermRestaurantPayout  = "restaurant:payout"
+	PermRestaurantStaff   = "restaurant:staff" // invite and remove staff
+	PermRestaurantApiKeys = "restaurant:api_keys"
+	PermRestaurantOrders  = "restaurant:orders" // view, accept and mark ready the orders of the restaurant
+	PermOrderCreate       = "order:create"
+	PermOrderTrack        = "order:track" // delivery otp and share links of own orders
+	PermOrderSearch       = "order:search"
+	PermOrderRoute        = "order:route"
+	PermOrderReplay       = "order:replay"
+	PermRatingCreate      = "rating:create"
+
+	PermAdminView          = "admin:view"
+	PermAdminSuspend       = "admin:suspend"
+	PermAdminCancelOrder   = "admin:cancel_order"
+	PermAdminReassignRider = "admin:reassign_rider"
+	PermAdminManageAgents  = "admin:manage_agents"
+)
+
+var rolePermissions = map[string][]string{
+	RoleCustomer: {
+		PermAccountPhone, PermUserProfile, PermOrderCreate, PermOrderTrack, PermOrderSearch, PermOrderRoute, PermRatingCreate,
+	},
+	RoleRider: {
+		PermAccountPhone, PermRiderProfile, PermOrderSearch, PermOrderRoute, PermRatingCreate,
+	},
+	RoleRestaurantOwner: {
+		PermAccountPhone, PermRestaurantProfile, PermRestaurantMenu, PermRestaurantPayout, PermRestaurantStaff,
+		PermRestaurantApiKeys, PermRestaurantOrders, PermOrderSearch, PermOrderRoute, PermRatingCreate,
+	},
+	RoleRestaurantManager: {
+		PermAccountPhone, PermRestaurantMenu, PermRestaurantStaff, PermRestaurantOrders, PermOrderSearch, PermOrderRoute,
+	},
+	RoleRestaurantKitchen: {
+		PermAccountPhone, PermRestaurantOrders, PermOrderSearch,
+	},
+	RoleSupportAgent: {
+		PermAccountPhone, PermOrderRoute, PermOrderReplay, PermAdminView, PermAdminCancelOrder, PermAdminReassignRider,
+	},
+	RoleAdmin: {
+		PermAccountPhone, PermOrderRoute, PermOrderReplay, PermAdminView, PermAdminCancelOrder, PermAdminReassignRider,
+		PermAdminSuspend, PermAdminManageAgents,
+	},
+}
+
+// DefaultRole role of users, riders and restaurants, admins and staff have the role stored on their account
+func DefaultRole(principalType string) string {
+	switch principalType {
+	case UserPrincipal:
+		return RoleCustomer
+	case RiderPrincipal:
+		return RoleRider
+	case RestaurantPrincipal:
+		return RoleRestaurantOwner
+	default:
+		return ""
+	}
+}
+
+// HasPermission role is allowed the permission
+func HasPermission(role string, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package auth_test
+
+import (
+	"food-eats/cmd/web/auth"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPermission(t *testing.T) {
+	assert.Equal(t, auth.RoleCustomer, auth.DefaultRole(auth.UserPrincipal))
+	assert.Equal(t, auth.RoleRider, auth.DefaultRole(auth.RiderPrincipal))
+	assert.Equal(t, auth.RoleRestaurantOwner, auth.DefaultRole(auth.RestaurantPrincipal))
+	// admins always carry the role of their account
+	assert.Equal(t, "", auth.DefaultRole(auth.AdminPrincipal))
+
+	assert.True(t, auth.HasPermission(auth.RoleCustomer, auth.PermOrderCreate))
+	assert.False(t, auth.HasPermission(auth.RoleCustomer, auth.PermOrderReplay))
+	assert.False(t, auth.HasPermission(auth.RoleRider, auth.PermAdminView))
+
+	// kitchen staff handle orders but not the menu or the payout details
+	assert.True(t, auth.HasPermission(auth.RoleRestaurantKitchen, auth.PermRestaurantOrders))
+	assert.False(t, auth.HasPermission(auth.RoleRestaurantKitchen, auth.PermRestaurantMenu))
+	assert.False(t, auth.HasPermission(auth.RoleRestaurantKitchen, auth.PermRestaurantPayout))
+	assert.True(t, auth.HasPermission(auth.RoleRestaurantManager, auth.PermRestaurantMenu))
+	assert.False(t, auth.HasPermission(auth.RoleRestaurantManager, auth.PermRestaurantPayout))
+	assert.True(t, auth.HasPermission(auth.RoleRestaurantOwner, auth.PermRestaurantPayout))
+
+	assert.True(t, auth.HasPermission(auth.RoleSupportAgent, auth.PermAdminCancelOrder))
+	assert.False(t, auth.HasPermission(auth.RoleSupportAgent, auth.PermAdminSuspend))
+	assert.True(t, auth.HasPermission(auth.RoleAdmin, auth.PermAdminSuspend))
+
+	// tokens without a role are not allowed anything
+	assert.False(t, auth.HasPermission("", auth.PermUserProfile))
+}
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// principal types, a token is valid only for routes of its type
+const (
+	UserPrincipal       = "USER"
+	RiderPrincipal      = "RIDER"
+	RestaurantPrincipal = "RESTAURANT"
+	AdminPrincipal      = "ADMIN"   // support agents and admins
+	StaffPrincipal      = "STAFF"   // restaurant staff, acting for their restaurant
+	ApiKeyPrincipal     = "API_KEY" // partner system of a restaurant signing requests with an api key
+)
+
+// PrincipalTypes every principal type
+var PrincipalTypes = []string{UserPrincipal, RiderPrincipal, RestaurantPrincipal, AdminPrincipal, StaffPrincipal, ApiKeyPrincipal}
+
+// token uses, a refresh token can only be exchanged for new tokens
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrMissingToken = errors.New("missing token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrWrongType    = errors.New("token not valid for this route")
+	ErrForbidden    = errors.New("not allowed")
+)
+
+// Principal the user, rider, restaurant, staff member, api key or admin a request is made by
+type Principal struct {
+	Id           primitive.ObjectID `json:"id"`
+	Type         string             `json:"type"`
+	Role         string             `json:"role"`
+	RestaurantId primitive.ObjectID `json:"restaurant_id,omitempty"` // restaurant of a staff member or api key
+	Scopes       []string           `json:"scopes,omitempty"`        // permissions of an api key, which has no role
+}
+
+// ActingRestaurant restaurant the principal acts for, the restaurant itself or the one of a staff member or api key
+func (p Principal) ActingRestaurant() primitive.ObjectID {
+	if !p.RestaurantId.IsZero() {
+		return p.RestaurantId
+	}
+	return p.Id
+}
+
+// Can the role of the principal, or the scopes of an api key, have the permission
+func (p Principal) Can(permission string) bool {
+	if p.Type == ApiKeyPrincipal {
+		return slices.Contains(p.Scopes, permission)
+	}
+	return HasPermission(p.Role, permission)
+}
+
+// Claims of the signed tokens, subject is the id of the principal
+type Claims struct {
+	jwt.RegisteredClaims
+	Type         string `json:"typ"`
+	Role         string `json:"role"`
+	RestaurantId string `json:"rid,omitempty"`
+	Use          string `json:"use"`
+}
+
+// TokenPair issued on login and on refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // seconds the access token is valid for
+}
+
+// TokenManager signs and verifies HS256 tokens, and signed requests once api keys are enabled
+type TokenManager struct {
+	secret  []byte
+	apiKeys *ApiKeyVerifier
+}
+
+func NewTokenManager(secret string) *TokenManager {
+	return &TokenManager{secret: []byte(secret)}
+}
+
+// EnableApiKeys accepts requests signed with an api key wherever the api key principal is allowed
+func (tm *TokenManager) EnableApiKeys(verifier *ApiKeyVerifier) {
+	tm.apiKeys = verifier
+}
+
+// ApiKeys verifier of signed requests, nil until api keys are enabled
+func (tm *TokenManager) ApiKeys() *ApiKeyVerifier {
+	return tm.apiKeys
+}
+
+// IssueTokens access and refresh token of the principal
+func (tm *TokenManager) IssueTokens(principal Principal) (TokenPair, error) {
+	accessToken, err := tm.IssueToken(principal, AccessToken, AccessTokenTTL)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, err := tm.IssueToken(principal, RefreshToken, RefreshTokenTTL)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// IssueToken signed token of the principal for the use valid for ttl
+func (tm *TokenManager) IssueToken(principal Principal, use string, ttl time.Duration) (string, error) {
+	currTime := time.Now()
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   principal.Id.Hex(),
+			IssuedAt:  jwt.NewNumericDate(currTime),
+			ExpiresAt: jwt.NewNumericDate(currTime.Add(ttl)),
+		},
+		Type: principal.Type,
+		Role: principal.Role,
+		Use:  use,
+	}
+	if !principal.RestaurantId.IsZero() {
+		claims.RestaurantId = principal.RestaurantId.Hex()
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(tm.secret)
+}
+
+// ParseToken principal of a valid unexpired token issued for the use
+func (tm *TokenManager) ParseToken(token string, use string) (Principal, error) {
+	claims := &Claims{}
+	// only HS256 is issued, tokens signed with any other method are rejected
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return tm.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || claims.Use != use {
+		return Principal{}, ErrInvalidToken
+	}
+
+	id, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return Principal{}, ErrInvalidToken
+	}
+	principal := Principal{Id: id, Type: claims.Type, Role: claims.Role}
+	if claims.RestaurantId != "" {
+		principal.RestaurantId, err = primitive.ObjectIDFromHex(claims.RestaurantId)
+		if err != nil {
+			return Principal{}, ErrInvalidToken
+		}
+	}
+	return principal, nil
+}
+
+// Authenticate principal of the access token or api key sent with the request, which has to be of one of the
+// principalTypes. Browsers can not set headers on websocket upgrades, so the token is also read from the access_token query.
+func (tm *TokenManager) Authenticate(r *http.Request, principalTypes ...string) (Principal, error) {
+	if IsSigned(r) {
+		if !slices.Contains(principalTypes, ApiKeyPrincipal) {
+			return Principal{}, ErrWrongType
+		}
+		if tm == nil || tm.apiKeys == nil {
+			return Principal{}, ErrInvalidApiKey
+		}
+		return tm.apiKeys.Verify(r)
+	}
+
+	token := TokenFromRequest(r)
+	if token == "" {
+		return Principal{}, ErrMissingToken
+	}
+	if tm == nil {
+		return Principal{}, ErrInvalidToken
+	}
+	principal, err := tm.ParseToken(token, AccessToken)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !slices.Contains(principalTypes, principal.Type) {
+		return Principal{}, ErrWrongType
+	}
+	return principal, nil
+}
+
+// TokenFromRequest bearer token of the Authorization header or the access_token query
+func TokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, found := strings.CutPrefix(header, "Bearer ")
+		if found {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.URL.Query().Get("access_token")
+}
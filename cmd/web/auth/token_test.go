@@ -0,0 +1,149 @@
+package auth_test
+
+import (
+	"food-eats/cmd/web/auth"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAuthenticate(t *testing.T) {
+	tm := auth.NewTokenManager("secret")
+	principal := auth.Principal{Id: primitive.NewObjectID(), Type: auth.RiderPrincipal}
+	token, err := tm.IssueToken(principal, auth.AccessToken, time.Minute)
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/v1/websocket/rider", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	got, err := tm.Authenticate(r, auth.RiderPrincipal)
+	assert.NoError(t, err)
+	assert.Equal(t, principal, got)
+
+	// browsers send the token as query
+	r = httptest.NewRequest("GET", "/v1/websocket/rider?access_token="+token, nil)
+	got, err = tm.Authenticate(r, auth.RiderPrincipal)
+	assert.NoError(t, err)
+	assert.Equal(t, principal, got)
+
+	_, err = tm.Authenticate(r, auth.UserPrincipal)
+	assert.ErrorIs(t, err, auth.ErrWrongType)
+
+	_, err = auth.NewTokenManager("other").Authenticate(r, auth.RiderPrincipal)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	expired, err := tm.IssueToken(principal, auth.AccessToken, -time.Minute)
+	assert.NoError(t, err)
+	r = httptest.NewRequest("GET", "/v1/websocket/rider?access_token="+expired, nil)
+	_, err = tm.Authenticate(r, auth.RiderPrincipal)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	// refresh tokens can not be used as access token
+	refresh, err := tm.IssueToken(principal, auth.RefreshToken, time.Minute)
+	assert.NoError(t, err)
+	r = httptest.NewRequest("GET", "/v1/websocket/rider?access_token="+refresh, nil)
+	_, err = tm.Authenticate(r, auth.RiderPrincipal)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	_, err = tm.Authenticate(httptest.NewRequest("GET", "/v1/websocket/rider", nil), auth.RiderPrincipal)
+	assert.ErrorIs(t, err, auth.ErrMissingToken)
+}
+
+func TestParseToken_SigningMethod(t *testing.T) {
+	tm := auth.NewTokenManager("secret")
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   primitive.NewObjectID().Hex(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		Type: auth.RiderPrincipal,
+		Use:  auth.AccessToken,
+	}
+
+	hs256, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	_, err = tm.ParseToken(hs256, auth.AccessToken)
+	assert.NoError(t, err)
+
+	// another hmac method with the same secret
+	hs512, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	_, err = tm.ParseToken(hs512, auth.AccessToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
+	_, err = tm.ParseToken(unsigned, auth.AccessToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	// tokens without an expiry are never accepted
+	claims.ExpiresAt = nil
+	forever, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+	_, err = tm.ParseToken(forever, auth.AccessToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+}
+
+func TestOriginChecker(t *testing.T) {
+	check := auth.OriginChecker([]string{"https://app.example.com/", ""})
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "", want: true},
+		{origin: "https://app.example.com", want: true},
+		{origin: "http://example.com", want: true}, // same host as the request
+		{origin: "https://evil.com", want: false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://example.com/v1/websocket/user", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		assert.Equal(t, tt.want, check(r), tt.origin)
+	}
+
+	r := httptest.NewRequest("GET", "http://example.com/v1/websocket/user", nil)
+	r.Header.Set("Origin", "https://evil.com")
+	assert.True(t, auth.OriginChecker([]string{"*"})(r))
+}
+
+func TestIssueTokens(t *testing.T) {
+	tm := auth.NewTokenManager("secret")
+	principal := auth.Principal{Id: primitive.NewObjectID(), Type: auth.UserPrincipal}
+	tokens, err := tm.IssueTokens(principal)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(auth.AccessTokenTTL.Seconds()), tokens.ExpiresIn)
+
+	got, err := tm.ParseToken(tokens.RefreshToken, auth.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, principal, got)
+
+	// access tokens can not be used to refresh
+	_, err = tm.ParseToken(tokens.AccessToken, auth.RefreshToken)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+}
+
+func TestStaffToken(t *testing.T) {
+	tm := auth.NewTokenManager("secret")
+	principal := auth.Principal{
+		Id:           primitive.NewObjectID(),
+		Type:         auth.StaffPrincipal,
+		Role:         auth.RoleRestaurantKitchen,
+		RestaurantId: primitive.NewObjectID(),
+	}
+	token, err := tm.IssueToken(principal, auth.AccessToken, time.Minute)
+	assert.NoError(t, err)
+
+	got, err := tm.ParseToken(token, auth.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, principal, got)
+	// staff act for their restaurant, restaurants for themselves
+	assert.Equal(t, principal.RestaurantId, got.ActingRestaurant())
+
+	restaurant := auth.Principal{Id: primitive.NewObjectID(), Type: auth.RestaurantPrincipal}
+	assert.Equal(t, restaurant.Id, restaurant.ActingRestaurant())
+}
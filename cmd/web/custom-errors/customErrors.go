@@ -4,37 +4,188 @@ import (
 	"context"
 	"errors"
 	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
 	"strings"
 )
 
+// Error kind of error, decides the status and the code of the response. Errors are joined with their kind,
+// errors.Join(NotFoundError, errors.New("no such entry")).
+type Error struct {
+	Code   string
+	Status int
+}
+
+func (e *Error) Error() string {
+	return e.Code
+}
+
+// Is every kind with a 4xx status is a ClientError
+func (e *Error) Is(target error) bool {
+	return target == ClientError && e.Status < http.StatusInternalServerError
+}
+
 var (
-	ServerError = errors.New("server error")
-	ClientError = errors.New("client error")
+	ServerError  = &Error{Code: "internal_error", Status: http.StatusInternalServerError}
+	ClientError  = &Error{Code: "bad_request", Status: http.StatusBadRequest}
+	Unauthorized = &Error{Code: "unauthorized", Status: http.StatusUnauthorized}
+
+	ValidationError    = &Error{Code: "validation_failed", Status: http.StatusBadRequest}
+	ForbiddenError     = &Error{Code: "forbidden", Status: http.StatusForbidden}
+	NotFoundError      = &Error{Code: "not_found", Status: http.StatusNotFound}
+	ConflictError      = &Error{Code: "conflict", Status: http.StatusConflict}
+	PreconditionError  = &Error{Code: "precondition_failed", Status: http.StatusPreconditionFailed}
+	UnprocessableError = &Error{Code: "unprocessable", Status: http.StatusUnprocessableEntity}
+	TooManyRequests    = &Error{Code: "too_many_requests", Status: http.StatusTooManyRequests}
 )
 
+// serverErrorMessage sent instead of the error, the error itself is only logged
+const serverErrorMessage = "something went wrong"
+
+// FieldError field of the request failing a validation rule
+type FieldError struct {
+	Field    string `json:"field"`     // path of the struct field, Items[0].Id
+	JsonName string `json:"json_name"` // path as sent, items[0].id
+	Rule     string `json:"rule"`
+	Param    string `json:"param,omitempty"`
+	Message  string `json:"message"`
+}
+
+// ValidationErrors fields of a request failing validation, sent as details of a validation_failed response
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, field := range v {
+		messages[i] = field.JsonName + " " + field.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Is validation errors are client errors
+func (v ValidationErrors) Is(target error) bool {
+	return target == ClientError || target == ValidationError
+}
+
+// ErrorResponse body of every error response
+type ErrorResponse struct {
+	Code          string       `json:"code"`
+	Message       string       `json:"message"`
+	Details       []FieldError `json:"details,omitempty"`
+	CorrelationId string       `json:"correlation_id,omitempty"`
+}
+
 // ParseError error parser
 func ParseError(ctx context.Context, err error, req interface{}, c echo.Context) error {
+	if mongo.IsDuplicateKeyError(err) && !errors.Is(err, ClientError) {
+		err = errors.Join(ConflictError, errors.New("entry already exists"))
+	}
 	if errors.Is(err, ClientError) {
-		return parseClientError(err, c)
+		return parseClientError(ctx, err, c)
 	}
 	buffer := debug.Stack()
 	trace := strings.Split(string(buffer), "\n")
 
-	var args []interface{}
+	args := []interface{}{"error", err.Error()}
 	if req != nil {
 		args = append(args, "req", req)
 	}
 	args = append(args, "trace", trace[2:])
-	slog.ErrorContext(ctx, "", "error", err.Error(), args)
-	return parseServerError(err, c)
+	slog.ErrorContext(ctx, "", args...)
+	return parseServerError(ctx, c)
+}
+
+func parseServerError(ctx context.Context, c echo.Context) error {
+	return Respond(ctx, c, ServerError, serverErrorMessage)
 }
-func parseServerError(err error, c echo.Context) error {
-	return c.JSON(http.StatusInternalServerError, err.Error())
+
+func parseClientError(ctx context.Context, err error, c echo.Context) error {
+	var fields ValidationErrors
+	if errors.As(err, &fields) {
+		return Respond(ctx, c, ValidationError, "invalid request", fields...)
+	}
+	return Respond(ctx, c, kindOf(err), message(err))
+}
+
+// Respond writes the error response of the kind
+func Respond(ctx context.Context, c echo.Context, kind *Error, message string, details ...FieldError) error {
+	return c.JSON(kind.Status, ErrorResponse{
+		Code:          kind.Code,
+		Message:       message,
+		Details:       details,
+		CorrelationId: correlationId(ctx),
+	})
+}
+
+// HTTPErrorHandler renders errors returned to echo, like failed binding, in the same format
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	ctx := c.Request().Context()
+
+	var httpError *echo.HTTPError
+	if !errors.As(err, &httpError) {
+		_ = ParseError(ctx, err, nil, c)
+		return
+	}
+	if httpError.Internal != nil {
+		slog.ErrorContext(ctx, "", "error", httpError.Internal.Error())
+	}
+	kind := &Error{Code: strings.ToLower(strings.ReplaceAll(http.StatusText(httpError.Code), " ", "_")), Status: httpError.Code}
+	msg, ok := httpError.Message.(string)
+	if !ok || httpError.Code >= http.StatusInternalServerError {
+		msg = http.StatusText(httpError.Code)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		_ = c.NoContent(httpError.Code)
+		return
+	}
+	_ = Respond(ctx, c, kind, msg)
+}
+
+// kindOf most specific kind of a client error, errors joined only with ClientError stay a bad request
+func kindOf(err error) *Error {
+	kind := ClientError
+	walk(err, func(err error) bool {
+		if e, ok := err.(*Error); ok && e != ClientError && errors.Is(e, ClientError) {
+			kind = e
+			return true
+		}
+		return false
+	})
+	return kind
+}
+
+// message text of the error without its kinds
+func message(err error) string {
+	var messages []string
+	walk(err, func(err error) bool {
+		if _, ok := err.(*Error); !ok {
+			messages = append(messages, err.Error())
+		}
+		return false
+	})
+	return strings.Join(messages, ": ")
+}
+
+// walk calls visit with the errors joined in err until visit returns true
+func walk(err error, visit func(err error) bool) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, inner := range joined.Unwrap() {
+			if walk(inner, visit) {
+				return true
+			}
+		}
+		return false
+	}
+	return visit(err)
 }
 
-func parseClientError(err error, c echo.Context) error {
-	return c.JSON(http.StatusBadRequest, err.Error())
+func correlationId(ctx context.Context) string {
+	id, _ := ctx.Value("correlation_id").(string)
+	return id
 }
@@ -0,0 +1,94 @@
+package custom_errors_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"food-eats/cmd/web/custom-errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestParseError(t *testing.T) {
+	duplicate := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error collection: food-eats.Rating"}}}
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{
+			name:    "client error",
+			err:     errors.Join(custom_errors.ClientError, errors.New("invalid type")),
+			status:  http.StatusBadRequest,
+			code:    "bad_request",
+			message: "invalid type",
+		},
+		{
+			name:    "not found",
+			err:     errors.Join(custom_errors.NotFoundError, errors.New("no such entry")),
+			status:  http.StatusNotFound,
+			code:    "not_found",
+			message: "no such entry",
+		},
+		{
+			name:    "kind wins over client error",
+			err:     errors.Join(custom_errors.ClientError, errors.Join(custom_errors.PreconditionError, errors.New("order is not accepted"))),
+			status:  http.StatusPreconditionFailed,
+			code:    "precondition_failed",
+			message: "order is not accepted",
+		},
+		{
+			name:    "duplicate key",
+			err:     duplicate,
+			status:  http.StatusConflict,
+			code:    "conflict",
+			message: "entry already exists",
+		},
+		{
+			name:    "server errors are not sent",
+			err:     errors.Join(custom_errors.ServerError, errors.New("connection refused")),
+			status:  http.StatusInternalServerError,
+			code:    "internal_error",
+			message: "something went wrong",
+		},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+			ctx := context.WithValue(context.Background(), "correlation_id", "abc")
+
+			assert.NoError(t, custom_errors.ParseError(ctx, tt.err, nil, c))
+			assert.Equal(t, tt.status, rec.Code)
+
+			var got custom_errors.ErrorResponse
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			assert.Equal(t, custom_errors.ErrorResponse{Code: tt.code, Message: tt.message, CorrelationId: "abc"}, got)
+		})
+	}
+
+	// kinds stay client errors for the callers checking them
+	assert.ErrorIs(t, errors.Join(custom_errors.NotFoundError, errors.New("no such entry")), custom_errors.ClientError)
+	assert.NotErrorIs(t, errors.Join(custom_errors.ServerError, errors.New("failed")), custom_errors.ClientError)
+}
+
+func TestHTTPErrorHandler(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	custom_errors.HTTPErrorHandler(echo.NewHTTPError(http.StatusBadRequest, "code=400, message=Syntax error"), e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var got custom_errors.ErrorResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, "bad_request", got.Code)
+	assert.Equal(t, "code=400, message=Syntax error", got.Message)
+}
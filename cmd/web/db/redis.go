@@ -27,6 +27,9 @@ func InitRedisPool(address string, maxIdle, maxActive int) {
 }
 
 func RedisConnFromPool() (*redis.Conn, error) {
+	if redisClient == nil {
+		return nil, errors.New("redis is not initialised")
+	}
 	conn := redisClient.Conn()
 	if conn == nil {
 		return nil, errors.New("error getting redis conn from redis pool")
@@ -43,3 +46,8 @@ func Close(conn *redis.Conn) error {
 	}
 	return nil
 }
+
+// GetRedisClient redis client, used where a single connection is not enough like pub/sub
+func GetRedisClient() *redis.Client {
+	return redisClient
+}
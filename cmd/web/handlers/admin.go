@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"github.com/patrickmn/go-cache"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"time"
+)
+
+// AdminParam dependencies of the support and admin endpoints
+type AdminParam struct {
+	UserRepo       model.UserRepository
+	RiderRepo      model.RiderRepository
+	RestaurantRepo model.RestaurantRepository
+	OrderRepo      model.OrderRepository
+	TripRepo       model.TripRepository
+	AdminRepo      model.AdminRepository
+	AuditRepo      model.AuditRepository
+	Cache          *cache.Cache
+	SM             model.WebSocketManager
+
+	RedisConn redis.Cmdable
+}
+
+// SuspendAccountRequest admin suspending or reinstating a user, rider or restaurant
+type SuspendAccountRequest struct {
+	Type   string             `json:"type" validate:"required,oneof=USER RIDER RESTAURANT"`
+	Id     primitive.ObjectID `json:"id" validate:"required"`
+	Reason string             `json:"reason" validate:"required"`
+}
+
+// CancelOrderRequest support force cancelling an order which was not delivered yet
+type CancelOrderRequest struct {
+	Id          primitive.ObjectID `json:"id" validate:"required"`
+	Reason      string             `json:"reason" validate:"required"`
+	CancelledBy primitive.ObjectID `json:"-"` // from the token
+}
+
+// ReassignRiderRequest support moving an order to another rider before it is picked up
+type ReassignRiderRequest struct {
+	Id      primitive.ObjectID `json:"id" validate:"required"`
+	RiderId primitive.ObjectID `json:"rider_id" validate:"required"`
+}
+
+// GetEntityRequest support looking up any user, rider, restaurant, order or admin
+type GetEntityRequest struct {
+	Type string             `query:"type" validate:"required,oneof=USER RIDER RESTAURANT ORDER ADMIN"`
+	Id   primitive.ObjectID `query:"id" validate:"required"`
+}
+
+// GetAuditLogsRequest admin looking up who changed what, newest first
+type GetAuditLogsRequest struct {
+	Entity   string             `query:"entity" validate:"omitempty,oneof=User Rider Restaurant Order Rating"`
+	EntityId primitive.ObjectID `query:"entity_id"`
+	ActorId  primitive.ObjectID `query:"actor_id"`
+	Action   string             `query:"action" validate:"omitempty,oneof=CREATE UPDATE DELETE"`
+	From     time.Time          `query:"from"` // RFC 3339
+	To       time.Time          `query:"to" validate:"omitempty,gtfield=From"`
+	Limit    int                `query:"limit" validate:"omitempty,min=1,max=100"` // 20 when not set
+	PageNum  int                `query:"page_num" validate:"omitempty,min=1"`      // first page when not set
+}
+
+// CreateAgentRequest admin adding a support agent or another admin
+type CreateAgentRequest struct {
+	Name    string `json:"name" validate:"required"`
+	EmailId string `json:"email_id" validate:"required,email"`
+
+	// applied validation on indian numbers in the format of +91999999999, allowing both 9 and 10-digit numbers
+	PhoneNumber string `json:"phone_number" validate:"required,e164,min=12,max=13,startswith=+91"`
+	Role        string `json:"role" validate:"required,oneof=SUPPORT_AGENT ADMIN"`
+}
+
+// SuspendAccount suspended accounts can not log in, refresh their tokens or take part in new orders
+func (request *SuspendAccountRequest) SuspendAccount(ctx context.Context, param AdminParam) error {
+	if err := setAccountStatus(ctx, param, request.Type, request.Id, "SUSPENDED"); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "account suspended", "type", request.Type, "id", request.Id.Hex(), "reason", request.Reason)
+	return nil
+}
+
+// ReinstateAccount lifts the suspension of an account
+func (request *SuspendAccountRequest) ReinstateAccount(ctx context.Context, param AdminParam) error {
+	if err := setAccountStatus(ctx, param, request.Type, request.Id, "ACTIVE"); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "account reinstated", "type", request.Type, "id", request.Id.Hex(), "reason", request.Reason)
+	return nil
+}
+
+// setAccountStatus moves an account between ACTIVE and SUSPENDED, deleted accounts stay deleted
+func setAccountStatus(ctx context.Context, param AdminParam, accountType string, id primitive.ObjectID, status string) error {
+	// only suspended accounts can be reinstated, any other account can be suspended
+	checkStatus := func(current string) error {
+		if current == "DELETED" {
+			return errors.Join(custom_errors.PreconditionError, errors.New("account deleted"))
+		}
+		if current == status || (status == "ACTIVE" && current != "SUSPENDED") {
+			return errors.Join(custom_errors.ConflictError, errors.New("account is already "+current))
+		}
+		return nil
+	}
+
+	currTime := time.Now()
+	switch accountType {
+	case auth.UserPrincipal:
+		user, err := param.UserRepo.GetUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(user.Status); err != nil {
+			return err
+		}
+		user.Status = status
+		user.UpdatedAt = currTime
+		return param.UserRepo.UpdateUser(ctx, user)
+	case auth.RiderPrincipal:
+		rider, err := param.RiderRepo.GetRider(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(rider.Status); err != nil {
+			return err
+		}
+		rider.Status = status
+		rider.UpdatedAt = currTime
+		return param.RiderRepo.UpdateRider(ctx, rider)
+	case auth.RestaurantPrincipal:
+		restaurant, err := param.RestaurantRepo.GetRestaurant(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(restaurant.Status); err != nil {
+			return err
+		}
+		restaurant.Status = status
+		restaurant.UpdatedAt = currTime
+		if err := param.RestaurantRepo.UpdateRestaurant(ctx, restaurant); err != nil {
+			return err
+		}
+		param.Cache.Delete("restaurant_cache:" + restaurant.Id.Hex())
+		return nil
+	default:
+		return errors.Join(custom_errors.ClientError, errors.New("invalid type"))
+	}
+}
+
+// CancelOrder cancels an order which is not delivered yet, the order is taken off the trip of its rider and the
+// rider assignment is cleared, the restaurant, the rider, the user and the users tracking the order are notified
+func (request *CancelOrderRequest) CancelOrder(ctx context.Context, param AdminParam) (model.Order, error) {
+	order, err := param.OrderRepo.GetOrder(ctx, request.Id)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if order.Status == "DELIVERED" || order.Status == "CANCELLED" {
+		return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("order can not be cancelled"))
+	}
+
+	// riders can no longer accept the order, same key as used on acceptance
+	if _, err := param.RedisConn.Incr(ctx, "order_status:"+order.Id.Hex()).Result(); err != nil {
+		return model.Order{}, err
+	}
+
+	riderId, tripId := order.RiderId, order.TripId
+	currTime := time.Now()
+	order.Status = "CANCELLED"
+	order.CancelledAt = currTime
+	order.CancelledBy = request.CancelledBy
+	order.CancelReason = request.Reason
+	order.UpdatedAt = currTime
+	order.RiderId = primitive.NilObjectID
+	order.TripId = primitive.NilObjectID
+	order.DeliveryStarted = time.Time{}
+	order.RiderArrivedAt = time.Time{}
+	order.PickedUpAt = time.Time{}
+	if err := param.OrderRepo.UnassignOrder(ctx, order); err != nil {
+		return model.Order{}, err
+	}
+
+	if !tripId.IsZero() {
+		trip, err := removeOrderFromTrip(ctx, param.TripRepo, tripId, order.Id)
+		if err != nil {
+			slog.ErrorContext(ctx, "error in removing order from trip", "error", err.Error())
+		} else {
+			sendTripToRider(param.SM, trip, "Order cancelled")
+		}
+	}
+	sendOrderToRestaurant(param.SM, OrderCancelledMessage, order, "Order cancelled")
+	// the order no longer names its rider, the rider it was taken from is told directly
+	statusInfo := OrderStatusInfo{
+		Message: "Order cancelled",
+		OrderId: order.Id,
+		Status:  order.Status,
+		At:      currTime,
+	}
+	sendToOrderWatchers(param.SM, order.Id, OrderStatusMessage, statusInfo, order.UserId)
+	if !riderId.IsZero() {
+		sendToRiders(param.SM, OrderStatusMessage, statusInfo, riderId)
+	}
+	slog.InfoContext(ctx, "order cancelled", "order_id", order.Id.Hex(), "reason", request.Reason)
+
+	return order, nil
+}
+
+// ReassignRider moves an order which is not picked up yet from its rider to another rider,
+// the order is added to the active trip of the new rider if it can be batched with it
+func (request *ReassignRiderRequest) ReassignRider(ctx context.Context, param AdminParam) (model.Order, error) {
+	order, err := param.OrderRepo.GetOrder(ctx, request.Id)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if order.IsPickedUp() || order.Status == "DELIVERED" || order.Status == "CANCELLED" {
+		return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("order can not be reassigned"))
+	}
+	if order.RiderId.IsZero() {
+		return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("order has no rider yet"))
+	}
+	if order.RiderId == request.RiderId {
+		return model.Order{}, errors.Join(custom_errors.ConflictError, errors.New("order already assigned to rider"))
+	}
+
+	rider, err := param.RiderRepo.GetRider(ctx, request.RiderId)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if rider.Status != "ACTIVE" {
+		return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("rider not available"))
+	}
+
+	trip, err := param.TripRepo.GetActiveTrip(ctx, rider.Id)
+	if err != nil && !errors.Is(err, custom_errors.ClientError) {
+		return model.Order{}, err
+	}
+	if !trip.Id.IsZero() && !canBatch(trip, order) {
+		return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("order can not be batched with the trip of the rider"))
+	}
+
+	previousTripId := order.TripId
+	currTime := time.Now()
+	order.RiderId = rider.Id
+	// the new rider still has to reach the restaurant
+	if order.Status == "RIDER_ARRIVED" {
+		order.Status = "RIDER_ASSIGNED"
+	}
+	order.RiderArrivedAt = time.Time{}
+	order.UpdatedAt = currTime
+
+	trip, err = addOrderToTrip(ctx, OrderParam{TripRepo: param.TripRepo}, trip, order, rider.Location.GetLatitude(), rider.Location.GetLongitude())
+	if err != nil {
+		return model.Order{}, err
+	}
+	order.TripId = trip.Id
+	if err := param.OrderRepo.UpdateOrder(ctx, order); err != nil {
+		// the order stays with its rider, taking it off the new trip again
+		if _, err := removeOrderFromTrip(ctx, param.TripRepo, trip.Id, order.Id); err != nil {
+			slog.ErrorContext(ctx, "error in removing order from trip", "error", err.Error())
+		}
+		return model.Order{}, err
+	}
+
+	// only detached from the old trip once the order is with the new rider, so a failure never leaves it on no trip
+	if !previousTripId.IsZero() {
+		previousTrip, err := removeOrderFromTrip(ctx, param.TripRepo, previousTripId, order.Id)
+		if err != nil {
+			slog.ErrorContext(ctx, "error in removing order from trip", "error", err.Error())
+		} else {
+			sendTripToRider(param.SM, previousTrip, "Order reassigned")
+		}
+	}
+	sendTripToRider(param.SM, trip, "Trip updated")
+	sendOrderToRestaurant(param.SM, RiderAssignedMessage, order, "Rider reassigned")
+	sendOrderStatus(param.SM, order, "Rider reassigned", currTime)
+
+	return order, nil
+}
+
+// GetEntity any user, rider, restaurant, order or admin by id, the restaurant cache is skipped
+func (request *GetEntityRequest) GetEntity(ctx context.Context, param AdminParam) (interface{}, error) {
+	switch request.Type {
+	case auth.UserPrincipal:
+		return param.UserRepo.GetUser(ctx, request.Id)
+	case auth.RiderPrincipal:
+		return param.RiderRepo.GetRider(ctx, request.Id)
+	case auth.RestaurantPrincipal:
+		return param.RestaurantRepo.GetRestaurant(ctx, request.Id)
+	case "ORDER":
+		return param.OrderRepo.GetOrder(ctx, request.Id)
+	case auth.AdminPrincipal:
+		return param.AdminRepo.GetAdmin(ctx, request.Id)
+	default:
+		return nil, errors.Join(custom_errors.ClientError, errors.New("invalid type"))
+	}
+}
+
+// GetAuditLogs changes matching the filters with the actor and the request they were made in
+func (request *GetAuditLogsRequest) GetAuditLogs(ctx context.Context, param AdminParam) (*model.PageResponse[model.AuditLog], error) {
+	if request.Limit == 0 {
+		request.Limit = 20
+	}
+	if request.PageNum == 0 {
+		request.PageNum = 1
+	}
+
+	logs, total, err := param.AuditRepo.SearchAuditLogs(ctx, model.AuditQuery{
+		Entity:   request.Entity,
+		EntityId: request.EntityId,
+		ActorId:  request.ActorId,
+		Action:   request.Action,
+		From:     request.From,
+		To:       request.To,
+		Limit:    request.Limit,
+		Skip:     (request.PageNum - 1) * request.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return model.NewPageResponse(logs, total, request.PageNum, request.Limit), nil
+}
+
+// CreateAgent registers a support agent or admin, they log in with an otp like everyone else
+func (request *CreateAgentRequest) CreateAgent(ctx context.Context, param AdminParam) (model.Admin, error) {
+	_, err := param.AdminRepo.GetAdminByPhone(ctx, request.PhoneNumber)
+	if err == nil {
+		return model.Admin{}, errors.Join(custom_errors.ConflictError, errors.New("phone number already registered"))
+	}
+	if !errors.Is(err, custom_errors.ClientError) {
+		return model.Admin{}, err
+	}
+
+	currTime := time.Now()
+	admin := model.Admin{
+		Name:        request.Name,
+		EmailId:     request.EmailId,
+		PhoneNumber: request.PhoneNumber,
+		Role:        request.Role,
+		CreatedAt:   currTime,
+		UpdatedAt:   currTime,
+		Status:      "ACTIVE",
+	}
+	return param.AdminRepo.CreateAdmin(ctx, admin)
+}
+
+// BootstrapAdmin creates the first admin with the phone number, nothing is done if it already exists
+func BootstrapAdmin(ctx context.Context, repo model.AdminRepository, phoneNumber string) error {
+	_, err := repo.GetAdminByPhone(ctx, phoneNumber)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, custom_errors.ClientError) {
+		return err
+	}
+
+	currTime := time.Now()
+	_, err = repo.CreateAdmin(ctx, model.Admin{
+		Name:        "Admin",
+		PhoneNumber: phoneNumber,
+		Role:        auth.RoleAdmin,
+		CreatedAt:   currTime,
+		UpdatedAt:   currTime,
+		Status:      "ACTIVE",
+	})
+	return err
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"github.com/patrickmn/go-cache"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSetAccountStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		status  string
+		errIs   error
+	}{
+		{"suspending an active account", "ACTIVE", "SUSPENDED", nil},
+		{"reinstating a suspended account", "SUSPENDED", "ACTIVE", nil},
+		{"suspending a suspended account", "SUSPENDED", "SUSPENDED", custom_errors.ConflictError},
+		{"reinstating an active account", "ACTIVE", "ACTIVE", custom_errors.ConflictError},
+		{"suspending a deleted account", "DELETED", "SUSPENDED", custom_errors.PreconditionError},
+		{"reinstating a deleted account", "DELETED", "ACTIVE", custom_errors.PreconditionError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := primitive.NewObjectID()
+			param := AdminParam{
+				UserRepo:       newMemoryUsers(model.User{Id: id, Status: tt.current}),
+				RiderRepo:      newMemoryRiders(model.Rider{Id: id, Status: tt.current}),
+				RestaurantRepo: newMemoryRestaurants(model.Restaurant{Id: id, Status: tt.current}),
+				Cache:          cache.New(time.Minute, time.Minute),
+			}
+			param.Cache.Set("restaurant_cache:"+id.Hex(), model.Restaurant{Id: id}, time.Minute)
+
+			want := tt.status
+			if tt.errIs != nil {
+				want = tt.current
+			}
+			for _, accountType := range []string{auth.UserPrincipal, auth.RiderPrincipal, auth.RestaurantPrincipal} {
+				err := setAccountStatus(context.TODO(), param, accountType, id, tt.status)
+				if tt.errIs != nil {
+					assert.ErrorIs(t, err, tt.errIs, accountType)
+				} else {
+					assert.NoError(t, err, accountType)
+				}
+			}
+			assert.Equal(t, want, param.UserRepo.(*memoryUsers).users[id].Status)
+			assert.Equal(t, want, param.RiderRepo.(*memoryRiders).riders[id].Status)
+			assert.Equal(t, want, param.RestaurantRepo.(*memoryRestaurants).restaurants[id].Status)
+			// the cached restaurant is dropped once its status changed
+			_, cached := param.Cache.Get("restaurant_cache:" + id.Hex())
+			assert.Equal(t, tt.errIs != nil, cached)
+		})
+	}
+
+	t.Run("unknown account", func(t *testing.T) {
+		param := AdminParam{UserRepo: newMemoryUsers()}
+		assert.ErrorIs(t, setAccountStatus(context.TODO(), param, auth.UserPrincipal, primitive.NewObjectID(), "SUSPENDED"), custom_errors.NotFoundError)
+		assert.ErrorIs(t, setAccountStatus(context.TODO(), param, "ORDER", primitive.NewObjectID(), "SUSPENDED"), custom_errors.ClientError)
+	})
+}
+
+func TestRemoveOrderFromTrip(t *testing.T) {
+	a := tripOrder(0, 0, 0.027, 0)
+	b := tripOrder(0, 0, 0.018, 0)
+	trip := activeTrip(a, b)
+	trip.Id = primitive.NewObjectID()
+	trips := newMemoryTrips(trip)
+
+	updated, err := removeOrderFromTrip(context.TODO(), trips, trip.Id, a.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []primitive.ObjectID{b.Id}, updated.OrderIds)
+	assert.Equal(t, []string{"b PICKUP", "b DROP"}, stopNames(updated.Stops, map[primitive.ObjectID]string{b.Id: "b"}))
+	assert.Equal(t, "ACTIVE", trips.trips[trip.Id].Status)
+
+	// a trip left without orders is completed
+	updated, err = removeOrderFromTrip(context.TODO(), trips, trip.Id, b.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, updated.Stops)
+	assert.Equal(t, "COMPLETED", trips.trips[trip.Id].Status)
+	assert.False(t, trips.trips[trip.Id].CompletedAt.IsZero())
+
+	_, err = removeOrderFromTrip(context.TODO(), trips, primitive.NewObjectID(), a.Id)
+	assert.ErrorIs(t, err, custom_errors.NotFoundError)
+}
+
+// assignedOrder order of a rider on a trip with only that order, not picked up yet
+func assignedOrder() (model.Order, model.Trip) {
+	order := tripOrder(0, 0, 0.027, 0)
+	order.RiderId = primitive.NewObjectID()
+	order.RestaurantId = primitive.NewObjectID()
+	order.Status = "RIDER_ARRIVED"
+	order.RiderArrivedAt = time.Now()
+	trip := activeTrip(order)
+	trip.Id = primitive.NewObjectID()
+	trip.RiderId = order.RiderId
+	order.TripId = trip.Id
+	return order, trip
+}
+
+func TestCancelOrder(t *testing.T) {
+	t.Run("order leaves the trip of its rider", func(t *testing.T) {
+		order, trip := assignedOrder()
+		param := AdminParam{
+			OrderRepo: newMemoryOrders(order),
+			TripRepo:  newMemoryTrips(trip),
+			SM:        newRecordingSockets(),
+			RedisConn: newMemoryCounters(),
+		}
+		req := CancelOrderRequest{Id: order.Id, Reason: "restaurant closed", CancelledBy: primitive.NewObjectID()}
+
+		cancelled, err := req.CancelOrder(context.TODO(), param)
+		assert.NoError(t, err)
+		assert.Equal(t, "CANCELLED", cancelled.Status)
+		stored := param.OrderRepo.(*memoryOrders).orders[order.Id]
+		assert.Equal(t, req.CancelledBy, stored.CancelledBy)
+		assert.Equal(t, int64(1), param.RedisConn.(*memoryCounters).counters["order_status:"+order.Id.Hex()])
+		assert.Equal(t, "COMPLETED", param.TripRepo.(*memoryTrips).trips[trip.Id].Status)
+		sockets := param.SM.(*recordingSockets)
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual([]string{OrderCancelledMessage}, sockets.sent(order.RestaurantId))
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("rider assignment is cleared and the rider and user told", func(t *testing.T) {
+		order, trip := assignedOrder()
+		order.UserId = primitive.NewObjectID()
+		order.PickedUpAt = time.Now()
+		order.Status = "PICKED_UP"
+		param := AdminParam{
+			OrderRepo: newMemoryOrders(order),
+			TripRepo:  newMemoryTrips(trip),
+			SM:        newRecordingSockets(),
+			RedisConn: newMemoryCounters(),
+		}
+		req := CancelOrderRequest{Id: order.Id, Reason: "rider had an accident"}
+
+		cancelled, err := req.CancelOrder(context.TODO(), param)
+		assert.NoError(t, err)
+		stored := param.OrderRepo.(*memoryOrders).orders[order.Id]
+		assert.Equal(t, cancelled, stored)
+		assert.True(t, stored.RiderId.IsZero())
+		assert.True(t, stored.TripId.IsZero())
+		assert.False(t, stored.IsPickedUp())
+		assert.True(t, stored.RiderArrivedAt.IsZero())
+		sockets := param.SM.(*recordingSockets)
+		assert.Contains(t, sockets.sent(order.RiderId), OrderStatusMessage)
+		assert.Equal(t, []string{OrderStatusMessage}, sockets.sent(order.UserId))
+	})
+
+	for _, status := range []string{"DELIVERED", "CANCELLED"} {
+		t.Run(status+" order", func(t *testing.T) {
+			order, trip := assignedOrder()
+			order.Status = status
+			param := AdminParam{
+				OrderRepo: newMemoryOrders(order),
+				TripRepo:  newMemoryTrips(trip),
+				SM:        newRecordingSockets(),
+				RedisConn: newMemoryCounters(),
+			}
+			req := CancelOrderRequest{Id: order.Id, Reason: "restaurant closed"}
+
+			_, err := req.CancelOrder(context.TODO(), param)
+			assert.ErrorIs(t, err, custom_errors.PreconditionError)
+			assert.Empty(t, param.RedisConn.(*memoryCounters).counters)
+			assert.Equal(t, status, param.OrderRepo.(*memoryOrders).orders[order.Id].Status)
+		})
+	}
+}
+
+func TestCancelledOrder(t *testing.T) {
+	order, trip := assignedOrder()
+	order.UserId = primitive.NewObjectID()
+	order.DeliveryOtp = "1234"
+	riderId := order.RiderId
+	param := AdminParam{
+		OrderRepo: newMemoryOrders(order),
+		TripRepo:  newMemoryTrips(trip),
+		SM:        newRecordingSockets(),
+		RedisConn: newMemoryCounters(),
+	}
+	req := CancelOrderRequest{Id: order.Id, Reason: "restaurant closed"}
+	_, err := req.CancelOrder(context.TODO(), param)
+	assert.NoError(t, err)
+
+	orderParam := OrderParam{OrderRepo: param.OrderRepo, TripRepo: param.TripRepo, SM: param.SM}
+	pickup := PickupReq{OrderId: order.Id, Latitude: "12.9716", Longitude: "77.5946"}
+	paths := map[string]func() error{
+		"food ready": func() error {
+			ready := FoodReadyRequest{Id: order.Id, RestaurantId: order.RestaurantId}
+			return ready.MarkFoodReady(context.TODO(), orderParam)
+		},
+		"arrived at restaurant": func() error {
+			return handleArrivedAtRestaurant(context.TODO(), param.SM, orderParam, riderId, pickup)
+		},
+		"picked up": func() error {
+			return handlePickedUp(context.TODO(), param.SM, orderParam, riderId, pickup)
+		},
+		"delivered": func() error {
+			delivered := DeliveredReq{OrderId: order.Id, Latitude: "12.9716", Longitude: "77.5946", Otp: "1234"}
+			return handleOrderDelivered(context.TODO(), param.SM, orderParam, riderId, delivered)
+		},
+		"delivery otp": func() error {
+			otp := GetDeliveryOtpRequest{Id: order.Id, UserId: order.UserId}
+			_, err := otp.GetDeliveryOtp(context.TODO(), orderParam)
+			return err
+		},
+	}
+	for name, path := range paths {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, path(), custom_errors.PreconditionError)
+			assert.Equal(t, "CANCELLED", param.OrderRepo.(*memoryOrders).orders[order.Id].Status)
+		})
+	}
+}
+
+func TestReassignRider(t *testing.T) {
+	newRider := func(status string) model.Rider {
+		return model.Rider{Id: primitive.NewObjectID(), Status: status, Location: model.NewLocationFromLongLat(restaurantLng, restaurantLat)}
+	}
+
+	t.Run("order moves to a new trip of the rider", func(t *testing.T) {
+		order, trip := assignedOrder()
+		rider := newRider("ACTIVE")
+		orders, trips := newMemoryOrders(order), newMemoryTrips(trip)
+		param := AdminParam{OrderRepo: orders, TripRepo: trips, RiderRepo: newMemoryRiders(rider), SM: newRecordingSockets()}
+
+		req := ReassignRiderRequest{Id: order.Id, RiderId: rider.Id}
+		reassigned, err := req.ReassignRider(context.TODO(), param)
+		assert.NoError(t, err)
+		assert.Equal(t, rider.Id, reassigned.RiderId)
+		assert.Equal(t, "RIDER_ASSIGNED", reassigned.Status)
+		assert.True(t, reassigned.RiderArrivedAt.IsZero())
+		assert.Equal(t, reassigned, orders.orders[order.Id])
+
+		newTrip := trips.trips[reassigned.TripId]
+		assert.Equal(t, rider.Id, newTrip.RiderId)
+		assert.Equal(t, []primitive.ObjectID{order.Id}, newTrip.OrderIds)
+		assert.Equal(t, "COMPLETED", trips.trips[trip.Id].Status)
+		assert.Empty(t, trips.trips[trip.Id].OrderIds)
+	})
+
+	t.Run("order stays with its rider when saving it fails", func(t *testing.T) {
+		order, trip := assignedOrder()
+		rider := newRider("ACTIVE")
+		orders, trips := newMemoryOrders(order), newMemoryTrips(trip)
+		orders.updateErr = errors.New("write failed")
+		param := AdminParam{OrderRepo: orders, TripRepo: trips, RiderRepo: newMemoryRiders(rider), SM: newRecordingSockets()}
+
+		req := ReassignRiderRequest{Id: order.Id, RiderId: rider.Id}
+		_, err := req.ReassignRider(context.TODO(), param)
+		assert.Error(t, err)
+		assert.Equal(t, order, orders.orders[order.Id])
+		assert.Equal(t, trip, trips.trips[trip.Id])
+		// the trip created for the new rider is left without the order
+		for id, newTrip := range trips.trips {
+			if id != trip.Id {
+				assert.Empty(t, newTrip.OrderIds)
+				assert.Equal(t, "COMPLETED", newTrip.Status)
+			}
+		}
+	})
+
+	tests := []struct {
+		name  string
+		order func(order model.Order) model.Order
+		rider model.Rider
+		errIs error
+	}{
+		{
+			name:  "picked up",
+			order: func(order model.Order) model.Order { order.PickedUpAt = time.Now(); return order },
+			rider: newRider("ACTIVE"),
+			errIs: custom_errors.PreconditionError,
+		},
+		{
+			name:  "no rider yet",
+			order: func(order model.Order) model.Order { order.RiderId = primitive.NilObjectID; return order },
+			rider: newRider("ACTIVE"),
+			errIs: custom_errors.PreconditionError,
+		},
+		{
+			name:  "suspended rider",
+			order: func(order model.Order) model.Order { return order },
+			rider: newRider("SUSPENDED"),
+			errIs: custom_errors.PreconditionError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, trip := assignedOrder()
+			order = tt.order(order)
+			orders, trips := newMemoryOrders(order), newMemoryTrips(trip)
+			param := AdminParam{OrderRepo: orders, TripRepo: trips, RiderRepo: newMemoryRiders(tt.rider), SM: newRecordingSockets()}
+
+			req := ReassignRiderRequest{Id: order.Id, RiderId: tt.rider.Id}
+			_, err := req.ReassignRider(context.TODO(), param)
+			assert.ErrorIs(t, err, tt.errIs)
+			assert.Equal(t, order, orders.orders[order.Id])
+			assert.Equal(t, map[primitive.ObjectID]model.Trip{trip.Id: trip}, trips.trips)
+		})
+	}
+
+	t.Run("same rider", func(t *testing.T) {
+		order, trip := assignedOrder()
+		param := AdminParam{OrderRepo: newMemoryOrders(order), TripRepo: newMemoryTrips(trip), SM: newRecordingSockets()}
+		req := ReassignRiderRequest{Id: order.Id, RiderId: order.RiderId}
+		_, err := req.ReassignRider(context.TODO(), param)
+		assert.ErrorIs(t, err, custom_errors.ConflictError)
+	})
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/db"
+	"food-eats/cmd/web/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// apiKeyRotationGrace time the old key keeps working after a rotation, to roll out the new key
+const apiKeyRotationGrace = 24 * time.Hour
+
+// ApiKeyParam dependencies of the api key endpoints
+type ApiKeyParam struct {
+	Repository model.ApiKeyRepository
+}
+
+// CreateApiKeyRequest owner creating a key for a partner system of the restaurant
+type CreateApiKeyRequest struct {
+	CreatedBy auth.Principal `json:"-"` // from the token
+	Name      string         `json:"name" validate:"required"`
+	Scopes    []string       `json:"scopes" validate:"required,min=1,dive,oneof=restaurant:menu restaurant:orders order:search"`
+}
+
+// ApiKeyRequest owner rotating or revoking a key of the restaurant
+type ApiKeyRequest struct {
+	Principal auth.Principal     `json:"-"` // from the token
+	Id        primitive.ObjectID `json:"id" validate:"required"`
+}
+
+// GetApiKeysRequest keys of the restaurant
+type GetApiKeysRequest struct {
+	RestaurantId primitive.ObjectID `validate:"required"` // from the token
+}
+
+// GetApiKeyUsageRequest latest requests made with a key of the restaurant
+type GetApiKeyUsageRequest struct {
+	Principal auth.Principal     `json:"-"` // from the token
+	Id        primitive.ObjectID `query:"id" validate:"required"`
+	Limit     int                `query:"limit" validate:"omitempty,min=1,max=500"`
+}
+
+// ApiKeyWithSecret key together with its secret, returned only on creation and rotation
+type ApiKeyWithSecret struct {
+	model.ApiKey
+	Secret string `json:"secret"`
+}
+
+// ApiKeyStore api keys in mongo with nonces in redis, used to verify signed requests
+type ApiKeyStore struct {
+	Repository model.ApiKeyRepository
+}
+
+func (s ApiKeyStore) GetActiveApiKey(ctx context.Context, keyId string) (auth.ApiKey, error) {
+	key, err := s.Repository.GetApiKeyByKeyId(ctx, keyId)
+	if err != nil {
+		return auth.ApiKey{}, err
+	}
+	if !key.IsActive(time.Now()) {
+		return auth.ApiKey{}, errors.Join(custom_errors.ClientError, errors.New("api key not active"))
+	}
+	return auth.ApiKey{Id: key.Id, RestaurantId: key.RestaurantId, Secret: key.Secret, Scopes: key.Scopes}, nil
+}
+
+func (s ApiKeyStore) UseNonce(ctx context.Context, keyId string, nonce string, ttl time.Duration) (bool, error) {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return false, err
+	}
+	defer db.Close(redisConn)
+
+	return redisConn.SetNX(ctx, "api_nonce:"+keyId+":"+nonce, 1, ttl).Result()
+}
+
+func (s ApiKeyStore) RecordUsage(ctx context.Context, usage auth.ApiKeyUsage) error {
+	return s.Repository.AddUsage(ctx, model.ApiKeyUsage{
+		ApiKeyId:   usage.ApiKeyId,
+		Method:     usage.Method,
+		Path:       usage.Path,
+		Status:     usage.Status,
+		RemoteAddr: usage.RemoteAddr,
+		At:         usage.At,
+	})
+}
+
+// randomHex n random bytes hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newApiKey key of the restaurant with a fresh key id and secret
+func newApiKey(restaurantId primitive.ObjectID, name string, scopes []string, createdBy primitive.ObjectID) (model.ApiKey, error) {
+	keyId, err := randomHex(12)
+	if err != nil {
+		return model.ApiKey{}, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return model.ApiKey{}, err
+	}
+
+	currTime := time.Now()
+	return model.ApiKey{
+		RestaurantId: restaurantId,
+		Name:         name,
+		KeyId:        "fe_" + keyId,
+		Secret:       secret,
+		Scopes:       scopes,
+		CreatedBy:    createdBy,
+		CreatedAt:    currTime,
+		UpdatedAt:    currTime,
+		Status:       "ACTIVE",
+	}, nil
+}
+
+// getRestaurantApiKey key of the restaurant the principal acts for
+func getRestaurantApiKey(ctx context.Context, param ApiKeyParam, principal auth.Principal, id primitive.ObjectID) (model.ApiKey, error) {
+	key, err := param.Repository.GetApiKey(ctx, id)
+	if err != nil {
+		return model.ApiKey{}, err
+	}
+	if key.RestaurantId != principal.ActingRestaurant() {
+		return model.ApiKey{}, errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+	}
+	return key, nil
+}
+
+// CreateApiKey creates a key with the scopes, the secret is only returned now
+func (request *CreateApiKeyRequest) CreateApiKey(ctx context.Context, param ApiKeyParam) (ApiKeyWithSecret, error) {
+	key, err := newApiKey(request.CreatedBy.ActingRestaurant(), request.Name, request.Scopes, request.CreatedBy.Id)
+	if err != nil {
+		return ApiKeyWithSecret{}, err
+	}
+	key, err = param.Repository.CreateApiKey(ctx, key)
+	if err != nil {
+		return ApiKeyWithSecret{}, err
+	}
+	return ApiKeyWithSecret{ApiKey: key, Secret: key.Secret}, nil
+}
+
+// RotateApiKey creates a key with the same name and scopes, the old key keeps working for apiKeyRotationGrace
+func (request *ApiKeyRequest) RotateApiKey(ctx context.Context, param ApiKeyParam) (ApiKeyWithSecret, error) {
+	old, err := getRestaurantApiKey(ctx, param, request.Principal, request.Id)
+	if err != nil {
+		return ApiKeyWithSecret{}, err
+	}
+	if !old.IsActive(time.Now()) || !old.RotatedTo.IsZero() {
+		return ApiKeyWithSecret{}, errors.Join(custom_errors.ConflictError, errors.New("api key already rotated or revoked"))
+	}
+
+	key, err := newApiKey(old.RestaurantId, old.Name, old.Scopes, request.Principal.Id)
+	if err != nil {
+		return ApiKeyWithSecret{}, err
+	}
+	key, err = param.Repository.CreateApiKey(ctx, key)
+	if err != nil {
+		return ApiKeyWithSecret{}, err
+	}
+
+	currTime := time.Now()
+	old.ExpiresAt = currTime.Add(apiKeyRotationGrace)
+	old.RotatedTo = key.Id
+	old.UpdatedAt = currTime
+	if err := param.Repository.UpdateApiKey(ctx, old); err != nil {
+		return ApiKeyWithSecret{}, err
+	}
+	return ApiKeyWithSecret{ApiKey: key, Secret: key.Secret}, nil
+}
+
+// RevokeApiKey stops the key from signing requests right away
+func (request *ApiKeyRequest) RevokeApiKey(ctx context.Context, param ApiKeyParam) error {
+	key, err := getRestaurantApiKey(ctx, param, request.Principal, request.Id)
+	if err != nil {
+		return err
+	}
+	if key.Status == "REVOKED" {
+		return errors.Join(custom_errors.ConflictError, errors.New("api key already revoked"))
+	}
+
+	key.Status = "REVOKED"
+	key.UpdatedAt = time.Now()
+	return param.Repository.UpdateApiKey(ctx, key)
+}
+
+// GetApiKeys keys of the restaurant without their secrets
+func (request *GetApiKeysRequest) GetApiKeys(ctx context.Context, param ApiKeyParam) ([]model.ApiKey, error) {
+	return param.Repository.GetRestaurantApiKeys(ctx, request.RestaurantId)
+}
+
+// GetApiKeyUsage latest requests made with the key, 100 by default
+func (request *GetApiKeyUsageRequest) GetApiKeyUsage(ctx context.Context, param ApiKeyParam) ([]model.ApiKeyUsage, error) {
+	if _, err := getRestaurantApiKey(ctx, param, request.Principal, request.Id); err != nil {
+		return nil, err
+	}
+	limit := request.Limit
+	if limit == 0 {
+		limit = 100
+	}
+	return param.Repository.GetUsage(ctx, request.Id, limit)
+}
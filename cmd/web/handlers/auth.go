@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"food-eats/cmd/web/notify"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// SendLoginOtpRequest user, rider, restaurant, staff member or admin asking for an otp to log in with
+type SendLoginOtpRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required,e164,min=12,max=13,startswith=+91"`
+	Type        string `json:"type" validate:"required,oneof=USER RIDER RESTAURANT ADMIN STAFF"`
+}
+
+// SendOtpResponse otp was sent to the phone number
+type SendOtpResponse struct {
+	ExpiresIn   int64 `json:"expires_in"`   // seconds the otp is valid for
+	ResendAfter int64 `json:"resend_after"` // seconds until a new otp can be requested
+	MaxAttempts int   `json:"max_attempts"` // wrong otp allowed before a new one has to be requested
+}
+
+// LoginRequest logging in with the otp sent to the phone number
+type LoginRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required,e164,min=12,max=13,startswith=+91"`
+	Type        string `json:"type" validate:"required,oneof=USER RIDER RESTAURANT ADMIN STAFF"`
+	Otp         string `json:"otp" validate:"required,len=6,numeric"`
+}
+
+// RefreshTokenRequest exchanging a refresh token for new tokens
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// SendPhoneOtpRequest logged in user, rider or restaurant asking for an otp to verify its phone number
+type SendPhoneOtpRequest struct {
+	Principal auth.Principal `json:"-"` // from the token
+}
+
+// VerifyPhoneRequest verifying the phone number with the otp sent to it
+type VerifyPhoneRequest struct {
+	Principal auth.Principal `json:"-"` // from the token
+	Otp       string         `json:"otp" validate:"required,len=6,numeric"`
+}
+
+// AuthParam dependencies of login
+type AuthParam struct {
+	UserRepo       model.UserRepository
+	RiderRepo      model.RiderRepository
+	RestaurantRepo model.RestaurantRepository
+	AdminRepo      model.AdminRepository
+	StaffRepo      model.RestaurantStaffRepository
+	Tokens         *auth.TokenManager
+	SMS            notify.SMSSender
+
+	RedisConn *redis.Conn
+}
+
+// account fields of a user, rider, restaurant, staff member or admin needed to log in
+type account struct {
+	principal   auth.Principal
+	phoneNumber string
+	status      string
+	verified    bool
+}
+
+// findAccount user, rider, restaurant, staff member or admin registered with the phone number
+func findAccount(ctx context.Context, param AuthParam, principalType string, phoneNumber string) (account, error) {
+	switch principalType {
+	case auth.UserPrincipal:
+		user, err := param.UserRepo.GetUserByPhone(ctx, phoneNumber)
+		return account{newPrincipal(user.Id, principalType, ""), user.PhoneNumber, user.Status, user.Verified}, err
+	case auth.RiderPrincipal:
+		rider, err := param.RiderRepo.GetRiderByPhone(ctx, phoneNumber)
+		return account{newPrincipal(rider.Id, principalType, ""), rider.PhoneNumber, rider.Status, rider.Verified}, err
+	case auth.RestaurantPrincipal:
+		restaurant, err := param.RestaurantRepo.GetRestaurantByPhone(ctx, phoneNumber)
+		return account{newPrincipal(restaurant.Id, principalType, ""), restaurant.PhoneNumber, restaurant.Status, restaurant.Verified}, err
+	case auth.AdminPrincipal:
+		admin, err := param.AdminRepo.GetAdminByPhone(ctx, phoneNumber)
+		return account{newPrincipal(admin.Id, principalType, admin.Role), admin.PhoneNumber, admin.Status, admin.Verified}, err
+	case auth.StaffPrincipal:
+		staff, err := param.StaffRepo.GetStaffByPhone(ctx, phoneNumber)
+		if err != nil {
+			return account{}, err
+		}
+		return staffAccount(ctx, param, staff)
+	default:
+		return account{}, errors.Join(custom_errors.ClientError, errors.New("invalid type"))
+	}
+}
+
+// getAccount user, rider, restaurant, staff member or admin of the principal, the role is read again as it may have changed
+func getAccount(ctx context.Context, param AuthParam, principal auth.Principal) (account, error) {
+	switch principal.Type {
+	case auth.UserPrincipal:
+		user, err := param.UserRepo.GetUser(ctx, principal.Id)
+		return account{newPrincipal(user.Id, principal.Type, ""), user.PhoneNumber, user.Status, user.Verified}, err
+	case auth.RiderPrincipal:
+		rider, err := param.RiderRepo.GetRider(ctx, principal.Id)
+		return account{newPrincipal(rider.Id, principal.Type, ""), rider.PhoneNumber, rider.Status, rider.Verified}, err
+	case auth.RestaurantPrincipal:
+		restaurant, err := param.RestaurantRepo.GetRestaurant(ctx, principal.Id)
+		return account{newPrincipal(restaurant.Id, principal.Type, ""), restaurant.PhoneNumber, restaurant.Status, restaurant.Verified}, err
+	case auth.AdminPrincipal:
+		admin, err := param.AdminRepo.GetAdmin(ctx, principal.Id)
+		return account{newPrincipal(admin.Id, principal.Type, admin.Role), admin.PhoneNumber, admin.Status, admin.Verified}, err
+	case auth.StaffPrincipal:
+		staff, err := param.StaffRepo.GetStaff(ctx, principal.Id)
+		if err != nil {
+			return account{}, err
+		}
+		return staffAccount(ctx, param, staff)
+	default:
+		return account{}, errors.Join(custom_errors.ClientError, errors.New("invalid type"))
+	}
+}
+
+// setPhoneVerified marks the phone number of the account verified, once an otp sent to it was entered
+func setPhoneVerified(ctx context.Context, param AuthParam, acc account) error {
+	if acc.verified {
+		return nil
+	}
+	switch acc.principal.Type {
+	case auth.UserPrincipal:
+		return param.UserRepo.SetPhoneVerified(ctx, acc.principal.Id)
+	case auth.RiderPrincipal:
+		return param.RiderRepo.SetPhoneVerified(ctx, acc.principal.Id)
+	case auth.AdminPrincipal:
+		return param.AdminRepo.SetPhoneVerified(ctx, acc.principal.Id)
+	case auth.StaffPrincipal:
+		return param.StaffRepo.SetPhoneVerified(ctx, acc.principal.Id)
+	default:
+		return param.RestaurantRepo.SetPhoneVerified(ctx, acc.principal.Id)
+	}
+}
+
+// staffAccount account of a staff member, staff of a suspended or deleted restaurant take its status
+// and an invitation has to be accepted before it expires
+func staffAccount(ctx context.Context, param AuthParam, staff model.RestaurantStaff) (account, error) {
+	if staff.Status == "INVITED" && time.Now().After(staff.InviteExpiresAt) {
+		return account{}, errors.Join(custom_errors.PreconditionError, errors.New("invitation expired"))
+	}
+	restaurant, err := param.RestaurantRepo.GetRestaurant(ctx, staff.RestaurantId)
+	if err != nil {
+		return account{}, err
+	}
+
+	principal := auth.Principal{Id: staff.Id, Type: auth.StaffPrincipal, Role: staff.Role, RestaurantId: staff.RestaurantId}
+	status := staff.Status
+	if restaurant.Status != "ACTIVE" {
+		status = restaurant.Status
+	}
+	return account{principal, staff.PhoneNumber, status, staff.Verified}, nil
+}
+
+// acceptInvite first login of an invited staff member activates the account
+func acceptInvite(ctx context.Context, param AuthParam, acc account) error {
+	if acc.principal.Type != auth.StaffPrincipal || acc.status != "INVITED" {
+		return nil
+	}
+	staff, err := param.StaffRepo.GetStaff(ctx, acc.principal.Id)
+	if err != nil {
+		return err
+	}
+	currTime := time.Now()
+	staff.Status = "ACTIVE"
+	staff.AcceptedAt = currTime
+	staff.UpdatedAt = currTime
+	return param.StaffRepo.UpdateStaff(ctx, staff)
+}
+
+// newPrincipal principal with the role stored on the account, or the default role of the type
+func newPrincipal(id primitive.ObjectID, principalType string, role string) auth.Principal {
+	if role == "" {
+		role = auth.DefaultRole(principalType)
+	}
+	return auth.Principal{Id: id, Type: principalType, Role: role}
+}
+
+// checkActive suspended and deleted accounts can not log in
+func checkActive(acc account) error {
+	switch acc.status {
+	case "SUSPENDED":
+		return errors.Join(custom_errors.ForbiddenError, errors.New("account suspended"))
+	case "DELETED":
+		return errors.Join(custom_errors.ForbiddenError, errors.New("account deleted"))
+	}
+	return nil
+}
+
+func newSendOtpResponse() SendOtpResponse {
+	return SendOtpResponse{
+		ExpiresIn:   int64(otpTTL.Seconds()),
+		ResendAfter: int64(otpResendCooldown.Seconds()),
+		MaxAttempts: maxOtpAttempts,
+	}
+}
+
+// SendLoginOtp sends an otp to the phone number of a registered account
+func (request *SendLoginOtpRequest) SendLoginOtp(ctx context.Context, param AuthParam) (SendOtpResponse, error) {
+	acc, err := findAccount(ctx, param, request.Type, request.PhoneNumber)
+	if err != nil {
+		return SendOtpResponse{}, err
+	}
+	if err := checkActive(acc); err != nil {
+		return SendOtpResponse{}, err
+	}
+	if err := issueOtp(ctx, redisOtpStore{conn: param.RedisConn}, param.SMS, loginOtp, request.Type, request.PhoneNumber); err != nil {
+		return SendOtpResponse{}, err
+	}
+	return newSendOtpResponse(), nil
+}
+
+// Login verifies the otp and issues tokens for the account of the phone number.
+// Entering the otp proves the phone number, so it is marked verified as well, and accepts a staff invitation.
+func (request *LoginRequest) Login(ctx context.Context, param AuthParam) (auth.TokenPair, error) {
+	if err := verifyOtp(ctx, redisOtpStore{conn: param.RedisConn}, loginOtp, request.Type, request.PhoneNumber, request.Otp); err != nil {
+		return auth.TokenPair{}, err
+	}
+
+	acc, err := findAccount(ctx, param, request.Type, request.PhoneNumber)
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	if err := checkActive(acc); err != nil {
+		return auth.TokenPair{}, err
+	}
+	if err := acceptInvite(ctx, param, acc); err != nil {
+		return auth.TokenPair{}, err
+	}
+	if err := setPhoneVerified(ctx, param, acc); err != nil {
+		return auth.TokenPair{}, err
+	}
+	return param.Tokens.IssueTokens(acc.principal)
+}
+
+// Refresh issues new tokens for a valid refresh token, as long as the account is not suspended or deleted
+func (request *RefreshTokenRequest) Refresh(ctx context.Context, param AuthParam) (auth.TokenPair, error) {
+	principal, err := param.Tokens.ParseToken(request.RefreshToken, auth.RefreshToken)
+	if err != nil {
+		return auth.TokenPair{}, errors.Join(custom_errors.Unauthorized, err)
+	}
+	acc, err := getAccount(ctx, param, principal)
+	if err != nil {
+		return auth.TokenPair{}, err
+	}
+	if err := checkActive(acc); err != nil {
+		return auth.TokenPair{}, err
+	}
+	return param.Tokens.IssueTokens(acc.principal)
+}
+
+// SendPhoneOtp sends an otp to the registered phone number, needed after the number was changed
+func (request *SendPhoneOtpRequest) SendPhoneOtp(ctx context.Context, param AuthParam) (SendOtpResponse, error) {
+	acc, err := getAccount(ctx, param, request.Principal)
+	if err != nil {
+		return SendOtpResponse{}, err
+	}
+	if acc.verified {
+		return SendOtpResponse{}, errors.Join(custom_errors.ConflictError, errors.New("phone number already verified"))
+	}
+	if err := issueOtp(ctx, redisOtpStore{conn: param.RedisConn}, param.SMS, verifyPhoneOtp, acc.principal.Type, acc.phoneNumber); err != nil {
+		return SendOtpResponse{}, err
+	}
+	return newSendOtpResponse(), nil
+}
+
+// VerifyPhone marks the phone number verified when the otp sent to it is correct
+func (request *VerifyPhoneRequest) VerifyPhone(ctx context.Context, param AuthParam) error {
+	acc, err := getAccount(ctx, param, request.Principal)
+	if err != nil {
+		return err
+	}
+	if err := verifyOtp(ctx, redisOtpStore{conn: param.RedisConn}, verifyPhoneOtp, acc.principal.Type, acc.phoneNumber, request.Otp); err != nil {
+		return err
+	}
+	return setPhoneVerified(ctx, param, acc)
+}
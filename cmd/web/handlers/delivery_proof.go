@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math/big"
+)
+
+// after these many wrong otp the rider has to use photo proof, photo proof is not accepted before
+const maxDeliveryOtpAttempts = 5
+
+// DeliveredReq rider marking an order delivered with the otp told by the user, a photo of the drop once the otp
+// attempts are used up
+type DeliveredReq struct {
+	OrderId   primitive.ObjectID `json:"order_id"`
+	Latitude  string             `json:"latitude" validate:"latitude"`
+	Longitude string             `json:"longitude" validate:"longitude"`
+	Otp       string             `json:"otp"`
+	PhotoUrl  string             `json:"photo_url"` // uploaded photo of the delivered order
+}
+
+// DeliveryOtpInfo sent to the user once a rider is assigned
+type DeliveryOtpInfo struct {
+	Message string             `json:"message"`
+	OrderId primitive.ObjectID `json:"order_id"`
+	Otp     string             `json:"otp"`
+}
+
+// GetDeliveryOtpRequest user fetching the otp to share with the rider
+type GetDeliveryOtpRequest struct {
+	Id     primitive.ObjectID `query:"id" validate:"required"`
+	UserId primitive.ObjectID `validate:"required"` // from the token
+}
+
+// generateDeliveryOtp random 4-digit otp
+func generateDeliveryOtp() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(10000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%04d", n.Int64()), nil
+}
+
+// verifyDeliveryProof checks the proof sent by the rider and records it on the order.
+// A wrong otp is counted against the order, so the order needs to be saved even when an error is returned.
+// Errors are MessageError and are sent back to the rider.
+func verifyDeliveryProof(order *model.Order, riderId primitive.ObjectID, req DeliveredReq) error {
+	if order.RiderId != riderId {
+		return newMessageError(ErrCodeForbidden, "order is not assigned to rider")
+	}
+	if !order.IsPickedUp() {
+		return newMessageError(ErrCodeInvalidState, "order is not picked up")
+	}
+
+	if req.Otp != "" {
+		if order.DeliveryOtpAttempts >= maxDeliveryOtpAttempts {
+			return newMessageError(ErrCodeInvalidProof, "otp attempts exhausted, use photo proof")
+		}
+		order.DeliveryOtpAttempts++
+		if subtle.ConstantTimeCompare([]byte(req.Otp), []byte(order.DeliveryOtp)) != 1 {
+			return newMessageError(ErrCodeInvalidProof, "invalid otp")
+		}
+		order.DeliveryProof = "OTP"
+		return nil
+	}
+
+	// photo proof is only the way out for users unable to tell the otp, else the otp could be skipped
+	if req.PhotoUrl != "" {
+		if order.DeliveryOtpAttempts < maxDeliveryOtpAttempts {
+			return newMessageError(ErrCodeInvalidProof, "otp is required, photo proof only after otp attempts are exhausted")
+		}
+		order.DeliveryProof = "PHOTO"
+		order.DeliveryPhotoUrl = req.PhotoUrl
+		return nil
+	}
+
+	return newMessageError(ErrCodeInvalidProof, "otp or photo proof is required")
+}
+
+// GetDeliveryOtp otp of an order out for delivery, only the user who placed the order can see it
+func (request *GetDeliveryOtpRequest) GetDeliveryOtp(ctx context.Context, param OrderParam) (DeliveryOtpInfo, error) {
+	order, err := param.OrderRepo.GetOrder(ctx, request.Id)
+	if err != nil {
+		return DeliveryOtpInfo{}, err
+	}
+	if order.UserId != request.UserId {
+		return DeliveryOtpInfo{}, errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+	}
+	if order.Status == "CANCELLED" {
+		return DeliveryOtpInfo{}, errors.Join(custom_errors.PreconditionError, errors.New("order is cancelled"))
+	}
+	if order.RiderId.IsZero() || order.Status == "DELIVERED" {
+		return DeliveryOtpInfo{}, errors.Join(custom_errors.PreconditionError, errors.New("order is not out for delivery"))
+	}
+
+	return DeliveryOtpInfo{
+		Message: "Share this otp with the rider",
+		OrderId: order.Id,
+		Otp:     order.DeliveryOtp,
+	}, nil
+}
+
+func sendDeliveryOtpToUser(rm model.WebSocketManager, order model.Order) {
+	otpInfo := DeliveryOtpInfo{
+		Message: "Rider assigned, share this otp with the rider",
+		OrderId: order.Id,
+		Otp:     order.DeliveryOtp,
+	}
+	sendToUsers(rm, DeliveryOtpMessage, otpInfo, order.UserId)
+}
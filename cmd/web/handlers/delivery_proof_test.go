@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"food-eats/cmd/web/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestVerifyDeliveryProof(t *testing.T) {
+	riderId := primitive.NewObjectID()
+	pickedUp := func(attempts int) model.Order {
+		return model.Order{
+			Id:                  primitive.NewObjectID(),
+			RiderId:             riderId,
+			Status:              "PICKED_UP",
+			PickedUpAt:          time.Now(),
+			DeliveryOtp:         "4821",
+			DeliveryOtpAttempts: attempts,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		order    model.Order
+		riderId  primitive.ObjectID
+		req      DeliveredReq
+		code     string // empty when accepted
+		proof    string
+		attempts int
+	}{
+		{
+			name:     "right otp",
+			order:    pickedUp(0),
+			riderId:  riderId,
+			req:      DeliveredReq{Otp: "4821"},
+			proof:    "OTP",
+			attempts: 1,
+		},
+		{
+			name:    "wrong rider",
+			order:   pickedUp(0),
+			riderId: primitive.NewObjectID(),
+			req:     DeliveredReq{Otp: "4821"},
+			code:    ErrCodeForbidden,
+		},
+		{
+			name:    "not picked up",
+			order:   func() model.Order { order := pickedUp(0); order.PickedUpAt = time.Time{}; return order }(),
+			riderId: riderId,
+			req:     DeliveredReq{Otp: "4821"},
+			code:    ErrCodeInvalidState,
+		},
+		{
+			name:     "wrong otp counts an attempt",
+			order:    pickedUp(2),
+			riderId:  riderId,
+			req:      DeliveredReq{Otp: "1111"},
+			code:     ErrCodeInvalidProof,
+			attempts: 3,
+		},
+		{
+			name:     "otp attempts exhausted",
+			order:    pickedUp(maxDeliveryOtpAttempts),
+			riderId:  riderId,
+			req:      DeliveredReq{Otp: "4821"},
+			code:     ErrCodeInvalidProof,
+			attempts: maxDeliveryOtpAttempts,
+		},
+		{
+			name:     "photo before otp attempts are exhausted",
+			order:    pickedUp(maxDeliveryOtpAttempts - 1),
+			riderId:  riderId,
+			req:      DeliveredReq{PhotoUrl: "https://cdn.example.com/drop.jpg"},
+			code:     ErrCodeInvalidProof,
+			attempts: maxDeliveryOtpAttempts - 1,
+		},
+		{
+			name:     "photo after otp attempts are exhausted",
+			order:    pickedUp(maxDeliveryOtpAttempts),
+			riderId:  riderId,
+			req:      DeliveredReq{PhotoUrl: "https://cdn.example.com/drop.jpg"},
+			proof:    "PHOTO",
+			attempts: maxDeliveryOtpAttempts,
+		},
+		{
+			name:    "no proof",
+			order:   pickedUp(0),
+			riderId: riderId,
+			req:     DeliveredReq{},
+			code:    ErrCodeInvalidProof,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := tt.order
+			err := verifyDeliveryProof(&order, tt.riderId, tt.req)
+			if tt.code == "" {
+				assert.NoError(t, err)
+			} else {
+				var messageErr *MessageError
+				assert.True(t, errors.As(err, &messageErr))
+				assert.Equal(t, tt.code, messageErr.Code)
+			}
+			assert.Equal(t, tt.proof, order.DeliveryProof)
+			assert.Equal(t, tt.attempts, order.DeliveryOtpAttempts)
+			if tt.proof == "PHOTO" {
+				assert.Equal(t, tt.req.PhotoUrl, order.DeliveryPhotoUrl)
+			}
+		})
+	}
+}
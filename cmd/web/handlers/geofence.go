@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"food-eats/cmd/web/db"
+	"food-eats/cmd/web/model"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"time"
+)
+
+// GeofenceConfig when a rider is considered arrived at a stop
+type GeofenceConfig struct {
+	Radius float64       // in meters, 0 disables automatic arrival detection
+	Dwell  time.Duration // rider has to stay inside the radius for this long
+}
+
+// handleGeofence compares the rider location with the pending stops of the rider's trip and marks the rider
+// arrived at a pickup or drop once the rider stays inside the radius for the dwell time
+func handleGeofence(ctx context.Context, rm model.WebSocketManager, or OrderParam, trip model.Trip, latitude, longitude float64) {
+	if or.Geofence.Radius <= 0 {
+		return
+	}
+	riderId := trip.RiderId
+
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		slog.ErrorContext(ctx, "error getting redis connection", "error", err.Error())
+		return
+	}
+	defer db.Close(redisConn)
+
+	for _, stop := range arrivedStops(ctx, redisGeofenceEntries{conn: redisConn}, trip, latitude, longitude, or.Geofence, time.Now()) {
+		slog.InfoContext(ctx, "rider arrived at stop", "rider_id", riderId.Hex(), "order_id", stop.OrderId.Hex(), "type", stop.Type)
+		if stop.Type == "PICKUP" {
+			err = handleArrivedAtRestaurant(ctx, rm, or, riderId, PickupReq{OrderId: stop.OrderId})
+		} else {
+			err = handleStopStatus(ctx, rm, or, riderId, StopStatusReq{TripId: trip.Id, OrderId: stop.OrderId, Type: "DROP", Status: "ARRIVED"})
+		}
+		if err != nil {
+			slog.InfoContext(ctx, "error marking rider arrived", "error", err.Error())
+		}
+	}
+}
+
+// arrivedStops pending stops the rider stayed inside the radius of for the dwell time. Leaving the radius starts
+// the dwell time over. A drop is only considered once its pickup is completed, the rider passing by the user on the
+// way to the restaurant has not arrived.
+func arrivedStops(ctx context.Context, entries geofenceEntries, trip model.Trip, latitude, longitude float64, config GeofenceConfig, now time.Time) []model.TripStop {
+	var arrived []model.TripStop
+	for _, stop := range trip.Stops {
+		if stop.Status != "PENDING" {
+			continue
+		}
+		if stop.Type == "DROP" {
+			if pickup, ok := trip.GetStop(stop.OrderId, "PICKUP"); !ok || pickup.Status != "COMPLETED" {
+				continue
+			}
+		}
+
+		key := "geofence:" + trip.RiderId.Hex() + ":" + stop.OrderId.Hex() + ":" + stop.Type
+		if !insideGeofence(latitude, longitude, stop, config.Radius) {
+			entries.Leave(ctx, key)
+			continue
+		}
+
+		enteredAt, err := entries.Enter(ctx, key, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "error saving geofence entry", "error", err.Error())
+			continue
+		}
+		if now.Sub(enteredAt) < config.Dwell {
+			continue
+		}
+
+		entries.Leave(ctx, key)
+		arrived = append(arrived, stop)
+	}
+	return arrived
+}
+
+// geofenceEntries when the rider entered the radius of a stop
+type geofenceEntries interface {
+	// Enter keeps the time unless the rider is already inside, returns the time the rider entered
+	Enter(ctx context.Context, key string, at time.Time) (time.Time, error)
+	Leave(ctx context.Context, key string)
+}
+
+// redisGeofenceEntries entries kept in redis, so the rider can be on any instance between location updates
+type redisGeofenceEntries struct {
+	conn *redis.Conn
+}
+
+func (r redisGeofenceEntries) Enter(ctx context.Context, key string, at time.Time) (time.Time, error) {
+	if _, err := r.conn.SetNX(ctx, key, at.Unix(), 30*time.Minute).Result(); err != nil {
+		return time.Time{}, err
+	}
+	enteredAt, err := r.conn.Get(ctx, key).Int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(enteredAt, 0), nil
+}
+
+func (r redisGeofenceEntries) Leave(ctx context.Context, key string) {
+	r.conn.Del(ctx, key)
+}
+
+// insideGeofence rider location is within radius meters of the stop
+func insideGeofence(latitude, longitude float64, stop model.TripStop, radius float64) bool {
+	return distanceBetweenPoints(latitude, longitude, stop.Latitude, stop.Longitude)*1000 <= radius
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"food-eats/cmd/web/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryGeofenceEntries geofence entries in memory
+type memoryGeofenceEntries map[string]time.Time
+
+func (m memoryGeofenceEntries) Enter(_ context.Context, key string, at time.Time) (time.Time, error) {
+	if enteredAt, ok := m[key]; ok {
+		return enteredAt, nil
+	}
+	m[key] = at
+	return at, nil
+}
+
+func (m memoryGeofenceEntries) Leave(_ context.Context, key string) {
+	delete(m, key)
+}
+
+func TestInsideGeofence(t *testing.T) {
+	stop := model.TripStop{Latitude: restaurantLat, Longitude: restaurantLng}
+	// 0.0009 degrees of latitude is about 100 meters
+	assert.True(t, insideGeofence(restaurantLat, restaurantLng, stop, 50))
+	assert.True(t, insideGeofence(restaurantLat+0.0009, restaurantLng, stop, 150))
+	assert.False(t, insideGeofence(restaurantLat+0.0009, restaurantLng, stop, 50))
+}
+
+func TestArrivedStops(t *testing.T) {
+	ctx := context.TODO()
+	config := GeofenceConfig{Radius: 100, Dwell: time.Minute}
+	order := tripOrder(0, 0, 0.027, 0)
+	trip := activeTrip(order)
+	trip.RiderId = primitive.NewObjectID()
+	atRestaurant := func(entries memoryGeofenceEntries, at time.Time) []model.TripStop {
+		return arrivedStops(ctx, entries, trip, restaurantLat, restaurantLng, config, at)
+	}
+	start := time.Now()
+
+	t.Run("entering the radius starts the dwell time", func(t *testing.T) {
+		entries := memoryGeofenceEntries{}
+		assert.Empty(t, atRestaurant(entries, start))
+		assert.Len(t, entries, 1)
+		assert.Empty(t, atRestaurant(entries, start.Add(30*time.Second)))
+	})
+
+	t.Run("staying for the dwell time arrives", func(t *testing.T) {
+		entries := memoryGeofenceEntries{}
+		atRestaurant(entries, start)
+		arrived := atRestaurant(entries, start.Add(time.Minute))
+		assert.Len(t, arrived, 1)
+		assert.Equal(t, "PICKUP", arrived[0].Type)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("leaving the radius starts the dwell time over", func(t *testing.T) {
+		entries := memoryGeofenceEntries{}
+		atRestaurant(entries, start)
+		assert.Empty(t, arrivedStops(ctx, entries, trip, restaurantLat+0.009, restaurantLng, config, start.Add(30*time.Second)))
+		assert.Empty(t, entries)
+		assert.Empty(t, atRestaurant(entries, start.Add(70*time.Second)))
+		assert.Len(t, atRestaurant(entries, start.Add(130*time.Second)), 1)
+	})
+
+	t.Run("no drop before the pickup is completed", func(t *testing.T) {
+		entries := memoryGeofenceEntries{}
+		drop := trip.Stops[1]
+		for _, at := range []time.Time{start, start.Add(time.Minute)} {
+			assert.Empty(t, arrivedStops(ctx, entries, trip, drop.Latitude, drop.Longitude, config, at))
+		}
+		assert.Empty(t, entries)
+	})
+
+	t.Run("drop once the pickup is completed", func(t *testing.T) {
+		entries := memoryGeofenceEntries{}
+		pickedUp := activeTrip(order)
+		pickedUp.Stops[0].Status = "COMPLETED"
+		drop := pickedUp.Stops[1]
+		arrivedStops(ctx, entries, pickedUp, drop.Latitude, drop.Longitude, config, start)
+		arrived := arrivedStops(ctx, entries, pickedUp, drop.Latitude, drop.Longitude, config, start.Add(time.Minute))
+		assert.Len(t, arrived, 1)
+		assert.Equal(t, "DROP", arrived[0].Type)
+	})
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// in memory repositories for handler tests, methods a test does not need are left to the embedded interface
+
+var errNoEntry = errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+
+type memoryOrders struct {
+	model.OrderRepository
+	orders    map[primitive.ObjectID]model.Order
+	updateErr error // returned by UpdateOrder when set
+}
+
+func newMemoryOrders(orders ...model.Order) *memoryOrders {
+	repo := &memoryOrders{orders: map[primitive.ObjectID]model.Order{}}
+	for _, order := range orders {
+		repo.orders[order.Id] = order
+	}
+	return repo
+}
+
+func (m *memoryOrders) GetOrder(_ context.Context, id primitive.ObjectID) (model.Order, error) {
+	order, ok := m.orders[id]
+	if !ok {
+		return model.Order{}, errNoEntry
+	}
+	return order, nil
+}
+
+func (m *memoryOrders) UpdateOrder(_ context.Context, order model.Order) error {
+	if m.updateErr != nil {
+		return m.updateErr
+	}
+	if _, ok := m.orders[order.Id]; !ok {
+		return errNoEntry
+	}
+	m.orders[order.Id] = order
+	return nil
+}
+
+func (m *memoryOrders) UpdateOrderFrom(ctx context.Context, order model.Order, from string) error {
+	if stored, ok := m.orders[order.Id]; ok && stored.Status != from {
+		return errors.Join(custom_errors.ConflictError, errors.New("order is no longer "+from))
+	}
+	return m.UpdateOrder(ctx, order)
+}
+
+func (m *memoryOrders) UnassignOrder(ctx context.Context, order model.Order) error {
+	order.RiderId = primitive.NilObjectID
+	order.TripId = primitive.NilObjectID
+	order.DeliveryStarted = time.Time{}
+	order.RiderArrivedAt = time.Time{}
+	order.PickedUpAt = time.Time{}
+	return m.UpdateOrder(ctx, order)
+}
+
+type memoryTrips struct {
+	model.TripRepository
+	trips     map[primitive.ObjectID]model.Trip
+	updateErr error // returned by CreateTrip and UpdateTrip when set
+}
+
+func newMemoryTrips(trips ...model.Trip) *memoryTrips {
+	repo := &memoryTrips{trips: map[primitive.ObjectID]model.Trip{}}
+	for _, trip := range trips {
+		repo.trips[trip.Id] = trip
+	}
+	return repo
+}
+
+func (m *memoryTrips) CreateTrip(_ context.Context, trip model.Trip) (model.Trip, error) {
+	if m.updateErr != nil {
+		return model.Trip{}, m.updateErr
+	}
+	trip.Id = primitive.NewObjectID()
+	m.trips[trip.Id] = trip
+	return trip, nil
+}
+
+func (m *memoryTrips) UpdateTrip(_ context.Context, trip model.Trip) error {
+	if m.updateErr != nil {
+		return m.updateErr
+	}
+	if _, ok := m.trips[trip.Id]; !ok {
+		return errNoEntry
+	}
+	m.trips[trip.Id] = trip
+	return nil
+}
+
+func (m *memoryTrips) GetTrip(_ context.Context, id primitive.ObjectID) (model.Trip, error) {
+	trip, ok := m.trips[id]
+	if !ok {
+		return model.Trip{}, errNoEntry
+	}
+	return trip, nil
+}
+
+func (m *memoryTrips) GetActiveTrip(_ context.Context, riderId primitive.ObjectID) (model.Trip, error) {
+	for _, trip := range m.trips {
+		if trip.RiderId == riderId && trip.Status == "ACTIVE" {
+			return trip, nil
+		}
+	}
+	return model.Trip{}, errNoEntry
+}
+
+type memoryRiders struct {
+	model.RiderRepository
+	riders map[primitive.ObjectID]model.Rider
+}
+
+func newMemoryRiders(riders ...model.Rider) *memoryRiders {
+	repo := &memoryRiders{riders: map[primitive.ObjectID]model.Rider{}}
+	for _, rider := range riders {
+		repo.riders[rider.Id] = rider
+	}
+	return repo
+}
+
+func (m *memoryRiders) GetRider(_ context.Context, id primitive.ObjectID) (model.Rider, error) {
+	rider, ok := m.riders[id]
+	if !ok {
+		return model.Rider{}, errNoEntry
+	}
+	return rider, nil
+}
+
+func (m *memoryRiders) UpdateRider(_ context.Context, rider model.Rider) error {
+	m.riders[rider.Id] = rider
+	return nil
+}
+
+type memoryUsers struct {
+	model.UserRepository
+	users map[primitive.ObjectID]model.User
+}
+
+func newMemoryUsers(users ...model.User) *memoryUsers {
+	repo := &memoryUsers{users: map[primitive.ObjectID]model.User{}}
+	for _, user := range users {
+		repo.users[user.Id] = user
+	}
+	return repo
+}
+
+func (m *memoryUsers) GetUser(_ context.Context, id primitive.ObjectID) (model.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return model.User{}, errNoEntry
+	}
+	return user, nil
+}
+
+func (m *memoryUsers) UpdateUser(_ context.Context, user model.User) error {
+	m.users[user.Id] = user
+	return nil
+}
+
+type memoryRestaurants struct {
+	model.RestaurantRepository
+	restaurants map[primitive.ObjectID]model.Restaurant
+}
+
+func newMemoryRestaurants(restaurants ...model.Restaurant) *memoryRestaurants {
+	repo := &memoryRestaurants{restaurants: map[primitive.ObjectID]model.Restaurant{}}
+	for _, restaurant := range restaurants {
+		repo.restaurants[restaurant.Id] = restaurant
+	}
+	return repo
+}
+
+func (m *memoryRestaurants) GetRestaurant(_ context.Context, id primitive.ObjectID) (model.Restaurant, error) {
+	restaurant, ok := m.restaurants[id]
+	if !ok {
+		return model.Restaurant{}, errNoEntry
+	}
+	return restaurant, nil
+}
+
+func (m *memoryRestaurants) UpdateRestaurant(_ context.Context, restaurant model.Restaurant) error {
+	m.restaurants[restaurant.Id] = restaurant
+	return nil
+}
+
+type memoryLocations struct {
+	model.LocationHistoryRepository
+	points []model.LocationPoint
+}
+
+func (m *memoryLocations) SearchLocationPoints(_ context.Context, query model.SearchLocationQuery) ([]model.LocationPoint, error) {
+	var points []model.LocationPoint
+	for _, point := range m.points {
+		if point.TripId == query.TripId {
+			points = append(points, point)
+		}
+	}
+	return points, nil
+}
+
+// memoryCounters redis with only the INCR used to stop riders accepting an order
+type memoryCounters struct {
+	redis.Cmdable
+	counters map[string]int64
+}
+
+func newMemoryCounters() *memoryCounters {
+	return &memoryCounters{counters: map[string]int64{}}
+}
+
+func (m *memoryCounters) Incr(_ context.Context, key string) *redis.IntCmd {
+	m.counters[key]++
+	return redis.NewIntResult(m.counters[key], nil)
+}
+
+// recordingSockets keeps the types of the messages sent, some are sent from other goroutines
+type recordingSockets struct {
+	model.WebSocketManager
+	lock     sync.Mutex
+	messages map[primitive.ObjectID][]string
+}
+
+func newRecordingSockets() *recordingSockets {
+	return &recordingSockets{messages: map[primitive.ObjectID][]string{}}
+}
+
+func (r *recordingSockets) record(message string, ids []primitive.ObjectID) {
+	var envelope Envelope
+	_ = json.Unmarshal([]byte(message), &envelope)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, id := range ids {
+		r.messages[id] = append(r.messages[id], envelope.Type)
+	}
+}
+
+func (r *recordingSockets) BroadcastToRiders(message string, ids []primitive.ObjectID) {
+	r.record(message, ids)
+}
+
+func (r *recordingSockets) BroadcastToUsers(message string, ids []primitive.ObjectID) {
+	r.record(message, ids)
+}
+
+func (r *recordingSockets) BroadcastToRestaurants(message string, ids []primitive.ObjectID) {
+	r.record(message, ids)
+}
+
+func (r *recordingSockets) BroadcastToOrderStreams(message string, ids []primitive.ObjectID) {
+	r.record(message, ids)
+}
+
+// sent types of the messages sent to the id
+func (r *recordingSockets) sent(id primitive.ObjectID) []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.messages[id]...)
+}
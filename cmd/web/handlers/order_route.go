@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// GetOrderRouteRequest route travelled by the rider for an order
+type GetOrderRouteRequest struct {
+	Id        primitive.ObjectID `query:"id" validate:"required"`
+	Principal auth.Principal     `json:"-"` // from the token
+}
+
+// ReplayOrderRequest replay of an order for support, speed 10 plays the order 10 times faster
+type ReplayOrderRequest struct {
+	Id    primitive.ObjectID `query:"id" validate:"required"`
+	Speed float64            `query:"speed" validate:"min=0,max=1000"`
+}
+
+// OrderRouteResponse route of the rider from assignment till delivery
+type OrderRouteResponse struct {
+	OrderId       primitive.ObjectID    `json:"order_id"`
+	Polyline      string                `json:"polyline"`       // google encoded polyline
+	TotalDistance float64               `json:"total_distance"` // in km
+	StartedAt     time.Time             `json:"started_at"`
+	EndedAt       time.Time             `json:"ended_at"`
+	Points        []model.LocationPoint `json:"points"`
+}
+
+// ReplayFrame a single location update or status change, offset is when to play it relative to the first frame
+type ReplayFrame struct {
+	Offset    int64     `json:"offset"` // in milliseconds, scaled by speed
+	Type      string    `json:"type"`   // LOCATION or STATUS
+	Status    string    `json:"status,omitempty"`
+	Latitude  float64   `json:"latitude,omitempty"`
+	Longitude float64   `json:"longitude,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// OrderReplayResponse timeline of the order
+type OrderReplayResponse struct {
+	OrderId primitive.ObjectID `json:"order_id"`
+	Speed   float64            `json:"speed"`
+	Frames  []ReplayFrame      `json:"frames"`
+}
+
+// handleRiderLocation keeps the location trail of a rider on a trip, sends the location to the users tracking
+// the orders on the trip and checks for arrival at stops
+func handleRiderLocation(ctx context.Context, rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, req LocationSyncReq) {
+	if or.TripRepo == nil {
+		return
+	}
+	latitude, err := strconv.ParseFloat(req.Latitude, 64)
+	if err != nil {
+		return
+	}
+	longitude, err := strconv.ParseFloat(req.Longitude, 64)
+	if err != nil {
+		return
+	}
+
+	// todo cache the trip, this is a db call for every location update of a rider on a trip
+	trip, err := or.TripRepo.GetActiveTrip(ctx, riderId)
+	if err != nil {
+		return
+	}
+
+	if or.LocationRepo != nil {
+		point := model.LocationPoint{
+			RiderId:    riderId,
+			TripId:     trip.Id,
+			Location:   model.NewLocationFromLongLat(longitude, latitude),
+			RecordedAt: time.Now(),
+		}
+		if err := or.LocationRepo.AddLocationPoint(ctx, point); err != nil {
+			slog.ErrorContext(ctx, "error saving rider location", "error", err.Error())
+		}
+	}
+
+	for _, stop := range trip.Stops {
+		if stop.Type == "DROP" && stop.Status != "COMPLETED" {
+			handleSendingLocation(rm, stop.OrderId, req)
+		}
+	}
+
+	handleGeofence(ctx, rm, or, trip, latitude, longitude)
+}
+
+// getOrderTrail location points of the rider while carrying the order
+func getOrderTrail(ctx context.Context, param OrderParam, order model.Order) ([]model.LocationPoint, error) {
+	if order.TripId.IsZero() {
+		return nil, errors.Join(custom_errors.PreconditionError, errors.New("order has no rider assigned"))
+	}
+	return param.LocationRepo.SearchLocationPoints(ctx, model.SearchLocationQuery{
+		TripId: order.TripId,
+		From:   order.DeliveryStarted,
+		To:     order.DeliveredAt,
+	})
+}
+
+// canViewOrder the user who placed the order, its rider, its restaurant and the staff of it, and support
+func canViewOrder(principal auth.Principal, order model.Order) bool {
+	switch {
+	case principal.Can(auth.PermAdminView):
+		return true
+	case principal.Type == auth.UserPrincipal:
+		return principal.Id == order.UserId
+	case principal.Type == auth.RiderPrincipal:
+		return principal.Id == order.RiderId
+	default:
+		return !order.RestaurantId.IsZero() && principal.ActingRestaurant() == order.RestaurantId
+	}
+}
+
+// GetOrderRoute route polyline and distance travelled for an order
+func (request *GetOrderRouteRequest) GetOrderRoute(ctx context.Context, param OrderParam) (OrderRouteResponse, error) {
+	order, err := param.OrderRepo.GetOrder(ctx, request.Id)
+	if err != nil {
+		return OrderRouteResponse{}, err
+	}
+	// the trail leads to the door of the user, other orders look like they do not exist
+	if !canViewOrder(request.Principal, order) {
+		return OrderRouteResponse{}, errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+	}
+	points, err := getOrderTrail(ctx, param, order)
+	if err != nil {
+		return OrderRouteResponse{}, err
+	}
+
+	locations := make([]model.Location, 0, len(points))
+	totalDistance := float64(0)
+	for i, point := range points {
+		locations = append(locations, point.Location)
+		if i > 0 {
+			previous := points[i-1].Location
+			totalDistance += distanceBetweenPoints(previous.GetLatitude(), previous.GetLongitude(), point.Location.GetLatitude(), point.Location.GetLongitude())
+		}
+	}
+
+	return OrderRouteResponse{
+		OrderId:       order.Id,
+		Polyline:      model.EncodePolyline(locations),
+		TotalDistance: totalDistance,
+		StartedAt:     order.DeliveryStarted,
+		EndedAt:       order.DeliveredAt,
+		Points:        points,
+	}, nil
+}
+
+// ReplayOrder timeline of status changes and rider locations of an order, used by support to investigate complaints
+func (request *ReplayOrderRequest) ReplayOrder(ctx context.Context, param OrderParam) (OrderReplayResponse, error) {
+	order, err := param.OrderRepo.GetOrder(ctx, request.Id)
+	if err != nil {
+		return OrderReplayResponse{}, err
+	}
+
+	speed := request.Speed
+	if speed == 0 {
+		speed = 1
+	}
+
+	var frames []ReplayFrame
+	statuses := []struct {
+		status string
+		at     time.Time
+	}{
+		{"CREATED", order.CreatedAt},
+		{"ACCEPTED", order.AcceptedAt},
+		{"RIDER_ASSIGNED", order.DeliveryStarted},
+		{"FOOD_READY", order.FoodReadyAt},
+		{"RIDER_ARRIVED", order.RiderArrivedAt},
+		{"PICKED_UP", order.PickedUpAt},
+		{"DELIVERED", order.DeliveredAt},
+	}
+	for _, status := range statuses {
+		if status.at.IsZero() {
+			continue
+		}
+		frames = append(frames, ReplayFrame{Type: "STATUS", Status: status.status, At: status.at})
+	}
+
+	if !order.TripId.IsZero() {
+		points, err := getOrderTrail(ctx, param, order)
+		if err != nil {
+			return OrderReplayResponse{}, err
+		}
+		for _, point := range points {
+			frames = append(frames, ReplayFrame{
+				Type:      "LOCATION",
+				Latitude:  point.Location.GetLatitude(),
+				Longitude: point.Location.GetLongitude(),
+				At:        point.RecordedAt,
+			})
+		}
+	}
+
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].At.Before(frames[j].At)
+	})
+	for i := range frames {
+		frames[i].Offset = int64(float64(frames[i].At.Sub(frames[0].At).Milliseconds()) / speed)
+	}
+
+	return OrderReplayResponse{
+		OrderId: order.Id,
+		Speed:   speed,
+		Frames:  frames,
+	}, nil
+}
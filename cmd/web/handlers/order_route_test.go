@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetOrderRoute_Ownership(t *testing.T) {
+	started := time.Now().Add(-time.Hour)
+	order := model.Order{
+		Id:              primitive.NewObjectID(),
+		UserId:          primitive.NewObjectID(),
+		RiderId:         primitive.NewObjectID(),
+		RestaurantId:    primitive.NewObjectID(),
+		TripId:          primitive.NewObjectID(),
+		DeliveryStarted: started,
+	}
+	param := OrderParam{
+		OrderRepo: newMemoryOrders(order),
+		LocationRepo: &memoryLocations{points: []model.LocationPoint{
+			{TripId: order.TripId, Location: model.NewLocationFromLongLat(restaurantLng, restaurantLat), RecordedAt: started},
+			{TripId: order.TripId, Location: model.NewLocationFromLongLat(restaurantLng, restaurantLat+0.009), RecordedAt: started.Add(time.Minute)},
+		}},
+	}
+
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		allowed   bool
+	}{
+		{"user who placed the order", auth.Principal{Id: order.UserId, Type: auth.UserPrincipal, Role: auth.RoleCustomer}, true},
+		{"rider of the order", auth.Principal{Id: order.RiderId, Type: auth.RiderPrincipal, Role: auth.RoleRider}, true},
+		{"restaurant of the order", auth.Principal{Id: order.RestaurantId, Type: auth.RestaurantPrincipal, Role: auth.RoleRestaurantOwner}, true},
+		{"staff of the restaurant", auth.Principal{Id: primitive.NewObjectID(), Type: auth.StaffPrincipal, Role: auth.RoleRestaurantManager, RestaurantId: order.RestaurantId}, true},
+		{"support agent", auth.Principal{Id: primitive.NewObjectID(), Type: auth.AdminPrincipal, Role: auth.RoleSupportAgent}, true},
+		{"another user", auth.Principal{Id: primitive.NewObjectID(), Type: auth.UserPrincipal, Role: auth.RoleCustomer}, false},
+		{"another rider", auth.Principal{Id: primitive.NewObjectID(), Type: auth.RiderPrincipal, Role: auth.RoleRider}, false},
+		{"another restaurant", auth.Principal{Id: primitive.NewObjectID(), Type: auth.RestaurantPrincipal, Role: auth.RoleRestaurantOwner}, false},
+		{"user with the id of the restaurant", auth.Principal{Id: order.RestaurantId, Type: auth.UserPrincipal, Role: auth.RoleCustomer}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := GetOrderRouteRequest{Id: order.Id, Principal: tt.principal}
+			route, err := req.GetOrderRoute(context.TODO(), param)
+			if !tt.allowed {
+				assert.ErrorIs(t, err, custom_errors.NotFoundError)
+				assert.Empty(t, route.Points)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, order.Id, route.OrderId)
+			assert.Len(t, route.Points, 2)
+			assert.InDelta(t, 1.0, route.TotalDistance, 0.01)
+		})
+	}
+}
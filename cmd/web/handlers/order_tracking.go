@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/db"
+	"food-eats/cmd/web/model"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const (
+	// watchers of an order are dropped a day after the last subscription
+	orderWatchersTTL = 24 * time.Hour
+	// share links stop working after this time
+	shareTokenTTL = 6 * time.Hour
+)
+
+// SubscribeOrderReq user subscribing to updates of an order, users other than the one who placed the order
+// need the token of a share link
+type SubscribeOrderReq struct {
+	OrderId    primitive.ObjectID `json:"order_id"`
+	ShareToken string             `json:"share_token"`
+}
+
+// UnsubscribeOrderReq user no longer interested in updates of an order
+type UnsubscribeOrderReq struct {
+	OrderId primitive.ObjectID `json:"order_id"`
+}
+
+// ShareOrderRequest user who placed the order creating a link for others to track it
+type ShareOrderRequest struct {
+	Id     primitive.ObjectID `query:"id" validate:"required"`
+	UserId primitive.ObjectID `validate:"required"` // from the token
+}
+
+// ShareOrderResponse token to subscribe to the order with
+type ShareOrderResponse struct {
+	OrderId   primitive.ObjectID `json:"order_id"`
+	Token     string             `json:"token"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
+func orderWatchersKey(orderId primitive.ObjectID) string {
+	return "order_watchers:" + orderId.Hex()
+}
+
+func shareTokenKey(token string) string {
+	return "order_share:" + token
+}
+
+// orderWatchers users subscribed to orders and the share links letting others subscribe
+type orderWatchers interface {
+	// Add user starts receiving status and location updates of the order
+	Add(ctx context.Context, orderId primitive.ObjectID, userId primitive.ObjectID) error
+	// Share stores the share link token of the order for the ttl
+	Share(ctx context.Context, token string, orderId primitive.ObjectID, ttl time.Duration) error
+	// SharedOrder order of the share link token, zero when the token is unknown or expired
+	SharedOrder(ctx context.Context, token string) (primitive.ObjectID, error)
+}
+
+// redisOrderWatchers watchers in a set per order, share tokens in keys expiring with the link
+type redisOrderWatchers struct {
+	conn redis.Cmdable
+}
+
+func (r redisOrderWatchers) Add(ctx context.Context, orderId primitive.ObjectID, userId primitive.ObjectID) error {
+	key := orderWatchersKey(orderId)
+	pipe := r.conn.Pipeline()
+	pipe.SAdd(ctx, key, userId.Hex())
+	pipe.Expire(ctx, key, orderWatchersTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r redisOrderWatchers) Share(ctx context.Context, token string, orderId primitive.ObjectID, ttl time.Duration) error {
+	return r.conn.Set(ctx, shareTokenKey(token), orderId.Hex(), ttl).Err()
+}
+
+func (r redisOrderWatchers) SharedOrder(ctx context.Context, token string) (primitive.ObjectID, error) {
+	sharedOrder, err := r.conn.Get(ctx, shareTokenKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return primitive.NilObjectID, nil
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	orderId, _ := primitive.ObjectIDFromHex(sharedOrder)
+	return orderId, nil
+}
+
+// addOrderWatcher user starts receiving status and location updates of the order
+func addOrderWatcher(ctx context.Context, redisConn redis.Cmdable, orderId primitive.ObjectID, userId primitive.ObjectID) error {
+	return redisOrderWatchers{conn: redisConn}.Add(ctx, orderId, userId)
+}
+
+// ShareOrder creates a share link token, anyone with the token can track the order until it expires
+func (request *ShareOrderRequest) ShareOrder(ctx context.Context, param OrderParam) (ShareOrderResponse, error) {
+	return request.share(ctx, param.OrderRepo, redisOrderWatchers{conn: param.RedisConn})
+}
+
+func (request *ShareOrderRequest) share(ctx context.Context, orderRepo model.OrderRepository, watchers orderWatchers) (ShareOrderResponse, error) {
+	order, err := orderRepo.GetOrder(ctx, request.Id)
+	if err != nil {
+		return ShareOrderResponse{}, err
+	}
+	if order.UserId != request.UserId {
+		return ShareOrderResponse{}, errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+	}
+	if order.Status == "DELIVERED" {
+		return ShareOrderResponse{}, errors.Join(custom_errors.ConflictError, errors.New("order already delivered"))
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ShareOrderResponse{}, err
+	}
+	token := hex.EncodeToString(b)
+	if err := watchers.Share(ctx, token, order.Id, shareTokenTTL); err != nil {
+		return ShareOrderResponse{}, err
+	}
+
+	return ShareOrderResponse{
+		OrderId:   order.Id,
+		Token:     token,
+		ExpiresAt: time.Now().Add(shareTokenTTL),
+	}, nil
+}
+
+// TrackOrderStreamRequest user tracking an order over server-sent events, the stream only carries the status and
+// location updates of that order
+type TrackOrderStreamRequest struct {
+	OrderId    primitive.ObjectID `query:"order_id" validate:"required"`
+	UserId     primitive.ObjectID `validate:"required"` // from the token
+	ShareToken string             `query:"share_token"`
+	LastSeq    string             `query:"last_seq"` // browsers send the Last-Event-ID header instead
+}
+
+// TrackedOrder checks the user may track the order. The stream is registered for the order itself, so the user is
+// not added to the watchers of the order and nothing is left behind once the stream closes.
+func (request *TrackOrderStreamRequest) TrackedOrder(ctx context.Context, param OrderParam) (model.Order, error) {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return model.Order{}, err
+	}
+	defer db.Close(redisConn)
+
+	return trackableOrder(ctx, param.OrderRepo, redisOrderWatchers{conn: redisConn}, request.UserId, SubscribeOrderReq{OrderId: request.OrderId, ShareToken: request.ShareToken})
+}
+
+// SendOrderStatus current status of the order, sent on the stream once it is open
+func (request *TrackOrderStreamRequest) SendOrderStatus(client *model.WebSocketClient, order model.Order) {
+	msg, err := newEnvelope(OrderStatusMessage, "", trackingStatus(order))
+	if err != nil {
+		slog.Error("error creating websocket message", "type", OrderStatusMessage, "err", err)
+		return
+	}
+	client.Send(msg)
+}
+
+// subscribeOrder subscribes the user to the order, users other than the one who placed the order need a valid share token
+func subscribeOrder(ctx context.Context, or OrderParam, userId primitive.ObjectID, req SubscribeOrderReq) (model.Order, error) {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return model.Order{}, err
+	}
+	defer db.Close(redisConn)
+
+	return watchOrder(ctx, or.OrderRepo, redisOrderWatchers{conn: redisConn}, userId, req)
+}
+
+// watchOrder adds the user as watcher of the order
+func watchOrder(ctx context.Context, orderRepo model.OrderRepository, watchers orderWatchers, userId primitive.ObjectID, req SubscribeOrderReq) (model.Order, error) {
+	order, err := trackableOrder(ctx, orderRepo, watchers, userId, req)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if err := watchers.Add(ctx, order.Id, userId); err != nil {
+		return model.Order{}, err
+	}
+	return order, nil
+}
+
+// trackableOrder the order when the user may track it, users other than the one who placed the order need a valid
+// share token and share links stop working once the order is delivered
+func trackableOrder(ctx context.Context, orderRepo model.OrderRepository, watchers orderWatchers, userId primitive.ObjectID, req SubscribeOrderReq) (model.Order, error) {
+	order, err := orderRepo.GetOrder(ctx, req.OrderId)
+	if err != nil {
+		return model.Order{}, err
+	}
+
+	if order.UserId != userId {
+		if req.ShareToken == "" || order.Status == "DELIVERED" {
+			return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("order can not be tracked"))
+		}
+		sharedOrder, err := watchers.SharedOrder(ctx, req.ShareToken)
+		if err != nil {
+			return model.Order{}, err
+		}
+		if sharedOrder != order.Id {
+			return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("order can not be tracked"))
+		}
+	}
+	return order, nil
+}
+
+func trackingStatus(order model.Order) OrderStatusInfo {
+	return OrderStatusInfo{
+		Message: "Tracking order",
+		OrderId: order.Id,
+		Status:  order.Status,
+		At:      order.UpdatedAt,
+	}
+}
+
+func sendTrackingStatus(rm model.WebSocketManager, userId primitive.ObjectID, order model.Order) {
+	sendToUsers(rm, OrderStatusMessage, trackingStatus(order), userId)
+}
+
+// handleSubscribeOrder subscribes the user to the order and sends the current status of the order
+func handleSubscribeOrder(ctx context.Context, rm model.WebSocketManager, or OrderParam, userId primitive.ObjectID, req SubscribeOrderReq) error {
+	if req.OrderId.IsZero() {
+		return newMessageError(ErrCodeInvalidPayload, "order_id is required")
+	}
+	order, err := subscribeOrder(ctx, or, userId, req)
+	if err != nil {
+		if errors.Is(err, custom_errors.ClientError) {
+			return newMessageError(ErrCodeForbidden, "order can not be tracked")
+		}
+		return err
+	}
+	sendTrackingStatus(rm, userId, order)
+	return nil
+}
+
+// handleUnsubscribeOrder user stops receiving updates of the order
+func handleUnsubscribeOrder(ctx context.Context, userId primitive.ObjectID, req UnsubscribeOrderReq) error {
+	if req.OrderId.IsZero() {
+		return newMessageError(ErrCodeInvalidPayload, "order_id is required")
+	}
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	return redisConn.SRem(ctx, orderWatchersKey(req.OrderId), userId.Hex()).Err()
+}
+
+// sendToOrderWatchers sends a status or location update of the order to the streams tracking it and every user
+// subscribed to it, the users are sent the update as well, once even when they also watch the order
+func sendToOrderWatchers(rm model.WebSocketManager, orderId primitive.ObjectID, msgType string, payload interface{}, users ...primitive.ObjectID) {
+	deliver(rm, orderRecipient, msgType, payload, []primitive.ObjectID{orderId})
+
+	userIds := append([]primitive.ObjectID(nil), users...)
+	for _, userId := range orderWatcherIds(orderId) {
+		if !slices.Contains(userIds, userId) {
+			userIds = append(userIds, userId)
+		}
+	}
+	sendToUsers(rm, msgType, payload, userIds...)
+}
+
+// orderWatcherIds users subscribed to the order, none when they can not be read
+func orderWatcherIds(orderId primitive.ObjectID) []primitive.ObjectID {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		slog.Error("error getting redis connection", "error", err.Error())
+		return nil
+	}
+	defer db.Close(redisConn)
+
+	members, err := redisConn.SMembers(context.Background(), orderWatchersKey(orderId)).Result()
+	if err != nil {
+		slog.Error("error fetching order watchers", "order_id", orderId.Hex(), "error", err.Error())
+		return nil
+	}
+	var userIds []primitive.ObjectID
+	for _, member := range members {
+		userId, err := primitive.ObjectIDFromHex(member)
+		if err != nil {
+			continue
+		}
+		userIds = append(userIds, userId)
+	}
+	return userIds
+}
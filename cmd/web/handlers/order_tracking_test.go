@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type sharedOrder struct {
+	orderId   primitive.ObjectID
+	expiresAt time.Time
+}
+
+// memoryWatchers order watchers and share tokens in memory
+type memoryWatchers struct {
+	watchers map[primitive.ObjectID][]primitive.ObjectID
+	shares   map[string]sharedOrder
+}
+
+func newMemoryWatchers() *memoryWatchers {
+	return &memoryWatchers{watchers: map[primitive.ObjectID][]primitive.ObjectID{}, shares: map[string]sharedOrder{}}
+}
+
+func (m *memoryWatchers) Add(_ context.Context, orderId primitive.ObjectID, userId primitive.ObjectID) error {
+	m.watchers[orderId] = append(m.watchers[orderId], userId)
+	return nil
+}
+
+func (m *memoryWatchers) Share(_ context.Context, token string, orderId primitive.ObjectID, ttl time.Duration) error {
+	m.shares[token] = sharedOrder{orderId: orderId, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryWatchers) SharedOrder(_ context.Context, token string) (primitive.ObjectID, error) {
+	share, ok := m.shares[token]
+	if !ok || share.expiresAt.Before(time.Now()) {
+		return primitive.NilObjectID, nil
+	}
+	return share.orderId, nil
+}
+
+func TestShareOrder(t *testing.T) {
+	userId := primitive.NewObjectID()
+	order := model.Order{Id: primitive.NewObjectID(), UserId: userId, Status: "PICKED_UP"}
+	delivered := model.Order{Id: primitive.NewObjectID(), UserId: userId, Status: "DELIVERED"}
+	orders := newMemoryOrders(order, delivered)
+
+	tests := []struct {
+		name   string
+		req    ShareOrderRequest
+		errIs  error
+		shared bool
+	}{
+		{"user who placed the order", ShareOrderRequest{Id: order.Id, UserId: userId}, nil, true},
+		{"another user", ShareOrderRequest{Id: order.Id, UserId: primitive.NewObjectID()}, custom_errors.NotFoundError, false},
+		{"delivered order", ShareOrderRequest{Id: delivered.Id, UserId: userId}, custom_errors.ConflictError, false},
+		{"unknown order", ShareOrderRequest{Id: primitive.NewObjectID(), UserId: userId}, custom_errors.NotFoundError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watchers := newMemoryWatchers()
+			response, err := tt.req.share(context.TODO(), orders, watchers)
+			if tt.errIs != nil {
+				assert.ErrorIs(t, err, tt.errIs)
+			} else {
+				assert.NoError(t, err)
+			}
+			if !tt.shared {
+				assert.Empty(t, watchers.shares)
+				return
+			}
+			assert.Len(t, response.Token, 32)
+			assert.Equal(t, order.Id, response.OrderId)
+			assert.WithinDuration(t, time.Now().Add(shareTokenTTL), response.ExpiresAt, time.Second)
+			assert.Equal(t, order.Id, watchers.shares[response.Token].orderId)
+		})
+	}
+}
+
+func TestWatchOrder(t *testing.T) {
+	userId := primitive.NewObjectID()
+	order := model.Order{Id: primitive.NewObjectID(), UserId: userId, Status: "PICKED_UP"}
+	other := model.Order{Id: primitive.NewObjectID(), UserId: userId, Status: "PICKED_UP"}
+	delivered := model.Order{Id: primitive.NewObjectID(), UserId: userId, Status: "DELIVERED"}
+	orders := newMemoryOrders(order, other, delivered)
+	familyId := primitive.NewObjectID()
+
+	// tokens as created by ShareOrder, one has run out
+	shares := map[string]sharedOrder{
+		"order-token":     {orderId: order.Id, expiresAt: time.Now().Add(shareTokenTTL)},
+		"other-token":     {orderId: other.Id, expiresAt: time.Now().Add(shareTokenTTL)},
+		"delivered-token": {orderId: delivered.Id, expiresAt: time.Now().Add(shareTokenTTL)},
+		"expired-token":   {orderId: order.Id, expiresAt: time.Now().Add(-time.Minute)},
+	}
+
+	tests := []struct {
+		name    string
+		userId  primitive.ObjectID
+		req     SubscribeOrderReq
+		errIs   error
+		watched bool
+	}{
+		{"user who placed the order", userId, SubscribeOrderReq{OrderId: order.Id}, nil, true},
+		{"user who placed a delivered order", userId, SubscribeOrderReq{OrderId: delivered.Id}, nil, true},
+		{"valid token", familyId, SubscribeOrderReq{OrderId: order.Id, ShareToken: "order-token"}, nil, true},
+		{"no token", familyId, SubscribeOrderReq{OrderId: order.Id}, custom_errors.PreconditionError, false},
+		{"token of a different order", familyId, SubscribeOrderReq{OrderId: order.Id, ShareToken: "other-token"}, custom_errors.PreconditionError, false},
+		{"expired token", familyId, SubscribeOrderReq{OrderId: order.Id, ShareToken: "expired-token"}, custom_errors.PreconditionError, false},
+		{"unknown token", familyId, SubscribeOrderReq{OrderId: order.Id, ShareToken: "guessed"}, custom_errors.PreconditionError, false},
+		{"token of a delivered order", familyId, SubscribeOrderReq{OrderId: delivered.Id, ShareToken: "delivered-token"}, custom_errors.PreconditionError, false},
+		{"unknown order", userId, SubscribeOrderReq{OrderId: primitive.NewObjectID()}, custom_errors.NotFoundError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watchers := newMemoryWatchers()
+			watchers.shares = shares
+			watched, err := watchOrder(context.TODO(), orders, watchers, tt.userId, tt.req)
+			if tt.errIs != nil {
+				assert.ErrorIs(t, err, tt.errIs)
+			} else {
+				assert.NoError(t, err)
+			}
+			if !tt.watched {
+				assert.Empty(t, watchers.watchers)
+				return
+			}
+			assert.Equal(t, tt.req.OrderId, watched.Id)
+			assert.Equal(t, []primitive.ObjectID{tt.userId}, watchers.watchers[tt.req.OrderId])
+		})
+	}
+}
+
+func TestTrackableOrder(t *testing.T) {
+	order := model.Order{Id: primitive.NewObjectID(), UserId: primitive.NewObjectID(), Status: "PICKED_UP"}
+	watchers := newMemoryWatchers()
+	watchers.shares["order-token"] = sharedOrder{orderId: order.Id, expiresAt: time.Now().Add(shareTokenTTL)}
+
+	// a stream checks the token without subscribing the user, nothing is left behind when it closes
+	tracked, err := trackableOrder(context.TODO(), newMemoryOrders(order), watchers, primitive.NewObjectID(), SubscribeOrderReq{OrderId: order.Id, ShareToken: "order-token"})
+	assert.NoError(t, err)
+	assert.Equal(t, order.Id, tracked.Id)
+	assert.Empty(t, watchers.watchers)
+}
+
+func TestSendToOrderWatchers_OrderStreams(t *testing.T) {
+	orderId, otherOrderId := primitive.NewObjectID(), primitive.NewObjectID()
+	sockets := newRecordingSockets()
+
+	sendToOrderWatchers(sockets, orderId, LocationMessage, LocationSyncReq{})
+	assert.Equal(t, []string{LocationMessage}, sockets.sent(orderId))
+	assert.Empty(t, sockets.sent(otherOrderId))
+}
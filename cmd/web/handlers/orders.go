@@ -2,20 +2,20 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"food-eats/cmd/web/custom-errors"
 	"food-eats/cmd/web/model"
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
 	"time"
 )
 
 // CreateOrderRequest a type for creating a order request
 type CreateOrderRequest struct {
-	UserId       primitive.ObjectID `json:"user_id"`
-	RestaurantId primitive.ObjectID `json:"restaurant_id"`
-	Items        []model.Item       `json:"items"`
+	UserId       primitive.ObjectID `json:"-"` // from the token
+	RestaurantId primitive.ObjectID `json:"restaurant_id" validate:"objectid"`
+	Items        []model.Item       `json:"items" validate:"required,min=1,dive"`
 }
 
 // UpdateOrderRequest a type for updaing a order request
@@ -39,7 +39,7 @@ type GetOrderRequest struct {
 
 // GetPendingRestaurantOrder get pending order for the restaurant
 type GetPendingRestaurantOrder struct {
-	Id      primitive.ObjectID `query:"id" validate:"required"` // required a mongodb objectId
+	Id      primitive.ObjectID `validate:"required"` // restaurant, from the token
 	Limit   int                `query:"limit" validate:"min=10,max=20"`
 	PageNum int                `query:"page_num" validate:"min=1"`
 }
@@ -47,15 +47,24 @@ type GetPendingRestaurantOrder struct {
 // AcceptPendingRestaurantOrder accept pending order for the restaurant
 type AcceptPendingRestaurantOrder struct {
 	Id           primitive.ObjectID `json:"id" validate:"required"`
-	RestaurantId primitive.ObjectID `json:"restaurant_id" validate:"required"`
+	RestaurantId primitive.ObjectID `json:"-" validate:"required"`              // from the token
+	PrepTime     int                `json:"prep_time" validate:"min=0,max=180"` // optional estimate in minutes
+}
+
+// CancelOwnOrderRequest user cancelling an order the restaurant has not accepted yet
+type CancelOwnOrderRequest struct {
+	Id     primitive.ObjectID `json:"id" validate:"required"`
+	Reason string             `json:"reason"`
+	UserId primitive.ObjectID `json:"-" validate:"required"` // from the token
 }
 
+// SearchOrderRequest orders of the user, rider or restaurant of the token
 type SearchOrderRequest struct {
-	Id      primitive.ObjectID `json:"id" validate:"required"`
-	Type    string             `json:"type" validate:"required"`
-	Limit   int                `json:"limit" `
-	PageNum int                `json:"page_num"`
-	Status  string             `json:"status"`
+	Id      primitive.ObjectID `json:"-" validate:"required"`
+	Type    string             `json:"-" validate:"required"`
+	Limit   int                `json:"limit" validate:"omitempty,min=1,max=100"`
+	PageNum int                `json:"page_num" validate:"omitempty,min=1"` // first page when not set
+	Status  string             `json:"status" validate:"omitempty,oneof=CREATED ACCEPTED FOOD_READY RIDER_ASSIGNED RIDER_ARRIVED PICKED_UP DELIVERED CANCELLED"`
 }
 
 func (request SearchOrderRequest) SearchOrder(ctx context.Context, repo OrderParam) (*model.PageResponse[model.Order], error) {
@@ -67,6 +76,9 @@ func (request SearchOrderRequest) SearchOrder(ctx context.Context, repo OrderPar
 	} else {
 		query.DriverId = request.Id
 	}
+	if request.PageNum == 0 {
+		request.PageNum = 1
+	}
 	skip := (request.PageNum - 1) * request.Limit
 
 	query.Status = request.Status
@@ -92,6 +104,7 @@ type GetNewDeliveryOrder struct {
 type NewOrderBroadCast struct {
 	Message string             `json:"message"`
 	OrderId primitive.ObjectID `json:"order_id"`
+	TripId  primitive.ObjectID `json:"trip_id,omitempty"` // set when offered as a batch on an existing trip
 }
 
 // OrderParam order param
@@ -100,9 +113,12 @@ type OrderParam struct {
 	UserRepo       model.UserRepository
 	RestaurantRepo model.RestaurantRepository
 	RiderRepo      model.RiderRepository
-	SM             *model.SocketManager
+	TripRepo       model.TripRepository
+	LocationRepo   model.LocationHistoryRepository
+	SM             model.WebSocketManager
+	Geofence       GeofenceConfig
 
-	RedisConn *redis.Conn
+	RedisConn redis.Cmdable
 }
 
 // CreateOrder register new order
@@ -119,8 +135,12 @@ func (request *CreateOrderRequest) CreateOrder(ctx context.Context, param OrderP
 		return model.Order{}, err
 	}
 
+	if user.Status != "ACTIVE" {
+		return model.Order{}, errors.Join(custom_errors.ForbiddenError, errors.New("user can not place orders"))
+	}
+
 	if restaurant.Status != "ACTIVE" {
-		return model.Order{}, errors.Join(custom_errors.ClientError, errors.New("restaurant not serving"))
+		return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("restaurant not serving"))
 	}
 
 	finalPrice := float32(0)
@@ -141,8 +161,8 @@ func (request *CreateOrderRequest) CreateOrder(ctx context.Context, param OrderP
 		DeliveryLongitude: user.Location.GetLongitude(),
 		DeliveryAddress:   user.Address,
 
-		PickupLatitude:  user.Location.GetLatitude(),
-		PickupLongitude: user.Location.GetLongitude(),
+		PickupLatitude:  restaurant.Location.GetLatitude(),
+		PickupLongitude: restaurant.Location.GetLongitude(),
 		PickupAddress:   restaurant.Address,
 	}
 
@@ -151,6 +171,12 @@ func (request *CreateOrderRequest) CreateOrder(ctx context.Context, param OrderP
 		return model.Order{}, err
 	}
 
+	// user placing the order tracks it by default
+	if err := addOrderWatcher(ctx, param.RedisConn, createdRecord.Id, user.Id); err != nil {
+		slog.ErrorContext(ctx, "error subscribing user to order", "error", err.Error())
+	}
+	sendOrderToRestaurant(param.SM, OrderPlacedMessage, createdRecord, "New order")
+
 	return createdRecord, nil
 }
 
@@ -224,6 +250,9 @@ func (oa *AcceptPendingRestaurantOrder) AcceptOrder(ctx context.Context, param O
 	if err != nil {
 		return err
 	}
+	if order.RestaurantId != oa.RestaurantId {
+		return errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+	}
 
 	restaurant, err := param.RestaurantRepo.GetRestaurant(ctx, oa.RestaurantId)
 	if err != nil {
@@ -234,14 +263,18 @@ func (oa *AcceptPendingRestaurantOrder) AcceptOrder(ctx context.Context, param O
 	// use INCR
 
 	// Check if the order is already accepted
-	if order.Status == "ACCEPTED" {
-		return errors.New("order is already accepted")
+	if order.Status != "CREATED" {
+		return errors.Join(custom_errors.ConflictError, errors.New("order is already "+order.Status))
 	}
 
 	// Update the order status to "ACCEPTED"
 	order.Status = "ACCEPTED"
 	order.AcceptedAt = time.Now()
-	if err := param.OrderRepo.UpdateOrder(ctx, order); err != nil {
+	if oa.PrepTime > 0 {
+		order.EstimatedReadyAt = order.AcceptedAt.Add(time.Duration(oa.PrepTime) * time.Minute)
+	}
+	// a user cancelling at the same time wins or loses as a whole
+	if err := param.OrderRepo.UpdateOrderFrom(ctx, order, "CREATED"); err != nil {
 		return err
 	}
 
@@ -256,12 +289,32 @@ func (oa *AcceptPendingRestaurantOrder) AcceptOrder(ctx context.Context, param O
 		Longitude: searchRider.Longitude,
 		Limit:     searchRider.Limit,
 	})
+	if err != nil {
+		return err
+	}
+
+	// riders already on a trip are only offered the order as a batch
+	var nearbyRiders []primitive.ObjectID
+	for _, rider := range riders {
+		nearbyRiders = append(nearbyRiders, rider.Id)
+	}
+	busyTrips, err := param.TripRepo.SearchActiveTrips(ctx, model.SearchTripQuery{RiderIds: nearbyRiders})
+	if err != nil {
+		return err
+	}
+	busyRiders := map[primitive.ObjectID]bool{}
+	for _, trip := range busyTrips {
+		busyRiders[trip.RiderId] = true
+	}
 
 	// ws riders
 	var ridersSelected []primitive.ObjectID
-	for _, rider := range riders {
-		// broadcast to SM
-		rId := rider.Id
+	var freeRiders []primitive.ObjectID
+	for _, rId := range nearbyRiders {
+		if busyRiders[rId] {
+			continue
+		}
+		freeRiders = append(freeRiders, rId)
 		ridersSelected = append(ridersSelected, rId)
 	}
 
@@ -269,11 +322,21 @@ func (oa *AcceptPendingRestaurantOrder) AcceptOrder(ctx context.Context, param O
 		Message: "New order for pickup",
 		OrderId: order.Id,
 	}
-	msg, err := json.Marshal(&newOrder)
+	sendToRiders(param.SM, NewOrderMessage, newOrder, freeRiders...)
+
+	batchTrips, err := findBatchRiders(ctx, param, order)
 	if err != nil {
 		return err
 	}
-	param.SM.BroadcastToRiders(string(msg), ridersSelected)
+	for _, trip := range batchTrips {
+		batchOrder := NewOrderBroadCast{
+			Message: "Batch order for pickup",
+			OrderId: order.Id,
+			TripId:  trip.Id,
+		}
+		sendToRiders(param.SM, NewOrderMessage, batchOrder, trip.RiderId)
+		ridersSelected = append(ridersSelected, trip.RiderId)
+	}
 
 	for _, id := range ridersSelected {
 		_, err := param.RedisConn.SAdd(ctx, "rider_broadcasted:"+order.Id.Hex(), id.Hex()).Result()
@@ -289,3 +352,35 @@ func (oa *AcceptPendingRestaurantOrder) AcceptOrder(ctx context.Context, param O
 
 	return nil
 }
+
+// CancelOrder cancels an order of the user while the restaurant has not accepted it, no rider was offered the order
+// yet so only the restaurant and the users tracking the order are notified
+func (request *CancelOwnOrderRequest) CancelOrder(ctx context.Context, param OrderParam) (model.Order, error) {
+	order, err := param.OrderRepo.GetOrder(ctx, request.Id)
+	if err != nil {
+		return model.Order{}, err
+	}
+	if order.UserId != request.UserId {
+		return model.Order{}, errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+	}
+	if order.Status != "CREATED" {
+		return model.Order{}, errors.Join(custom_errors.PreconditionError, errors.New("order can not be cancelled once the restaurant accepted it"))
+	}
+
+	currTime := time.Now()
+	order.Status = "CANCELLED"
+	order.CancelledAt = currTime
+	order.CancelledBy = request.UserId
+	order.CancelReason = request.Reason
+	order.UpdatedAt = currTime
+	// the restaurant accepting at the same time wins or loses as a whole
+	if err := param.OrderRepo.UpdateOrderFrom(ctx, order, "CREATED"); err != nil {
+		return model.Order{}, err
+	}
+
+	sendOrderToRestaurant(param.SM, OrderCancelledMessage, order, "Order cancelled by the customer")
+	sendOrderStatus(param.SM, order, "Order cancelled", currTime)
+	slog.InfoContext(ctx, "order cancelled by the user", "order_id", order.Id.Hex(), "reason", request.Reason)
+
+	return order, nil
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCancelOwnOrder(t *testing.T) {
+	userId := primitive.NewObjectID()
+	placed := func(status string) model.Order {
+		return model.Order{Id: primitive.NewObjectID(), UserId: userId, RestaurantId: primitive.NewObjectID(), Status: status}
+	}
+
+	t.Run("cancelled before the restaurant accepts", func(t *testing.T) {
+		order := placed("CREATED")
+		orders := newMemoryOrders(order)
+		sockets := newRecordingSockets()
+		req := CancelOwnOrderRequest{Id: order.Id, UserId: userId, Reason: "ordered twice"}
+
+		cancelled, err := req.CancelOrder(context.TODO(), OrderParam{OrderRepo: orders, SM: sockets})
+		assert.NoError(t, err)
+		assert.Equal(t, "CANCELLED", cancelled.Status)
+		assert.Equal(t, userId, orders.orders[order.Id].CancelledBy)
+		assert.Equal(t, "ordered twice", orders.orders[order.Id].CancelReason)
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual([]string{OrderCancelledMessage}, sockets.sent(order.RestaurantId))
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	tests := []struct {
+		name   string
+		order  model.Order
+		userId primitive.ObjectID
+		errIs  error
+	}{
+		{"another user", placed("CREATED"), primitive.NewObjectID(), custom_errors.NotFoundError},
+		{"accepted by the restaurant", placed("ACCEPTED"), userId, custom_errors.PreconditionError},
+		{"rider assigned", placed("RIDER_ASSIGNED"), userId, custom_errors.PreconditionError},
+		{"already cancelled", placed("CANCELLED"), userId, custom_errors.PreconditionError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders := newMemoryOrders(tt.order)
+			req := CancelOwnOrderRequest{Id: tt.order.Id, UserId: tt.userId}
+
+			_, err := req.CancelOrder(context.TODO(), OrderParam{OrderRepo: orders, SM: newRecordingSockets()})
+			assert.ErrorIs(t, err, tt.errIs)
+			assert.Equal(t, tt.order.Status, orders.orders[tt.order.Id].Status)
+		})
+	}
+
+	t.Run("accepted while cancelling", func(t *testing.T) {
+		order := placed("CREATED")
+		orders := racedOrders(order, "ACCEPTED")
+		sockets := newRecordingSockets()
+		req := CancelOwnOrderRequest{Id: order.Id, UserId: userId}
+
+		_, err := req.CancelOrder(context.TODO(), OrderParam{OrderRepo: orders, SM: sockets})
+		assert.ErrorIs(t, err, custom_errors.ConflictError)
+		assert.Equal(t, "ACCEPTED", orders.orders[order.Id].Status)
+		assert.Empty(t, sockets.sent(order.RestaurantId))
+	})
+}
+
+// staleOrders orders read as they were before another request changed them
+type staleOrders struct {
+	*memoryOrders
+	read map[primitive.ObjectID]model.Order
+}
+
+func (s staleOrders) GetOrder(_ context.Context, id primitive.ObjectID) (model.Order, error) {
+	return s.read[id], nil
+}
+
+// racedOrders the order is read as it is but stored in the status another request moved it to
+func racedOrders(order model.Order, status string) staleOrders {
+	stored := order
+	stored.Status = status
+	return staleOrders{memoryOrders: newMemoryOrders(stored), read: map[primitive.ObjectID]model.Order{order.Id: order}}
+}
+
+func TestAcceptOrder_CancelledWhileAccepting(t *testing.T) {
+	restaurant := model.Restaurant{Id: primitive.NewObjectID()}
+	order := model.Order{Id: primitive.NewObjectID(), RestaurantId: restaurant.Id, Status: "CREATED"}
+	orders := racedOrders(order, "CANCELLED")
+	param := OrderParam{OrderRepo: orders, RestaurantRepo: newMemoryRestaurants(restaurant), SM: newRecordingSockets()}
+
+	req := AcceptPendingRestaurantOrder{Id: order.Id, RestaurantId: restaurant.Id}
+	err := req.AcceptOrder(context.TODO(), param)
+	assert.ErrorIs(t, err, custom_errors.ConflictError)
+	assert.Equal(t, "CANCELLED", orders.orders[order.Id].Status)
+	assert.True(t, orders.orders[order.Id].AcceptedAt.IsZero())
+}
+
+func TestAcceptOrder_NotCreated(t *testing.T) {
+	restaurant := model.Restaurant{Id: primitive.NewObjectID()}
+	order := model.Order{Id: primitive.NewObjectID(), RestaurantId: restaurant.Id, Status: "CANCELLED"}
+	param := OrderParam{OrderRepo: newMemoryOrders(order), RestaurantRepo: newMemoryRestaurants(restaurant), SM: newRecordingSockets()}
+
+	req := AcceptPendingRestaurantOrder{Id: order.Id, RestaurantId: restaurant.Id}
+	err := req.AcceptOrder(context.TODO(), param)
+	assert.ErrorIs(t, err, custom_errors.ConflictError)
+	assert.ErrorContains(t, err, "CANCELLED")
+}
+
+// failingRiderSearch riders which can not be searched
+type failingRiderSearch struct {
+	*memoryRiders
+}
+
+func (f failingRiderSearch) SearchRider(_ context.Context, _ model.SearchRiderQuery) ([]model.RiderSearchResponse, error) {
+	return nil, errors.Join(custom_errors.ServerError, errors.New("search failed"))
+}
+
+func TestAcceptOrder_RiderSearchFails(t *testing.T) {
+	restaurant := model.Restaurant{Id: primitive.NewObjectID()}
+	order := model.Order{Id: primitive.NewObjectID(), RestaurantId: restaurant.Id, Status: "CREATED"}
+	param := OrderParam{
+		OrderRepo:      newMemoryOrders(order),
+		RestaurantRepo: newMemoryRestaurants(restaurant),
+		RiderRepo:      failingRiderSearch{newMemoryRiders()},
+		SM:             newRecordingSockets(),
+	}
+
+	req := AcceptPendingRestaurantOrder{Id: order.Id, RestaurantId: restaurant.Id}
+	err := req.AcceptOrder(context.TODO(), param)
+	assert.ErrorIs(t, err, custom_errors.ServerError)
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/notify"
+	"github.com/redis/go-redis/v9"
+	"math/big"
+	"time"
+)
+
+const (
+	// otp expires after this time
+	otpTTL = 5 * time.Minute
+	// wrong otp allowed before the otp is dropped and a new one has to be requested
+	maxOtpAttempts = 5
+	// a new otp can be requested only after this time
+	otpResendCooldown = 30 * time.Second
+)
+
+// otp purposes, an otp sent for one can not be used for another
+const (
+	loginOtp       = "login"
+	verifyPhoneOtp = "verify_phone"
+)
+
+func otpKey(purpose string, principalType string, phoneNumber string) string {
+	return "otp:" + purpose + ":" + principalType + ":" + phoneNumber
+}
+
+func otpCooldownKey(purpose string, principalType string, phoneNumber string) string {
+	return "otp_cooldown:" + purpose + ":" + principalType + ":" + phoneNumber
+}
+
+// generateOtp random 6-digit otp
+func generateOtp() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// otpStore otps and resend cooldowns of phone numbers
+type otpStore interface {
+	// StartCooldown starts the cooldown of the key, false with the time left while the last one is still running
+	StartCooldown(ctx context.Context, key string, cooldown time.Duration) (bool, time.Duration, error)
+	// Save replaces the otp of the key, with no attempts made yet
+	Save(ctx context.Context, key string, otp string, ttl time.Duration) error
+	// Attempt counts an attempt on the otp of the key, returns the otp and the attempts made including this one,
+	// redis.Nil when there is no otp or it expired
+	Attempt(ctx context.Context, key string) (string, int64, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// redisOtpStore otp in a hash with the attempts, cooldowns in keys expiring with the cooldown
+type redisOtpStore struct {
+	conn redis.Cmdable
+}
+
+func (r redisOtpStore) StartCooldown(ctx context.Context, key string, cooldown time.Duration) (bool, time.Duration, error) {
+	ok, err := r.conn.SetNX(ctx, key, 1, cooldown).Result()
+	if err != nil || ok {
+		return ok, 0, err
+	}
+	wait, _ := r.conn.TTL(ctx, key).Result()
+	return false, wait, nil
+}
+
+func (r redisOtpStore) Save(ctx context.Context, key string, otp string, ttl time.Duration) error {
+	pipe := r.conn.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, "otp", otp, "attempts", 0)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r redisOtpStore) Attempt(ctx context.Context, key string) (string, int64, error) {
+	// counted before comparing, so concurrent guesses can not exceed the limit
+	pipe := r.conn.TxPipeline()
+	attemptsCmd := pipe.HIncrBy(ctx, key, "attempts", 1)
+	storedCmd := pipe.HGet(ctx, key, "otp")
+	if _, err := pipe.Exec(ctx); err != nil {
+		if errors.Is(err, redis.Nil) {
+			// dropping the attempts counter created on the expired otp
+			r.conn.Del(ctx, key)
+		}
+		return "", 0, err
+	}
+	return storedCmd.Val(), attemptsCmd.Val(), nil
+}
+
+func (r redisOtpStore) Delete(ctx context.Context, key string) error {
+	return r.conn.Del(ctx, key).Err()
+}
+
+// issueOtp sends a new otp to the phone number, replacing an earlier one.
+// Requests within the cooldown of the last otp are rejected so numbers can not be flooded.
+func issueOtp(ctx context.Context, store otpStore, sender notify.SMSSender, purpose string, principalType string, phoneNumber string) error {
+	ok, wait, err := store.StartCooldown(ctx, otpCooldownKey(purpose, principalType, phoneNumber), otpResendCooldown)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.Join(custom_errors.ClientError, fmt.Errorf("otp already sent, retry in %d seconds", int(wait.Seconds())))
+	}
+
+	otp, err := generateOtp()
+	if err != nil {
+		return err
+	}
+	if err := store.Save(ctx, otpKey(purpose, principalType, phoneNumber), otp, otpTTL); err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("%s is your food eats otp, valid for %d minutes. Do not share it with anyone.", otp, int(otpTTL.Minutes()))
+	return sender.Send(ctx, phoneNumber, message)
+}
+
+// verifyOtp checks the otp sent to the phone number, an otp can be used only once.
+// Every wrong otp counts as an attempt, the otp is dropped after maxOtpAttempts.
+func verifyOtp(ctx context.Context, store otpStore, purpose string, principalType string, phoneNumber string, otp string) error {
+	key := otpKey(purpose, principalType, phoneNumber)
+
+	stored, attempts, err := store.Attempt(ctx, key)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return errors.Join(custom_errors.ClientError, errors.New("otp expired, request a new one"))
+		}
+		return err
+	}
+	if attempts > maxOtpAttempts {
+		store.Delete(ctx, key)
+		return errors.Join(custom_errors.ClientError, errors.New("too many attempts, request a new otp"))
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(otp)) != 1 {
+		return errors.Join(custom_errors.ClientError, fmt.Errorf("invalid otp, %d attempts left", maxOtpAttempts-attempts))
+	}
+
+	return store.Delete(ctx, key)
+}
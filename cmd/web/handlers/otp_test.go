@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/notify"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type storedOtp struct {
+	otp       string
+	attempts  int64
+	expiresAt time.Time
+}
+
+// memoryOtps otps and cooldowns in memory, expiring like the redis keys
+type memoryOtps struct {
+	otps      map[string]*storedOtp
+	cooldowns map[string]time.Time
+}
+
+func newMemoryOtps() *memoryOtps {
+	return &memoryOtps{otps: map[string]*storedOtp{}, cooldowns: map[string]time.Time{}}
+}
+
+func (m *memoryOtps) StartCooldown(_ context.Context, key string, cooldown time.Duration) (bool, time.Duration, error) {
+	if until, ok := m.cooldowns[key]; ok && until.After(time.Now()) {
+		return false, time.Until(until), nil
+	}
+	m.cooldowns[key] = time.Now().Add(cooldown)
+	return true, 0, nil
+}
+
+func (m *memoryOtps) Save(_ context.Context, key string, otp string, ttl time.Duration) error {
+	m.otps[key] = &storedOtp{otp: otp, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (m *memoryOtps) Attempt(_ context.Context, key string) (string, int64, error) {
+	stored, ok := m.otps[key]
+	if !ok || stored.expiresAt.Before(time.Now()) {
+		delete(m.otps, key)
+		return "", 0, redis.Nil
+	}
+	stored.attempts++
+	return stored.otp, stored.attempts, nil
+}
+
+func (m *memoryOtps) Delete(_ context.Context, key string) error {
+	delete(m.otps, key)
+	return nil
+}
+
+const otpPhone = "+919999999999"
+
+// sentOtp otp of the last message sent to the phone number
+func sentOtp(t *testing.T, sender *notify.InMemorySMSSender) string {
+	messages := sender.Messages(otpPhone)
+	if !assert.NotEmpty(t, messages) {
+		return ""
+	}
+	return strings.Fields(messages[len(messages)-1])[0]
+}
+
+func TestIssueOtp_ResendCooldown(t *testing.T) {
+	store := newMemoryOtps()
+	sender := notify.NewInMemorySMSSender()
+
+	assert.NoError(t, issueOtp(context.TODO(), store, sender, loginOtp, auth.UserPrincipal, otpPhone))
+	first := sentOtp(t, sender)
+	assert.Len(t, first, 6)
+
+	err := issueOtp(context.TODO(), store, sender, loginOtp, auth.UserPrincipal, otpPhone)
+	assert.ErrorIs(t, err, custom_errors.ClientError)
+	assert.ErrorContains(t, err, "otp already sent, retry in")
+	assert.Len(t, sender.Messages(otpPhone), 1)
+
+	// another purpose has its own cooldown
+	assert.NoError(t, issueOtp(context.TODO(), store, sender, verifyPhoneOtp, auth.UserPrincipal, otpPhone))
+
+	// once the cooldown ran out a new otp replaces the first
+	store.cooldowns[otpCooldownKey(loginOtp, auth.UserPrincipal, otpPhone)] = time.Now().Add(-time.Second)
+	assert.NoError(t, issueOtp(context.TODO(), store, sender, loginOtp, auth.UserPrincipal, otpPhone))
+	second := sentOtp(t, sender)
+	if first != second {
+		assert.ErrorIs(t, verifyOtp(context.TODO(), store, loginOtp, auth.UserPrincipal, otpPhone, first), custom_errors.ClientError)
+	}
+	assert.NoError(t, verifyOtp(context.TODO(), store, loginOtp, auth.UserPrincipal, otpPhone, second))
+}
+
+func TestVerifyOtp(t *testing.T) {
+	issued := func(t *testing.T) (*memoryOtps, string) {
+		store := newMemoryOtps()
+		sender := notify.NewInMemorySMSSender()
+		assert.NoError(t, issueOtp(context.TODO(), store, sender, loginOtp, auth.UserPrincipal, otpPhone))
+		return store, sentOtp(t, sender)
+	}
+	verify := func(store *memoryOtps, otp string) error {
+		return verifyOtp(context.TODO(), store, loginOtp, auth.UserPrincipal, otpPhone, otp)
+	}
+	wrong := func(otp string) string {
+		if otp == "000000" {
+			return "111111"
+		}
+		return "000000"
+	}
+
+	t.Run("right otp is used once", func(t *testing.T) {
+		store, otp := issued(t)
+		assert.NoError(t, verify(store, otp))
+		assert.ErrorContains(t, verify(store, otp), "otp expired")
+	})
+
+	t.Run("otp of another purpose or type", func(t *testing.T) {
+		store, otp := issued(t)
+		assert.ErrorContains(t, verifyOtp(context.TODO(), store, verifyPhoneOtp, auth.UserPrincipal, otpPhone, otp), "otp expired")
+		assert.ErrorContains(t, verifyOtp(context.TODO(), store, loginOtp, auth.RiderPrincipal, otpPhone, otp), "otp expired")
+		assert.NoError(t, verify(store, otp))
+	})
+
+	t.Run("wrong otp counts an attempt", func(t *testing.T) {
+		store, otp := issued(t)
+		err := verify(store, wrong(otp))
+		assert.ErrorIs(t, err, custom_errors.ClientError)
+		assert.ErrorContains(t, err, "invalid otp, 4 attempts left")
+		assert.NoError(t, verify(store, otp))
+	})
+
+	t.Run("locked out after 5 attempts", func(t *testing.T) {
+		store, otp := issued(t)
+		for i := 0; i < maxOtpAttempts; i++ {
+			assert.ErrorContains(t, verify(store, wrong(otp)), "invalid otp")
+		}
+		// even the right otp is rejected and the otp is dropped
+		assert.ErrorContains(t, verify(store, otp), "too many attempts")
+		assert.ErrorContains(t, verify(store, otp), "otp expired")
+	})
+
+	t.Run("expired otp", func(t *testing.T) {
+		store, otp := issued(t)
+		store.otps[otpKey(loginOtp, auth.UserPrincipal, otpPhone)].expiresAt = time.Now().Add(-time.Second)
+		err := verify(store, otp)
+		assert.ErrorIs(t, err, custom_errors.ClientError)
+		assert.ErrorContains(t, err, "otp expired, request a new one")
+	})
+}
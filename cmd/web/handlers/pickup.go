@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"time"
+)
+
+// FoodReadyRequest restaurant marking the food of an order ready for pickup
+type FoodReadyRequest struct {
+	Id           primitive.ObjectID `json:"id" validate:"required"`
+	RestaurantId primitive.ObjectID `json:"-" validate:"required"` // from the token
+}
+
+// PickupReq rider message for arriving at the restaurant and picking up the order
+type PickupReq struct {
+	OrderId   primitive.ObjectID `json:"order_id"`
+	Latitude  string             `json:"latitude" validate:"latitude"`
+	Longitude string             `json:"longitude" validate:"longitude"`
+}
+
+// OrderStatusInfo sent on the sockets whenever the order moves to a new status
+type OrderStatusInfo struct {
+	Message string             `json:"message"`
+	OrderId primitive.ObjectID `json:"order_id"`
+	Status  string             `json:"status"`
+	At      time.Time          `json:"at"`
+}
+
+// MarkFoodReady marks the order FOOD_READY, the user and the assigned rider are notified
+func (request *FoodReadyRequest) MarkFoodReady(ctx context.Context, param OrderParam) error {
+	order, err := param.OrderRepo.GetOrder(ctx, request.Id)
+	if err != nil {
+		return err
+	}
+	if order.RestaurantId != request.RestaurantId {
+		return errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+	}
+	if order.Status == "CREATED" {
+		return errors.Join(custom_errors.PreconditionError, errors.New("order is not accepted"))
+	}
+	if order.Status == "CANCELLED" {
+		return errors.Join(custom_errors.PreconditionError, errors.New("order is cancelled"))
+	}
+	if !order.FoodReadyAt.IsZero() || order.IsPickedUp() {
+		return errors.Join(custom_errors.ConflictError, errors.New("order is already ready"))
+	}
+
+	currTime := time.Now()
+	order.Status = "FOOD_READY"
+	order.FoodReadyAt = currTime
+	order.PrepTime = currTime.Sub(order.AcceptedAt).Seconds()
+	order.UpdatedAt = currTime
+	if err := param.OrderRepo.UpdateOrder(ctx, order); err != nil {
+		return err
+	}
+
+	sendOrderStatus(param.SM, order, "Food is ready for pickup", currTime)
+	return nil
+}
+
+func handleArrivedAtRestaurant(ctx context.Context, rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, req PickupReq) error {
+	order, err := getRiderPickupOrder(ctx, or, riderId, req.OrderId)
+	if err != nil {
+		return err
+	}
+	if !order.RiderArrivedAt.IsZero() {
+		return newMessageError(ErrCodeConflict, "already arrived at restaurant")
+	}
+
+	currTime := time.Now()
+	order.Status = "RIDER_ARRIVED"
+	order.RiderArrivedAt = currTime
+	order.UpdatedAt = currTime
+	if err := or.OrderRepo.UpdateOrder(ctx, order); err != nil {
+		slog.ErrorContext(ctx, "update order failed", "error", err.Error())
+		return err
+	}
+
+	if !order.TripId.IsZero() {
+		trip, err := updateTripStop(ctx, or, order.TripId, order.Id, "PICKUP", "ARRIVED")
+		if err != nil {
+			slog.ErrorContext(ctx, "error in updating trip stop", "error", err.Error())
+		} else {
+			sendTripToRider(rm, trip, "Trip updated")
+		}
+	}
+	sendOrderStatus(rm, order, "Rider arrived at restaurant", currTime)
+	sendOrderToRestaurant(rm, RiderArrivedMessage, order, "Rider arrived")
+	return nil
+}
+
+func handlePickedUp(ctx context.Context, rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, req PickupReq) error {
+	order, err := getRiderPickupOrder(ctx, or, riderId, req.OrderId)
+	if err != nil {
+		return err
+	}
+
+	currTime := time.Now()
+	if order.RiderArrivedAt.IsZero() {
+		order.RiderArrivedAt = currTime
+	}
+	order.Status = "PICKED_UP"
+	order.PickedUpAt = currTime
+	order.RiderWaitTime = currTime.Sub(order.RiderArrivedAt).Seconds()
+	order.UpdatedAt = currTime
+	if err := or.OrderRepo.UpdateOrder(ctx, order); err != nil {
+		slog.ErrorContext(ctx, "update order failed", "error", err.Error())
+		return err
+	}
+
+	if !order.TripId.IsZero() {
+		trip, err := updateTripStop(ctx, or, order.TripId, order.Id, "PICKUP", "COMPLETED")
+		if err != nil {
+			slog.ErrorContext(ctx, "error in completing trip stop", "error", err.Error())
+		} else {
+			sendTripToRider(rm, trip, "Trip updated")
+		}
+	}
+	sendOrderStatus(rm, order, "Order picked up", currTime)
+	return nil
+}
+
+// getRiderPickupOrder order assigned to the rider which is not yet picked up
+func getRiderPickupOrder(ctx context.Context, or OrderParam, riderId primitive.ObjectID, orderId primitive.ObjectID) (model.Order, error) {
+	if orderId.IsZero() {
+		return model.Order{}, newMessageError(ErrCodeInvalidPayload, "order_id is required")
+	}
+	order, err := or.OrderRepo.GetOrder(ctx, orderId)
+	if err != nil {
+		slog.ErrorContext(ctx, "error in fetching order", "error", err.Error())
+		return model.Order{}, err
+	}
+	// a cancelled order no longer names the rider it was taken from
+	if order.Status == "CANCELLED" {
+		return model.Order{}, errOrderCancelled
+	}
+	if order.RiderId != riderId {
+		return model.Order{}, newMessageError(ErrCodeForbidden, "order is not assigned to rider")
+	}
+	if order.IsPickedUp() || order.Status == "DELIVERED" {
+		return model.Order{}, newMessageError(ErrCodeConflict, "order already picked up")
+	}
+	return order, nil
+}
+
+// sendOrderStatus notifies the users tracking the order and the assigned rider of the order status
+func sendOrderStatus(rm model.WebSocketManager, order model.Order, message string, at time.Time) {
+	statusInfo := OrderStatusInfo{
+		Message: message,
+		OrderId: order.Id,
+		Status:  order.Status,
+		At:      at,
+	}
+	sendToOrderWatchers(rm, order.Id, OrderStatusMessage, statusInfo)
+	if !order.RiderId.IsZero() {
+		sendToRiders(rm, OrderStatusMessage, statusInfo, order.RiderId)
+	}
+}
@@ -10,18 +10,18 @@ import (
 )
 
 type RatingRequest struct {
-	RatingGiver    primitive.ObjectID `json:"rating_giver"`
-	RatingReceiver primitive.ObjectID `json:"rating_receiver"`
-	OrderId        primitive.ObjectID `json:"order_id"`
+	RatingGiver    primitive.ObjectID `json:"-"` // from the token
+	RatingReceiver primitive.ObjectID `json:"rating_receiver" validate:"objectid"`
+	OrderId        primitive.ObjectID `json:"order_id" validate:"objectid"`
 
-	RatingGiverType    string `json:"rating_giver_type"`
-	RatingReceiverType string `json:"rating_receiver_type"`
+	RatingGiverType    string `json:"-" validate:"required"` // from the token
+	RatingReceiverType string `json:"rating_receiver_type" validate:"required,oneof=rider user restaurant"`
 	Rating             int    `json:"rating" validate:"min=0,max=5"`
 	Comment            string
 }
 
 type GetRatingRequest struct {
-	Id primitive.ObjectID `query:"id"`
+	Id primitive.ObjectID `query:"id" validate:"objectid"`
 }
 
 // RatingParam request param contains all dependencies
@@ -26,6 +26,7 @@ type CreateRestaurantRequest struct {
 	Latitude  string     `json:"latitude" validate:"required,latitude"`
 	Longitude string     `json:"longitude" validate:"required,longitude"`
 	Address   string     `json:"address" validate:"required"`
+	Pincode   string     `json:"pincode" validate:"omitempty,pincode"`
 	Website   string     `json:"website"`
 	Cuisines  string     `json:"cuisines"`
 	MealType  string     `json:"meal_type"`
@@ -34,20 +35,34 @@ type CreateRestaurantRequest struct {
 
 // UpdateRestaurantRequest a type for updaing a restaurant request
 type UpdateRestaurantRequest struct {
-	Id      primitive.ObjectID `json:"id" validate:"required"` // required a mongodb objectId
+	Id      primitive.ObjectID `json:"-" validate:"required"` // from the token
 	Name    string             `json:"name" validate:"required"`
 	EmailId string             `json:"email_id" validate:"required,email"`
 
 	// applied validation on indian numbers in format of +91999999999, allowing both 9 and 10 digit numbers
 	PhoneNumber string `json:"phone_number" validate:"required,e164,min=12,max=13,startswith=+91"`
 
-	Latitude  string     `json:"latitude" validate:"required,latitude"`
-	Longitude string     `json:"longitude" validate:"required,longitude"`
-	Address   string     `json:"address" validate:"required"`
-	Website   string     `json:"website"`
-	Cuisines  string     `json:"cuisines"`
-	MealType  string     `json:"meal_type"`
-	Menu      model.Menu `json:"menu"`
+	Latitude  string `json:"latitude" validate:"required,latitude"`
+	Longitude string `json:"longitude" validate:"required,longitude"`
+	Address   string `json:"address" validate:"required"`
+	Pincode   string `json:"pincode" validate:"omitempty,pincode"`
+	Website   string `json:"website"`
+	Cuisines  string `json:"cuisines"`
+	MealType  string `json:"meal_type"`
+}
+
+// UpdateMenuRequest managers changing the menu without access to the rest of the restaurant
+type UpdateMenuRequest struct {
+	Id   primitive.ObjectID `json:"-" validate:"required"` // from the token
+	Menu model.Menu         `json:"menu"`
+}
+
+// UpdatePayoutRequest owner changing the bank account the restaurant is paid out to
+type UpdatePayoutRequest struct {
+	Id            primitive.ObjectID `json:"-" validate:"required"` // from the token
+	AccountHolder string             `json:"account_holder" validate:"required"`
+	AccountNumber string             `json:"account_number" validate:"required,numeric,min=9,max=18"`
+	Ifsc          string             `json:"ifsc" validate:"required,len=11,alphanum"`
 }
 
 // GetRestaurantRequest a type for updaing a restaurant request
@@ -78,6 +93,7 @@ func (request *CreateRestaurantRequest) CreateRestaurant(ctx context.Context, pa
 		PhoneNumber: request.PhoneNumber,
 		Location:    model.NewLocationFromLongLatStr(request.Longitude, request.Latitude),
 		Address:     request.Address,
+		Pincode:     request.Pincode,
 		Status:      "ACTIVE",
 		Website:     request.Website,
 		Cuisines:    request.Cuisines,
@@ -104,16 +120,21 @@ func (request *UpdateRestaurantRequest) UpdateRestaurant(ctx context.Context, pa
 		return err
 	}
 
+	// a new number has to be verified again
+	if restaurant.PhoneNumber != request.PhoneNumber {
+		restaurant.Verified = false
+	}
+
 	// Update RestaurantId
 	restaurant.Name = request.Name
 	restaurant.EmailId = request.EmailId
 	restaurant.PhoneNumber = request.PhoneNumber
 	restaurant.Location = model.NewLocationFromLongLatStr(request.Longitude, request.Latitude)
 	restaurant.Address = request.Address
+	restaurant.Pincode = request.Pincode
 	restaurant.Website = request.Website
 	restaurant.Cuisines = request.Cuisines
 	restaurant.MealType = request.Cuisines
-	restaurant.Menu = request.Menu
 
 	restaurant.UpdatedAt = currTime
 
@@ -130,6 +151,55 @@ func (request *UpdateRestaurantRequest) UpdateRestaurant(ctx context.Context, pa
 	return nil
 }
 
+// UpdateMenu replaces the menu of the restaurant
+func (request *UpdateMenuRequest) UpdateMenu(ctx context.Context, param RestaurantParam) error {
+	restaurant, err := param.Repository.GetRestaurant(ctx, request.Id)
+	if err != nil {
+		return err
+	}
+
+	restaurant.Menu = request.Menu
+	restaurant.UpdatedAt = time.Now()
+	if err := param.Repository.UpdateRestaurant(ctx, restaurant); err != nil {
+		return err
+	}
+
+	param.Cache.Delete("restaurant_cache:" + restaurant.Id.Hex())
+	return nil
+}
+
+// UpdatePayout replaces the payout details of the restaurant
+func (request *UpdatePayoutRequest) UpdatePayout(ctx context.Context, param RestaurantParam) error {
+	restaurant, err := param.Repository.GetRestaurant(ctx, request.Id)
+	if err != nil {
+		return err
+	}
+
+	currTime := time.Now()
+	restaurant.Payout = model.PayoutDetails{
+		AccountHolder: request.AccountHolder,
+		AccountNumber: request.AccountNumber,
+		Ifsc:          request.Ifsc,
+		UpdatedAt:     currTime,
+	}
+	restaurant.UpdatedAt = currTime
+	if err := param.Repository.UpdateRestaurant(ctx, restaurant); err != nil {
+		return err
+	}
+
+	param.Cache.Delete("restaurant_cache:" + restaurant.Id.Hex())
+	return nil
+}
+
+// GetPayout payout details of the restaurant, read from the database as the cache is shared with public reads
+func (request *GetRestaurantRequest) GetPayout(ctx context.Context, param RestaurantParam) (model.PayoutDetails, error) {
+	restaurant, err := param.Repository.GetRestaurant(ctx, request.Id)
+	if err != nil {
+		return model.PayoutDetails{}, err
+	}
+	return restaurant.Payout, nil
+}
+
 // GetRestaurant getting a restaurant
 func (request *GetRestaurantRequest) GetRestaurant(ctx context.Context, param RestaurantParam) (model.Restaurant, error) {
 	if value, found := param.Cache.Get("restaurant_cache:" + request.Id.Hex()); found {
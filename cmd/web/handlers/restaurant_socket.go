@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"food-eats/cmd/web/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+)
+
+// RestaurantWebSocketReq restaurant dashboard connecting for order events, the restaurant is taken from the token
+type RestaurantWebSocketReq struct {
+	RestaurantId primitive.ObjectID `validate:"required"` // from the token
+	LastSeq      string             `query:"last_seq"`    // seq of the last message received before reconnecting
+}
+
+// RestaurantOrderInfo sent to the restaurant dashboard when an order of the restaurant is placed or progresses
+type RestaurantOrderInfo struct {
+	Message string      `json:"message"`
+	Order   model.Order `json:"order"`
+}
+
+// ConnectRestaurant checks the restaurant exists before its dashboard is connected
+func (request *RestaurantWebSocketReq) ConnectRestaurant(ctx context.Context, param RestaurantParam) (model.Restaurant, error) {
+	return param.Repository.GetRestaurant(ctx, request.RestaurantId)
+}
+
+// ProcessRestaurantMessage handles a message of the restaurant dashboard, dashboards only listen for now
+// so every message is answered with an error. ErrAbusiveConnection is returned when the connection has to be closed.
+func ProcessRestaurantMessage(rm model.WebSocketManager, limiter *MessageLimiter, restaurantId primitive.ObjectID, message []byte, ctx context.Context) error {
+	envelope, err := parseEnvelope(message)
+	if limitErr := limiter.allow(ctx, envelope.Type); limitErr != nil {
+		if errors.Is(limitErr, ErrAbusiveConnection) {
+			return limitErr
+		}
+		err = limitErr
+	}
+	if err == nil {
+		err = newMessageError(ErrCodeUnknownType, "unknown message type "+envelope.Type)
+	}
+	slog.InfoContext(ctx, "restaurant message rejected", "type", envelope.Type, "id", envelope.Id, "error", err.Error())
+	replyToRestaurant(rm, restaurantId, envelope, err)
+	return nil
+}
+
+// sendOrderToRestaurant notifies the dashboards of the restaurant of the order
+func sendOrderToRestaurant(rm model.WebSocketManager, msgType string, order model.Order, message string) {
+	sendToRestaurants(rm, msgType, RestaurantOrderInfo{Message: message, Order: order}, order.RestaurantId)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"food-eats/cmd/web/notify"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// staffInviteTTL time an invited staff member has to log in for the first time
+const staffInviteTTL = 7 * 24 * time.Hour
+
+// StaffParam dependencies of the staff endpoints
+type StaffParam struct {
+	StaffRepo      model.RestaurantStaffRepository
+	RestaurantRepo model.RestaurantRepository
+	SMS            notify.SMSSender
+}
+
+// InviteStaffRequest owner or manager inviting a person to log in for the restaurant
+type InviteStaffRequest struct {
+	InvitedBy auth.Principal `json:"-"` // from the token
+	Name      string         `json:"name" validate:"required"`
+
+	// applied validation on indian numbers in the format of +91999999999, allowing both 9 and 10-digit numbers
+	PhoneNumber string `json:"phone_number" validate:"required,e164,min=12,max=13,startswith=+91"`
+	Role        string `json:"role" validate:"required,oneof=RESTAURANT_OWNER RESTAURANT_MANAGER RESTAURANT_KITCHEN"`
+}
+
+// RemoveStaffRequest owner or manager removing a staff member or a pending invitation
+type RemoveStaffRequest struct {
+	RemovedBy auth.Principal     `json:"-"` // from the token
+	Id        primitive.ObjectID `query:"id" validate:"required"`
+}
+
+// GetStaffRequest staff of the restaurant
+type GetStaffRequest struct {
+	RestaurantId primitive.ObjectID `validate:"required"` // from the token
+}
+
+// canManageStaff owners manage every staff member, managers only the kitchen staff
+func canManageStaff(role string, staffRole string) bool {
+	switch role {
+	case auth.RoleRestaurantOwner:
+		return true
+	case auth.RoleRestaurantManager:
+		return staffRole == auth.RoleRestaurantKitchen
+	default:
+		return false
+	}
+}
+
+// InviteStaff adds the staff member as INVITED and texts the invitation, the invitation is accepted by
+// logging in with the phone number as STAFF before it expires
+func (request *InviteStaffRequest) InviteStaff(ctx context.Context, param StaffParam) (model.RestaurantStaff, error) {
+	if !canManageStaff(request.InvitedBy.Role, request.Role) {
+		return model.RestaurantStaff{}, errors.Join(custom_errors.ForbiddenError, errors.New("not allowed to invite "+request.Role))
+	}
+
+	restaurant, err := param.RestaurantRepo.GetRestaurant(ctx, request.InvitedBy.ActingRestaurant())
+	if err != nil {
+		return model.RestaurantStaff{}, err
+	}
+	if restaurant.Status != "ACTIVE" {
+		return model.RestaurantStaff{}, errors.Join(custom_errors.PreconditionError, errors.New("restaurant not active"))
+	}
+
+	currTime := time.Now()
+	existing, err := param.StaffRepo.GetStaffByPhone(ctx, request.PhoneNumber)
+	switch {
+	case err == nil && existing.Status == "INVITED" && currTime.After(existing.InviteExpiresAt):
+		// an expired invitation does not block inviting the number again
+		existing.Status = "DELETED"
+		existing.UpdatedAt = currTime
+		if err := param.StaffRepo.UpdateStaff(ctx, existing); err != nil {
+			return model.RestaurantStaff{}, err
+		}
+	case err == nil:
+		return model.RestaurantStaff{}, errors.Join(custom_errors.ConflictError, errors.New("phone number already works at a restaurant"))
+	case !errors.Is(err, custom_errors.ClientError):
+		return model.RestaurantStaff{}, err
+	}
+
+	staff := model.RestaurantStaff{
+		RestaurantId:    restaurant.Id,
+		Name:            request.Name,
+		PhoneNumber:     request.PhoneNumber,
+		Role:            request.Role,
+		InvitedBy:       request.InvitedBy.Id,
+		InviteExpiresAt: currTime.Add(staffInviteTTL),
+		CreatedAt:       currTime,
+		UpdatedAt:       currTime,
+		Status:          "INVITED",
+	}
+	staff, err = param.StaffRepo.CreateStaff(ctx, staff)
+	if err != nil {
+		return model.RestaurantStaff{}, err
+	}
+
+	message := "You are invited to manage orders of " + restaurant.Name + " on food eats, log in with this phone number as staff to join"
+	if err := param.SMS.Send(ctx, staff.PhoneNumber, message); err != nil {
+		return model.RestaurantStaff{}, err
+	}
+	return staff, nil
+}
+
+// RemoveStaff marks the staff member deleted, their tokens can no longer be refreshed
+func (request *RemoveStaffRequest) RemoveStaff(ctx context.Context, param StaffParam) error {
+	staff, err := param.StaffRepo.GetStaff(ctx, request.Id)
+	if err != nil {
+		return err
+	}
+	if staff.RestaurantId != request.RemovedBy.ActingRestaurant() || staff.Status == "DELETED" {
+		return errors.Join(custom_errors.NotFoundError, errors.New("no such entry"))
+	}
+	if staff.Id == request.RemovedBy.Id {
+		return errors.Join(custom_errors.ForbiddenError, errors.New("staff can not remove themselves"))
+	}
+	if !canManageStaff(request.RemovedBy.Role, staff.Role) {
+		return errors.Join(custom_errors.ForbiddenError, errors.New("not allowed to remove "+staff.Role))
+	}
+
+	staff.Status = "DELETED"
+	staff.UpdatedAt = time.Now()
+	return param.StaffRepo.UpdateStaff(ctx, staff)
+}
+
+// GetStaff staff and pending invitations of the restaurant
+func (request *GetStaffRequest) GetStaff(ctx context.Context, param StaffParam) ([]model.RestaurantStaff, error) {
+	return param.StaffRepo.GetRestaurantStaff(ctx, request.RestaurantId)
+}
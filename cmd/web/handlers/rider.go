@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"food-eats/cmd/web/custom-errors"
 	"food-eats/cmd/web/model"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
@@ -31,11 +33,12 @@ type CreateRiderRequest struct {
 	Latitude  string `json:"latitude" validate:"required,latitude"`
 	Longitude string `json:"longitude" validate:"required,longitude"`
 	Address   string `json:"address" validate:"required"`
+	Pincode   string `json:"pincode" validate:"omitempty,pincode"`
 }
 
 // UpdateRiderRequest a type for updaing a rider request
 type UpdateRiderRequest struct {
-	Id      primitive.ObjectID `json:"id" validate:"required"` // required a mongodb objectId
+	Id      primitive.ObjectID `json:"-" validate:"required"` // from the token
 	Name    string             `json:"name" validate:"required"`
 	EmailId string             `json:"email_id" validate:"required,email"`
 
@@ -45,12 +48,13 @@ type UpdateRiderRequest struct {
 	Latitude  string `json:"latitude" validate:"required,latitude"`
 	Longitude string `json:"longitude" validate:"required,longitude"`
 	Address   string `json:"address" validate:"required"`
+	Pincode   string `json:"pincode" validate:"omitempty,pincode"`
 	Status    string `json:"status"`
 }
 
 // GetRiderRequest a type for updaing a rider request
 type GetRiderRequest struct {
-	Id primitive.ObjectID `query:"id" validate:"required"` // required a mongodb objectId
+	Id primitive.ObjectID `validate:"required"` // from the token
 }
 
 // CreateRider register new rider
@@ -63,6 +67,7 @@ func (request *CreateRiderRequest) CreateRider(ctx context.Context, param RiderP
 		EmailId:     request.EmailId,
 		Location:    model.NewLocationFromLongLatStr(request.Longitude, request.Latitude),
 		Address:     request.Address,
+		Pincode:     request.Pincode,
 		Status:      "ACTIVE",
 		CreatedAt:   currTime,
 		UpdatedAt:   currTime,
@@ -85,12 +90,23 @@ func (request *UpdateRiderRequest) UpdateRider(ctx context.Context, param RiderP
 		return err
 	}
 
+	// only support can lift a suspension
+	if rider.Status == "SUSPENDED" {
+		return errors.Join(custom_errors.ForbiddenError, errors.New("rider suspended"))
+	}
+
+	// a new number has to be verified again
+	if rider.PhoneNumber != request.PhoneNumber {
+		rider.Verified = false
+	}
+
 	// Update Rider
 	rider.Name = request.Name
 	rider.EmailId = request.EmailId
 	rider.PhoneNumber = request.PhoneNumber
 	rider.Location = model.NewLocationFromLongLatStr(request.Longitude, request.Latitude)
 	rider.Address = request.Address
+	rider.Pincode = request.Pincode
 	rider.Status = request.Status
 	rider.UpdatedAt = currTime
 
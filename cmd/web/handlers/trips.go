@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// todo move these to config
+const (
+	maxOrdersPerTrip      = 2
+	batchPickupRadius     = 2.0 // in km, a second pickup is only offered from the same or a nearby restaurant
+	maxBearingDifference  = 45.0
+	maxFirstCustomerDelay = 10 * time.Minute
+	averageRiderSpeed     = 20.0 // in km/h, used to estimate delay caused by batching
+)
+
+// StopStatusReq rider updating status of a stop on the trip
+type StopStatusReq struct {
+	TripId  primitive.ObjectID `json:"trip_id"`
+	OrderId primitive.ObjectID `json:"order_id"`
+	Type    string             `json:"type"`   // PICKUP or DROP
+	Status  string             `json:"status"` // ARRIVED or COMPLETED
+}
+
+// TripInfo sent to the rider whenever the trip or its stop list changes
+type TripInfo struct {
+	Message string     `json:"message"`
+	Trip    model.Trip `json:"trip"`
+}
+
+// StopStatusInfo sent to the user when the rider progresses on a stop of their order
+type StopStatusInfo struct {
+	Message string             `json:"message"`
+	OrderId primitive.ObjectID `json:"order_id"`
+	Type    string             `json:"type"`
+	Status  string             `json:"status"`
+}
+
+// newTripStops pickup and drop stop for an order
+func newTripStops(order model.Order) []model.TripStop {
+	return []model.TripStop{
+		{
+			OrderId:   order.Id,
+			Type:      "PICKUP",
+			Latitude:  order.PickupLatitude,
+			Longitude: order.PickupLongitude,
+			Address:   order.PickupAddress,
+			Status:    "PENDING",
+		},
+		{
+			OrderId:   order.Id,
+			Type:      "DROP",
+			Latitude:  order.DeliveryLatitude,
+			Longitude: order.DeliveryLongitude,
+			Address:   order.DeliveryAddress,
+			Status:    "PENDING",
+		},
+	}
+}
+
+// planStops orders the stops the rider still has to visit, stops already visited keep their position.
+// Remaining stops are picked greedily by nearest distance, a drop is only picked once its pickup is planned.
+func planStops(latitude, longitude float64, stops []model.TripStop) []model.TripStop {
+	planned := make([]model.TripStop, 0, len(stops))
+	var remaining []model.TripStop
+	pickedUp := map[primitive.ObjectID]bool{}
+
+	for _, stop := range stops {
+		if stop.Status == "PENDING" {
+			remaining = append(remaining, stop)
+			continue
+		}
+		planned = append(planned, stop)
+		if stop.Type == "PICKUP" {
+			pickedUp[stop.OrderId] = true
+		}
+	}
+
+	for len(remaining) > 0 {
+		next := -1
+		nextDistance := math.MaxFloat64
+		for i, stop := range remaining {
+			if stop.Type == "DROP" && !pickedUp[stop.OrderId] {
+				continue
+			}
+			distance := distanceBetweenPoints(latitude, longitude, stop.Latitude, stop.Longitude)
+			if distance < nextDistance {
+				next, nextDistance = i, distance
+			}
+		}
+		if next == -1 {
+			// drop without a pickup, should not happen but keep the stops instead of looping forever
+			planned = append(planned, remaining...)
+			break
+		}
+
+		stop := remaining[next]
+		planned = append(planned, stop)
+		if stop.Type == "PICKUP" {
+			pickedUp[stop.OrderId] = true
+		}
+		latitude, longitude = stop.Latitude, stop.Longitude
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+
+	return planned
+}
+
+// timeToDrop estimated time for the rider to reach the drop of an order following the planned stops
+func timeToDrop(latitude, longitude float64, stops []model.TripStop, orderId primitive.ObjectID) time.Duration {
+	totalDistance := float64(0)
+	for _, stop := range stops {
+		if stop.Status == "COMPLETED" {
+			continue
+		}
+		totalDistance += distanceBetweenPoints(latitude, longitude, stop.Latitude, stop.Longitude)
+		latitude, longitude = stop.Latitude, stop.Longitude
+		if stop.OrderId == orderId && stop.Type == "DROP" {
+			break
+		}
+	}
+	return time.Duration(totalDistance / averageRiderSpeed * float64(time.Hour))
+}
+
+// bearing initial bearing in degrees from first point to the second
+func bearing(lat1, lng1, lat2, lng2 float64) float64 {
+	radLat1 := lat1 * math.Pi / 180
+	radLat2 := lat2 * math.Pi / 180
+	deltaLng := (lng2 - lng1) * math.Pi / 180
+
+	y := math.Sin(deltaLng) * math.Cos(radLat2)
+	x := math.Cos(radLat1)*math.Sin(radLat2) - math.Sin(radLat1)*math.Cos(radLat2)*math.Cos(deltaLng)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// canBatch checks if an order can be carried on an existing trip.
+// The new pickup must be near a pickup of the trip, both orders have to go in a similar direction and
+// no customer already on the trip may be delayed by more than maxFirstCustomerDelay.
+func canBatch(trip model.Trip, order model.Order) bool {
+	if trip.Status != "ACTIVE" || len(trip.OrderIds) >= maxOrdersPerTrip {
+		return false
+	}
+
+	orderBearing := bearing(order.PickupLatitude, order.PickupLongitude, order.DeliveryLatitude, order.DeliveryLongitude)
+	nearPickup := false
+	for _, stop := range trip.Stops {
+		if stop.Type == "DROP" {
+			// rider has already started dropping orders
+			if stop.Status != "PENDING" {
+				return false
+			}
+			continue
+		}
+
+		if distanceBetweenPoints(stop.Latitude, stop.Longitude, order.PickupLatitude, order.PickupLongitude) <= batchPickupRadius {
+			nearPickup = true
+		}
+
+		drop, ok := trip.GetStop(stop.OrderId, "DROP")
+		if !ok {
+			return false
+		}
+		tripBearing := bearing(stop.Latitude, stop.Longitude, drop.Latitude, drop.Longitude)
+		difference := math.Abs(tripBearing - orderBearing)
+		if difference > 180 {
+			difference = 360 - difference
+		}
+		if difference > maxBearingDifference {
+			return false
+		}
+	}
+	if !nearPickup {
+		return false
+	}
+
+	batchedStops := planStops(trip.Latitude, trip.Longitude, append(append([]model.TripStop{}, trip.Stops...), newTripStops(order)...))
+	for _, orderId := range trip.OrderIds {
+		before := timeToDrop(trip.Latitude, trip.Longitude, trip.Stops, orderId)
+		after := timeToDrop(trip.Latitude, trip.Longitude, batchedStops, orderId)
+		if after-before > maxFirstCustomerDelay {
+			return false
+		}
+	}
+
+	return true
+}
+
+// findBatchRiders riders on an active trip which can also carry the order
+func findBatchRiders(ctx context.Context, param OrderParam, order model.Order) ([]model.Trip, error) {
+	trips, err := param.TripRepo.SearchActiveTrips(ctx, model.SearchTripQuery{MaxOrders: maxOrdersPerTrip})
+	if err != nil {
+		return nil, err
+	}
+
+	var batchTrips []model.Trip
+	for _, trip := range trips {
+		if canBatch(trip, order) {
+			batchTrips = append(batchTrips, trip)
+		}
+	}
+	return batchTrips, nil
+}
+
+// addOrderToTrip adds the order to the rider's active trip, creating a new trip if the rider is not on one
+func addOrderToTrip(ctx context.Context, param OrderParam, trip model.Trip, order model.Order, latitude, longitude float64) (model.Trip, error) {
+	currTime := time.Now()
+	if trip.Id.IsZero() {
+		trip = model.Trip{
+			RiderId:   order.RiderId,
+			Latitude:  latitude,
+			Longitude: longitude,
+			CreatedAt: currTime,
+			Status:    "ACTIVE",
+		}
+	}
+
+	trip.OrderIds = append(trip.OrderIds, order.Id)
+	trip.Stops = planStops(trip.Latitude, trip.Longitude, append(trip.Stops, newTripStops(order)...))
+	trip.UpdatedAt = currTime
+
+	if trip.Id.IsZero() {
+		return param.TripRepo.CreateTrip(ctx, trip)
+	}
+	return trip, param.TripRepo.UpdateTrip(ctx, trip)
+}
+
+// removeOrderFromTrip takes the stops of the order off the trip, a trip left without orders is completed
+func removeOrderFromTrip(ctx context.Context, tripRepo model.TripRepository, tripId primitive.ObjectID, orderId primitive.ObjectID) (model.Trip, error) {
+	trip, err := tripRepo.GetTrip(ctx, tripId)
+	if err != nil {
+		return model.Trip{}, err
+	}
+
+	var orderIds []primitive.ObjectID
+	for _, id := range trip.OrderIds {
+		if id != orderId {
+			orderIds = append(orderIds, id)
+		}
+	}
+	var stops []model.TripStop
+	for _, stop := range trip.Stops {
+		if stop.OrderId != orderId {
+			stops = append(stops, stop)
+		}
+	}
+
+	currTime := time.Now()
+	trip.OrderIds = orderIds
+	trip.Stops = planStops(trip.Latitude, trip.Longitude, stops)
+	trip.UpdatedAt = currTime
+	if trip.IsCompleted() {
+		trip.Status = "COMPLETED"
+		trip.CompletedAt = currTime
+	}
+
+	return trip, tripRepo.UpdateTrip(ctx, trip)
+}
+
+// updateTripStop moves a stop of the order to ARRIVED or COMPLETED and closes the trip once every stop is done
+func updateTripStop(ctx context.Context, param OrderParam, tripId primitive.ObjectID, orderId primitive.ObjectID, stopType string, status string) (model.Trip, error) {
+	trip, err := param.TripRepo.GetTrip(ctx, tripId)
+	if err != nil {
+		return model.Trip{}, err
+	}
+
+	stop, ok := trip.GetStop(orderId, stopType)
+	if !ok {
+		return model.Trip{}, errors.Join(custom_errors.NotFoundError, errors.New("no such stop on trip"))
+	}
+
+	currTime := time.Now()
+	switch {
+	case status == "ARRIVED" && stop.Status == "PENDING":
+		stop.ArrivedAt = currTime
+	case status == "COMPLETED" && stop.Status != "COMPLETED":
+		if stop.ArrivedAt.IsZero() {
+			stop.ArrivedAt = currTime
+		}
+		stop.CompletedAt = currTime
+	default:
+		return model.Trip{}, errors.Join(custom_errors.ClientError, errors.New("invalid stop status"))
+	}
+	stop.Status = status
+	trip.Latitude, trip.Longitude = stop.Latitude, stop.Longitude
+	trip.Stops = planStops(trip.Latitude, trip.Longitude, trip.Stops)
+	trip.UpdatedAt = currTime
+	if trip.IsCompleted() {
+		trip.Status = "COMPLETED"
+		trip.CompletedAt = currTime
+	}
+
+	return trip, param.TripRepo.UpdateTrip(ctx, trip)
+}
+
+// handleStopStatus rider reaching the drop location, pickups are updated by arrived_at_restaurant and picked_up
+// and a drop is completed only by delivering the order
+func handleStopStatus(ctx context.Context, rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, req StopStatusReq) error {
+	if req.TripId.IsZero() || req.OrderId.IsZero() {
+		return newMessageError(ErrCodeInvalidPayload, "trip_id and order_id are required")
+	}
+	if req.Type != "DROP" || req.Status != "ARRIVED" {
+		return newMessageError(ErrCodeInvalidPayload, "invalid stop status")
+	}
+
+	trip, err := or.TripRepo.GetTrip(ctx, req.TripId)
+	if err != nil {
+		slog.ErrorContext(ctx, "error in fetching trip", "error", err.Error())
+		return err
+	}
+	if trip.RiderId != riderId || trip.Status != "ACTIVE" {
+		return newMessageError(ErrCodeForbidden, "not your trip")
+	}
+
+	trip, err = updateTripStop(ctx, or, req.TripId, req.OrderId, req.Type, req.Status)
+	if err != nil {
+		slog.InfoContext(ctx, "error in updating trip stop", "error", err.Error())
+		if errors.Is(err, custom_errors.ClientError) {
+			return newMessageError(ErrCodeInvalidState, "error updating stop")
+		}
+		return err
+	}
+	sendTripToRider(rm, trip, "Trip updated")
+
+	order, err := or.OrderRepo.GetOrder(ctx, req.OrderId)
+	if err != nil {
+		slog.ErrorContext(ctx, "error in fetching order", "error", err.Error())
+		return err
+	}
+	statusInfo := StopStatusInfo{
+		Message: "rider stop updated",
+		OrderId: order.Id,
+		Type:    req.Type,
+		Status:  req.Status,
+	}
+	sendToOrderWatchers(rm, order.Id, StopUpdatedMessage, statusInfo)
+	return nil
+}
+
+func sendTripToRider(rm model.WebSocketManager, trip model.Trip, message string) {
+	sendToRiders(rm, TripUpdatedMessage, TripInfo{Message: message, Trip: trip}, trip.RiderId)
+}
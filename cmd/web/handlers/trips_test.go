@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"food-eats/cmd/web/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// restaurant everything in these tests starts from, a degree of latitude is about 111 km
+const (
+	restaurantLat = 12.97
+	restaurantLng = 77.59
+)
+
+// tripOrder order picked up at the latitude and longitude offsets from the restaurant and dropped at the others
+func tripOrder(pickupLat, pickupLng, dropLat, dropLng float64) model.Order {
+	return model.Order{
+		Id:                primitive.NewObjectID(),
+		PickupLatitude:    restaurantLat + pickupLat,
+		PickupLongitude:   restaurantLng + pickupLng,
+		DeliveryLatitude:  restaurantLat + dropLat,
+		DeliveryLongitude: restaurantLng + dropLng,
+	}
+}
+
+// activeTrip trip of a rider standing at the restaurant carrying the orders, nothing picked up yet
+func activeTrip(orders ...model.Order) model.Trip {
+	trip := model.Trip{Status: "ACTIVE", Latitude: restaurantLat, Longitude: restaurantLng}
+	for _, order := range orders {
+		trip.OrderIds = append(trip.OrderIds, order.Id)
+		trip.Stops = append(trip.Stops, newTripStops(order)...)
+	}
+	return trip
+}
+
+func stopNames(stops []model.TripStop, names map[primitive.ObjectID]string) []string {
+	var result []string
+	for _, stop := range stops {
+		result = append(result, names[stop.OrderId]+" "+stop.Type)
+	}
+	return result
+}
+
+func TestPlanStops(t *testing.T) {
+	// the drop of a is right next to the rider, the pickup 3 km away
+	a := tripOrder(0.027, 0, 0.001, 0)
+	b := tripOrder(0.009, 0, 0.018, 0)
+	names := map[primitive.ObjectID]string{a.Id: "a", b.Id: "b"}
+
+	tests := []struct {
+		name  string
+		stops []model.TripStop
+		want  []string
+	}{
+		{
+			name:  "pickup before drop even when the drop is nearer",
+			stops: newTripStops(a),
+			want:  []string{"a PICKUP", "a DROP"},
+		},
+		{
+			name:  "nearest stop first once its pickup is planned",
+			stops: append(newTripStops(a), newTripStops(b)...),
+			want:  []string{"b PICKUP", "b DROP", "a PICKUP", "a DROP"},
+		},
+		{
+			name: "visited stops keep their position",
+			stops: func() []model.TripStop {
+				stops := append(newTripStops(a), newTripStops(b)...)
+				stops[0].Status = "COMPLETED"
+				return stops
+			}(),
+			want: []string{"a PICKUP", "a DROP", "b PICKUP", "b DROP"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planned := planStops(restaurantLat, restaurantLng, tt.stops)
+			assert.Equal(t, tt.want, stopNames(planned, names))
+		})
+	}
+}
+
+func TestTimeToDrop(t *testing.T) {
+	// picked up 1 km north, dropped another 2 km north
+	order := tripOrder(0.009, 0, 0.027, 0)
+	stops := newTripStops(order)
+
+	distance := distanceBetweenPoints(restaurantLat, restaurantLng, stops[1].Latitude, stops[1].Longitude)
+	expected := time.Duration(distance / averageRiderSpeed * float64(time.Hour))
+	assert.InDelta(t, float64(expected), float64(timeToDrop(restaurantLat, restaurantLng, stops, order.Id)), float64(time.Second))
+
+	// the rider already left the pickup, only the way from the rider to the drop is left
+	stops[0].Status = "COMPLETED"
+	distance = distanceBetweenPoints(restaurantLat, restaurantLng, stops[1].Latitude, stops[1].Longitude)
+	expected = time.Duration(distance / averageRiderSpeed * float64(time.Hour))
+	assert.InDelta(t, float64(expected), float64(timeToDrop(restaurantLat, restaurantLng, stops, order.Id)), float64(time.Second))
+
+	// stops after the drop are not counted
+	other := tripOrder(0, 0, 0.1, 0)
+	withOther := append(newTripStops(order), newTripStops(other)[1])
+	assert.Equal(t, timeToDrop(restaurantLat, restaurantLng, newTripStops(order), order.Id),
+		timeToDrop(restaurantLat, restaurantLng, withOther, order.Id))
+}
+
+func TestCanBatch(t *testing.T) {
+	// going 3 km north from the restaurant
+	first := tripOrder(0, 0, 0.027, 0)
+
+	tests := []struct {
+		name  string
+		trip  model.Trip
+		order model.Order
+		want  bool
+	}{
+		{
+			name:  "same restaurant same direction",
+			trip:  activeTrip(first),
+			order: tripOrder(0, 0, 0.018, 0),
+			want:  true,
+		},
+		{
+			name:  "trip not active",
+			trip:  func() model.Trip { trip := activeTrip(first); trip.Status = "COMPLETED"; return trip }(),
+			order: tripOrder(0, 0, 0.018, 0),
+			want:  false,
+		},
+		{
+			name:  "trip full",
+			trip:  activeTrip(first, tripOrder(0, 0, 0.02, 0)),
+			order: tripOrder(0, 0, 0.018, 0),
+			want:  false,
+		},
+		{
+			name:  "pickup too far",
+			trip:  activeTrip(first),
+			order: tripOrder(0.036, 0, 0.054, 0),
+			want:  false,
+		},
+		{
+			name:  "bearing too different",
+			trip:  activeTrip(first),
+			order: tripOrder(0, 0, 0, 0.027),
+			want:  false,
+		},
+		{
+			name:  "bearing within the limit",
+			trip:  activeTrip(first),
+			order: tripOrder(0, 0, 0.027, 0.009),
+			want:  true,
+		},
+		{
+			name: "rider already dropping",
+			trip: func() model.Trip {
+				trip := activeTrip(first)
+				trip.Stops[1].Status = "ARRIVED"
+				return trip
+			}(),
+			order: tripOrder(0, 0, 0.018, 0),
+			want:  false,
+		},
+		{
+			// picking up 1.9 km south first delays the first customer by about 3.8 km, over 10 minutes
+			name:  "delays the first customer too much",
+			trip:  activeTrip(first),
+			order: tripOrder(-0.0171, 0, 0.018, 0),
+			want:  false,
+		},
+		{
+			name:  "delays the first customer a little",
+			trip:  activeTrip(first),
+			order: tripOrder(-0.009, 0, 0.018, 0),
+			want:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, canBatch(tt.trip, tt.order))
+		})
+	}
+}
@@ -18,11 +18,12 @@ type CreateUserRequest struct {
 	Latitude  string `json:"latitude" validate:"required,latitude"`
 	Longitude string `json:"longitude" validate:"required,longitude"`
 	Address   string `json:"address" validate:"required"`
+	Pincode   string `json:"pincode" validate:"omitempty,pincode"`
 }
 
 // UpdateUserRequest a type for updating a user request
 type UpdateUserRequest struct {
-	Id      primitive.ObjectID `json:"id" validate:"required"` // required a mongodb objectId
+	Id      primitive.ObjectID `json:"-" validate:"required"` // from the token
 	Name    string             `json:"name" validate:"required"`
 	EmailId string             `json:"email_id" validate:"required,email"`
 
@@ -32,6 +33,7 @@ type UpdateUserRequest struct {
 	Latitude  string `json:"latitude" validate:"required,latitude"`
 	Longitude string `json:"longitude" validate:"required,longitude"`
 	Address   string `json:"address" validate:"required"`
+	Pincode   string `json:"pincode" validate:"omitempty,pincode"`
 }
 
 type UserParam struct {
@@ -40,7 +42,7 @@ type UserParam struct {
 
 // GetUserRequest a type for getting a user request
 type GetUserRequest struct {
-	Id primitive.ObjectID `query:"id" validate:"required"` // required a mongodb objectId
+	Id primitive.ObjectID `validate:"required"` // from the token
 }
 
 // CreateUser register new user
@@ -53,6 +55,7 @@ func (request *CreateUserRequest) CreateUser(ctx context.Context, param UserPara
 		PhoneNumber: request.PhoneNumber,
 		Location:    model.NewLocationFromLongLatStr(request.Longitude, request.Latitude),
 		Address:     request.Address,
+		Pincode:     request.Pincode,
 		Status:      "ACTIVE",
 		CreatedAt:   currTime,
 		UpdatedAt:   currTime,
@@ -75,12 +78,18 @@ func (request *UpdateUserRequest) UpdateUser(ctx context.Context, param UserPara
 		return err
 	}
 
+	// a new number has to be verified again
+	if user.PhoneNumber != request.PhoneNumber {
+		user.Verified = false
+	}
+
 	// Update UserId
 	user.Name = request.Name
 	user.EmailId = request.EmailId
 	user.PhoneNumber = request.PhoneNumber
 	user.Location = model.NewLocationFromLongLatStr(request.Longitude, request.Latitude)
 	user.Address = request.Address
+	user.Pincode = request.Pincode
 	user.UpdatedAt = currTime
 
 	err = param.Repository.UpdateUser(ctx, user)
@@ -2,7 +2,8 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"food-eats/cmd/web/custom-errors"
 	"food-eats/cmd/web/db"
 	"food-eats/cmd/web/model"
 	"github.com/redis/go-redis/v9"
@@ -13,14 +14,21 @@ import (
 	"time"
 )
 
+// RiderWebSocketReq rider connecting, the rider is taken from the token of the upgrade request
 type RiderWebSocketReq struct {
-	RiderId string `json:"rider_id"`
+	LastSeq string `query:"last_seq"` // seq of the last message received before reconnecting
+}
+
+// UserWebSocketReq user connecting, the user is taken from the token of the upgrade request
+type UserWebSocketReq struct {
+	LastSeq string `query:"last_seq"` // seq of the last message received before reconnecting
 }
 
 type NewLocationInfo struct {
-	Message   string `json:"msg"`
-	Latitude  string `json:"latitude" validate:"latitude"`
-	Longitude string `json:"longitude" validate:"longitude"`
+	Message   string             `json:"msg"`
+	OrderId   primitive.ObjectID `json:"order_id"`
+	Latitude  string             `json:"latitude" validate:"latitude"`
+	Longitude string             `json:"longitude" validate:"longitude"`
 }
 
 type LocationSyncReq struct {
@@ -34,98 +42,152 @@ type AcceptOrderId struct {
 	Longitude string             `json:"longitude" validate:"longitude"`
 }
 
-type TrackOrder struct {
-	OrderId primitive.ObjectID `json:"order_id"`
-}
-
-type RiderWebsocketMessage struct {
-	Type string      `json:"type"`
-	Body interface{} `json:"body"`
-}
-
-func ProcessRiderMessage(rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, message []byte, ctx context.Context) {
-	var msg RiderWebsocketMessage
-	if err := json.Unmarshal(message, &msg); err != nil {
-		// Handle error
-		return
+// ProcessRiderMessage handles a message of the rider, every message is acknowledged or answered with an error.
+// ErrAbusiveConnection is returned when the connection has to be closed.
+func ProcessRiderMessage(rm model.WebSocketManager, or OrderParam, limiter *MessageLimiter, riderId primitive.ObjectID, message []byte, ctx context.Context) error {
+	envelope, err := parseEnvelope(message)
+	if limitErr := limiter.allow(ctx, envelope.Type); limitErr != nil {
+		if errors.Is(limitErr, ErrAbusiveConnection) {
+			return limitErr
+		}
+		err = limitErr
+	}
+	if err == nil {
+		err = handleRiderMessage(ctx, rm, or, riderId, envelope)
 	}
-
-	messageType := msg.Type
-	body, err := json.Marshal(msg.Body)
 	if err != nil {
-		return
+		slog.InfoContext(ctx, "rider message rejected", "type", envelope.Type, "id", envelope.Id, "error", err.Error())
 	}
+	replyToRider(rm, riderId, envelope, err)
+	return nil
+}
 
-	switch messageType {
+func handleRiderMessage(ctx context.Context, rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, envelope Envelope) error {
+	switch envelope.Type {
 	case "accept_order":
 		acceptReq := AcceptOrderId{}
-		err = json.Unmarshal(body, &acceptReq)
-		if err != nil {
-			return
+		if err := decodePayload(envelope, &acceptReq); err != nil {
+			return err
 		}
-		handleOrderAcceptance(ctx, rm, or, riderId, acceptReq)
+		return handleOrderAcceptance(ctx, rm, or, riderId, acceptReq)
 	case "send_location":
 		syncReq := LocationSyncReq{}
-		err = json.Unmarshal(body, &syncReq)
-		if err != nil {
-			return
+		if err := decodePayload(envelope, &syncReq); err != nil {
+			return err
 		}
-		handleSendingLocation(ctx, rm, riderId, syncReq)
+		handleRiderLocation(ctx, rm, or, riderId, syncReq)
+		return nil
 	case "delivered":
-		acceptReq := AcceptOrderId{}
-		err = json.Unmarshal(body, &acceptReq)
-		if err != nil {
-			return
+		deliveredReq := DeliveredReq{}
+		if err := decodePayload(envelope, &deliveredReq); err != nil {
+			return err
+		}
+		return handleOrderDelivered(ctx, rm, or, riderId, deliveredReq)
+	case "update_stop":
+		stopReq := StopStatusReq{}
+		if err := decodePayload(envelope, &stopReq); err != nil {
+			return err
+		}
+		return handleStopStatus(ctx, rm, or, riderId, stopReq)
+	case "arrived_at_restaurant":
+		pickupReq := PickupReq{}
+		if err := decodePayload(envelope, &pickupReq); err != nil {
+			return err
 		}
-		handleOrderDelivered(ctx, rm, or, acceptReq)
+		return handleArrivedAtRestaurant(ctx, rm, or, riderId, pickupReq)
+	case "picked_up":
+		pickupReq := PickupReq{}
+		if err := decodePayload(envelope, &pickupReq); err != nil {
+			return err
+		}
+		return handlePickedUp(ctx, rm, or, riderId, pickupReq)
 	default:
-		return
+		return newMessageError(ErrCodeUnknownType, "unknown message type "+envelope.Type)
 	}
 }
 
-func ProcessUserMessage(rm *model.SocketManager, riderId primitive.ObjectID, message []byte, ctx context.Context) {
-	var msg RiderWebsocketMessage
-	if err := json.Unmarshal(message, &msg); err != nil {
-		// Handle error
-		return
+// ProcessUserMessage handles a message of the user, every message is acknowledged or answered with an error.
+// ErrAbusiveConnection is returned when the connection has to be closed.
+func ProcessUserMessage(rm model.WebSocketManager, or OrderParam, limiter *MessageLimiter, userId primitive.ObjectID, message []byte, ctx context.Context) error {
+	envelope, err := parseEnvelope(message)
+	if limitErr := limiter.allow(ctx, envelope.Type); limitErr != nil {
+		if errors.Is(limitErr, ErrAbusiveConnection) {
+			return limitErr
+		}
+		err = limitErr
 	}
-
-	messageType := msg.Type
-	acceptReq, ok := msg.Body.(TrackOrder)
-	if !ok {
-		return
+	if err == nil {
+		err = handleUserMessage(ctx, rm, or, userId, envelope)
 	}
+	if err != nil {
+		slog.InfoContext(ctx, "user message rejected", "type", envelope.Type, "id", envelope.Id, "error", err.Error())
+	}
+	replyToUser(rm, userId, envelope, err)
+	return nil
+}
 
-	switch messageType {
-	case "track_order":
-		handleTrackOrder(rm, acceptReq, ctx)
+func handleUserMessage(ctx context.Context, rm model.WebSocketManager, or OrderParam, userId primitive.ObjectID, envelope Envelope) error {
+	switch envelope.Type {
+	case "subscribe_order":
+		subscribeReq := SubscribeOrderReq{}
+		if err := decodePayload(envelope, &subscribeReq); err != nil {
+			return err
+		}
+		return handleSubscribeOrder(ctx, rm, or, userId, subscribeReq)
+	case "unsubscribe_order":
+		unsubscribeReq := UnsubscribeOrderReq{}
+		if err := decodePayload(envelope, &unsubscribeReq); err != nil {
+			return err
+		}
+		return handleUnsubscribeOrder(ctx, userId, unsubscribeReq)
 	default:
-		return
+		return newMessageError(ErrCodeUnknownType, "unknown message type "+envelope.Type)
 	}
 }
 
-func handleOrderAcceptance(ctx context.Context, rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, acceptReq AcceptOrderId) {
+func handleOrderAcceptance(ctx context.Context, rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, acceptReq AcceptOrderId) error {
 	// Extract order ID from message
 	orderId := acceptReq.OrderId
 	if orderId.IsZero() {
-		return
+		return newMessageError(ErrCodeInvalidPayload, "order_id is required")
 	}
 	order, err := or.OrderRepo.GetOrder(ctx, orderId)
 	if err != nil {
 		slog.ErrorContext(ctx, "error in fetching order", "error", err.Error(), "order", order)
-		go rm.BroadcastToRiders("error assigning", []primitive.ObjectID{riderId})
-		return
+		return err
 	}
 
-	if order.Status == "RIDER_ASSIGNED" {
+	if !order.RiderId.IsZero() {
 		slog.Info("order already assigned")
-		go rm.BroadcastToRiders("order already assigned", []primitive.ObjectID{riderId})
-		return
+		return newMessageError(ErrCodeConflict, "order already assigned")
+	}
+	if order.Status == "CANCELLED" {
+		return errOrderCancelled
+	}
+
+	// the connection of a suspended rider stays open until the rider reconnects
+	rider, err := or.RiderRepo.GetRider(ctx, riderId)
+	if err != nil {
+		slog.ErrorContext(ctx, "error in fetching rider", "error", err.Error())
+		return err
 	}
+	if rider.Status == "SUSPENDED" {
+		return newMessageError(ErrCodeForbidden, "rider suspended")
+	}
+
+	// a rider already on a trip can only take orders which fit in the trip
+	trip, err := or.TripRepo.GetActiveTrip(ctx, riderId)
+	if err != nil && !errors.Is(err, custom_errors.ClientError) {
+		slog.ErrorContext(ctx, "error in fetching trip", "error", err.Error())
+		return err
+	}
+	if !trip.Id.IsZero() && !canBatch(trip, order) {
+		return newMessageError(ErrCodeConflict, "order can not be batched with current trip")
+	}
+
 	redisConn, err := db.RedisConnFromPool()
 	if err != nil {
-		go rm.BroadcastToRiders("error assigning", []primitive.ObjectID{riderId})
-		return
+		return err
 	}
 
 	defer db.Close(redisConn)
@@ -133,18 +195,55 @@ func handleOrderAcceptance(ctx context.Context, rm model.WebSocketManager, or Or
 	// handle concurrency
 	result, err := redisConn.Incr(ctx, "order_status:"+orderId.Hex()).Result()
 	if err != nil {
-		go rm.BroadcastToRiders("error assigning", []primitive.ObjectID{riderId})
-		return
+		return err
 	}
 
 	if result != 1 {
 		slog.Info("order already assigned")
-		go rm.BroadcastToRiders("order already assigned", []primitive.ObjectID{riderId})
-		return
+		return newMessageError(ErrCodeConflict, "order already assigned")
+	}
+
+	// the order can be accepted again when assigning it to this rider fails
+	release := func() {
+		if err := redisConn.Del(ctx, "order_status:"+orderId.Hex()).Err(); err != nil {
+			slog.ErrorContext(ctx, "error in releasing order", "error", err.Error())
+		}
 	}
 
 	currTime := time.Now()
+	order.RiderId = riderId
+	order.Status = "RIDER_ASSIGNED"
+	order.DeliveryStarted = currTime
+	order.UpdatedAt = currTime
+	order.Latitude, _ = strconv.ParseFloat(acceptReq.Latitude, 64)
+	order.Longitude, _ = strconv.ParseFloat(acceptReq.Longitude, 64)
+	order.DeliveryOtp, err = generateDeliveryOtp()
+	if err != nil {
+		slog.ErrorContext(ctx, "error in generating delivery otp", "err", err.Error())
+		release()
+		return err
+	}
+
+	trip, err = addOrderToTrip(ctx, or, trip, order, order.Latitude, order.Longitude)
+	if err != nil {
+		slog.ErrorContext(ctx, "error in adding order to trip", "err", err.Error())
+		release()
+		return err
+	}
+	order.TripId = trip.Id
+
+	err = or.OrderRepo.UpdateOrder(ctx, order)
+	if err != nil {
+		slog.ErrorContext(ctx, "error in updating order ", "err", err.Error(), "order", "order")
+		// the stops of the order must not stay on a trip the order is not assigned to
+		if _, err := removeOrderFromTrip(ctx, or.TripRepo, trip.Id, order.Id); err != nil {
+			slog.ErrorContext(ctx, "error in removing order from trip", "err", err.Error())
+		}
+		release()
+		return err
+	}
 
+	// other riders are told only once the order is assigned for sure
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func(rConn *redis.Conn, rm model.WebSocketManager, wg *sync.WaitGroup) {
@@ -166,108 +265,98 @@ func handleOrderAcceptance(ctx context.Context, rm model.WebSocketManager, or Or
 			Message: "Already picked order",
 			OrderId: order.Id,
 		}
-		msg, err := json.Marshal(newOrder)
-		if err != nil {
-			return
-		}
-		rm.BroadcastToRiders(string(msg), rIds)
+		sendToRiders(rm, OrderTakenMessage, newOrder, rIds...)
 
 	}(redisConn, rm, &wg)
 
-	order.RiderId = riderId
-	order.Status = "RIDER_ASSIGNED"
-	order.DeliveryStarted = currTime
-	order.UpdatedAt = currTime
-	order.Latitude, _ = strconv.ParseFloat(acceptReq.Latitude, 64)
-	order.Longitude, _ = strconv.ParseFloat(acceptReq.Longitude, 64)
-
-	// sending back the acknowledgement
-	rm.BroadcastToRiders("Order accepted", []primitive.ObjectID{riderId})
-
-	err = or.OrderRepo.UpdateOrder(ctx, order)
-	if err != nil {
-		slog.ErrorContext(ctx, "error in updating order ", "err", err.Error(), "order", "order")
-		return
-	}
-	handleSendingLocation(ctx, rm, riderId, LocationSyncReq{acceptReq.Latitude, acceptReq.Longitude})
+	sendTripToRider(rm, trip, "Trip updated")
+	sendDeliveryOtpToUser(rm, order)
+	sendOrderToRestaurant(rm, RiderAssignedMessage, order, "Rider assigned")
+	handleSendingLocation(rm, order.Id, LocationSyncReq{acceptReq.Latitude, acceptReq.Longitude})
 	wg.Wait()
+	return nil
 }
 
-func handleOrderDelivered(ctx context.Context, rm model.WebSocketManager, or OrderParam, acceptReq AcceptOrderId) {
+func handleOrderDelivered(ctx context.Context, rm model.WebSocketManager, or OrderParam, riderId primitive.ObjectID, deliveredReq DeliveredReq) error {
 	// Extract order ID from message
-	orderId := acceptReq.OrderId
+	orderId := deliveredReq.OrderId
 	if orderId.IsZero() {
-		return
+		return newMessageError(ErrCodeInvalidPayload, "order_id is required")
 	}
 	order, err := or.OrderRepo.GetOrder(ctx, orderId)
 	if err != nil {
 		slog.ErrorContext(ctx, "error in fetching order", "error", err.Error(), "order", order)
-		return
+		return err
 	}
 
 	if order.Status == "DELIVERED" {
 		slog.Info("order already delivered")
-		return
+		return newMessageError(ErrCodeConflict, "order already delivered")
+	}
+	if order.Status == "CANCELLED" {
+		return errOrderCancelled
 	}
 	currTime := time.Now()
 
+	attempts := order.DeliveryOtpAttempts
+	if err := verifyDeliveryProof(&order, riderId, deliveredReq); err != nil {
+		slog.InfoContext(ctx, "delivery proof rejected", "error", err.Error(), "order_id", order.Id.Hex(), "rider_id", riderId.Hex())
+		// keeping track of wrong otp attempts
+		if order.DeliveryOtpAttempts != attempts {
+			order.UpdatedAt = currTime
+			if err := or.OrderRepo.UpdateOrder(ctx, order); err != nil {
+				slog.ErrorContext(ctx, "update order failed", "error", err.Error())
+			}
+		}
+		return err
+	}
+
 	order.Status = "DELIVERED"
 	order.DeliveredAt = currTime
 	order.UpdatedAt = currTime
 	order.DeliveryTime = order.DeliveredAt.Sub(order.AcceptedAt).Seconds()
-	order.Latitude, _ = strconv.ParseFloat(acceptReq.Latitude, 64)
-	order.Longitude, _ = strconv.ParseFloat(acceptReq.Longitude, 64)
+	order.Latitude, _ = strconv.ParseFloat(deliveredReq.Latitude, 64)
+	order.Longitude, _ = strconv.ParseFloat(deliveredReq.Longitude, 64)
 
 	err = or.OrderRepo.UpdateOrder(ctx, order)
 	if err != nil {
 		slog.ErrorContext(ctx, "update order failed", "error", err.Error())
-		return
+		return err
 	}
-	handleSendingDeliveredStatus(ctx, rm, order.UserId, LocationSyncReq{acceptReq.Latitude, acceptReq.Longitude})
+
+	if !order.TripId.IsZero() {
+		trip, err := updateTripStop(ctx, or, order.TripId, order.Id, "DROP", "COMPLETED")
+		if err != nil {
+			slog.ErrorContext(ctx, "error in completing trip stop", "error", err.Error())
+		} else if trip.Status == "ACTIVE" {
+			sendTripToRider(rm, trip, "Trip updated")
+		}
+	}
+	handleSendingDeliveredStatus(rm, order.Id, LocationSyncReq{deliveredReq.Latitude, deliveredReq.Longitude})
 
 	// running a go routine to update delivery time
 	// can do it async via some queue
 	go updateDeliveryTime(or, order)
+	return nil
 }
 
-func handleSendingLocation(ctx context.Context, rm model.WebSocketManager, userId primitive.ObjectID, req LocationSyncReq) {
+// handleSendingLocation sends the rider location to the users tracking the order
+func handleSendingLocation(rm model.WebSocketManager, orderId primitive.ObjectID, req LocationSyncReq) {
 	updateMessage := NewLocationInfo{
 		Message:   "in between",
+		OrderId:   orderId,
 		Latitude:  req.Latitude,
 		Longitude: req.Longitude,
 	}
-	msg, err := json.Marshal(updateMessage)
-	if err != nil {
-		return
-	}
-	rm.BroadcastToUsers(string(msg), []primitive.ObjectID{userId})
+	sendToOrderWatchers(rm, orderId, LocationMessage, updateMessage)
 }
 
-func handleSendingDeliveredStatus(ctx context.Context, rm model.WebSocketManager, userId primitive.ObjectID, req LocationSyncReq) {
+func handleSendingDeliveredStatus(rm model.WebSocketManager, orderId primitive.ObjectID, req LocationSyncReq) {
 	updateMessage := NewLocationInfo{
-		Message: "rider reached location",
-	}
-	msg, err := json.Marshal(updateMessage)
-	if err != nil {
-		return
+		Message:   "rider reached location",
+		OrderId:   orderId,
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
 	}
-	rm.BroadcastToRiders(string(msg), []primitive.ObjectID{userId})
-}
-
-func handleTrackOrder(rm *model.SocketManager, trackOrder TrackOrder, ctx context.Context) {
-	// todo no need of this
-	//orderId := trackOrder.OrderId
-	//if orderId.IsZero() {
-	//	return
-	//}
-	//
-	//order, err := rm.OrderRepo.GetOrder(ctx, orderId)
-	//if err != nil {
-	//	slog.ErrorContext(ctx, "error tracking order", err.Error())
-	//	return
-	//}
-	//
-	//userId := order.UserId
-	//
-	//rm.BroadcastToUsers()
+	sendToOrderWatchers(rm, orderId, LocationMessage, updateMessage)
 }
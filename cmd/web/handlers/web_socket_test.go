@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"food-eats/cmd/web/handlers"
+	"food-eats/cmd/web/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordingSocketManager keeps the messages sent to riders
+type recordingSocketManager struct {
+	model.WebSocketManager
+	riderMessages []string
+}
+
+func (r *recordingSocketManager) BroadcastToRiders(message string, _ []primitive.ObjectID) {
+	r.riderMessages = append(r.riderMessages, message)
+}
+
+func TestProcessRiderMessage_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		code    string
+	}{
+		{"invalid json", `{`, handlers.ErrCodeInvalidMessage},
+		{"old version", `{"v":0,"type":"accept_order","id":"1"}`, handlers.ErrCodeUnsupportedVersion},
+		{"unknown type", `{"v":1,"type":"dance","id":"1"}`, handlers.ErrCodeUnknownType},
+		{"missing payload", `{"v":1,"type":"accept_order","id":"1"}`, handlers.ErrCodeInvalidPayload},
+		{"missing order", `{"v":1,"type":"accept_order","id":"1","payload":{}}`, handlers.ErrCodeInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := &recordingSocketManager{}
+			riderId := primitive.NewObjectID()
+			err := handlers.ProcessRiderMessage(sm, handlers.OrderParam{}, handlers.NewRiderLimiter(riderId), riderId, []byte(tt.message), context.TODO())
+			assert.NoError(t, err)
+			assert.Len(t, sm.riderMessages, 1)
+
+			var envelope handlers.Envelope
+			assert.NoError(t, json.Unmarshal([]byte(sm.riderMessages[0]), &envelope))
+			assert.Equal(t, handlers.ErrorMessage, envelope.Type)
+			assert.Equal(t, handlers.MessageVersion, envelope.Version)
+
+			var payload handlers.ErrorPayload
+			assert.NoError(t, json.Unmarshal(envelope.Payload, &payload))
+			assert.Equal(t, tt.code, payload.Code)
+		})
+	}
+}
+
+func TestProcessRiderMessage_CorrelationId(t *testing.T) {
+	sm := &recordingSocketManager{}
+	riderId := primitive.NewObjectID()
+	err := handlers.ProcessRiderMessage(sm, handlers.OrderParam{}, handlers.NewRiderLimiter(riderId), riderId, []byte(`{"v":1,"type":"dance","id":"msg-1"}`), context.TODO())
+	assert.NoError(t, err)
+
+	var envelope handlers.Envelope
+	assert.NoError(t, json.Unmarshal([]byte(sm.riderMessages[0]), &envelope))
+	assert.Equal(t, "msg-1", envelope.CorrelationId)
+	assert.NotEmpty(t, envelope.Id)
+}
+
+func TestGetMessageSchemas(t *testing.T) {
+	schemas := handlers.GetMessageSchemas()
+	assert.Contains(t, schemas.Client, "accept_order")
+	assert.Contains(t, schemas.Server, handlers.ErrorMessage)
+	assert.ElementsMatch(t, []string{"v", "type", "id"}, schemas.Envelope["required"])
+
+	otp := schemas.Server[handlers.DeliveryOtpMessage].(map[string]interface{})
+	assert.Contains(t, otp["properties"], "order_id")
+}
+
+func TestProcessRiderMessage_RateLimited(t *testing.T) {
+	sm := &recordingSocketManager{}
+	riderId := primitive.NewObjectID()
+	limiter := handlers.NewRiderLimiter(riderId)
+	message := []byte(`{"v":1,"type":"dance","id":"msg-1"}`)
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = handlers.ProcessRiderMessage(sm, handlers.OrderParam{}, limiter, riderId, message, context.TODO())
+	}
+	assert.ErrorIs(t, err, handlers.ErrAbusiveConnection)
+
+	// the burst is answered normally, then messages are rejected as rate limited until the connection is closed
+	codes := map[string]int{}
+	for _, msg := range sm.riderMessages {
+		var envelope handlers.Envelope
+		assert.NoError(t, json.Unmarshal([]byte(msg), &envelope))
+		var payload handlers.ErrorPayload
+		assert.NoError(t, json.Unmarshal(envelope.Payload, &payload))
+		codes[payload.Code]++
+	}
+	assert.Equal(t, 5, codes[handlers.ErrCodeUnknownType])
+	assert.Equal(t, 10, codes[handlers.ErrCodeRateLimited])
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/time/rate"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// violations a connection may have in a burst before it is disconnected
+	maxViolations = 10
+	// violations are forgiven at this rate, a client sending slightly too fast keeps its connection
+	violationRecovery = 6 * time.Second
+)
+
+// ErrAbusiveConnection the connection kept sending messages after being rate limited and has to be closed
+var ErrAbusiveConnection = errors.New("too many rate limited messages")
+
+// messageLimit token bucket of a message type, every message takes a token
+type messageLimit struct {
+	Every time.Duration // a token is added every interval
+	Burst int
+}
+
+// messageLimits of the message types causing mongo or redis work, other types get defaultMessageLimit
+var messageLimits = map[string]messageLimit{
+	// the app sends the location every few seconds while on a trip
+	"send_location": {Every: time.Second, Burst: 5},
+	"accept_order":  {Every: time.Second, Burst: 3},
+	"delivered":     {Every: 2 * time.Second, Burst: 3},
+	"update_stop":   {Every: time.Second, Burst: 5},
+	"picked_up":     {Every: 2 * time.Second, Burst: 3},
+
+	"arrived_at_restaurant": {Every: 2 * time.Second, Burst: 3},
+	"subscribe_order":       {Every: time.Second, Burst: 10},
+}
+
+// defaultMessageLimit of every other type, including invalid and unknown messages
+var defaultMessageLimit = messageLimit{Every: time.Second, Burst: 5}
+
+// MessageLimiter rate limits the messages of one connection per message type.
+// Limited messages are answered with an error, a connection with repeated violations is disconnected.
+type MessageLimiter struct {
+	recipient  string
+	id         primitive.ObjectID
+	limiters   map[string]*rate.Limiter
+	violations *rate.Limiter
+	count      int
+	lock       sync.Mutex
+}
+
+// NewRiderLimiter limiter of a new connection of the rider
+func NewRiderLimiter(riderId primitive.ObjectID) *MessageLimiter {
+	return newMessageLimiter(riderRecipient, riderId)
+}
+
+// NewUserLimiter limiter of a new connection of the user
+func NewUserLimiter(userId primitive.ObjectID) *MessageLimiter {
+	return newMessageLimiter(userRecipient, userId)
+}
+
+// NewRestaurantLimiter limiter of a new connection of the restaurant dashboard
+func NewRestaurantLimiter(restaurantId primitive.ObjectID) *MessageLimiter {
+	return newMessageLimiter(restaurantRecipient, restaurantId)
+}
+
+// newMessageLimiter recipient and id are only used in logs
+func newMessageLimiter(recipient string, id primitive.ObjectID) *MessageLimiter {
+	return &MessageLimiter{
+		recipient:  recipient,
+		id:         id,
+		limiters:   map[string]*rate.Limiter{},
+		violations: rate.NewLimiter(rate.Every(violationRecovery), maxViolations),
+	}
+}
+
+// allow takes a token of the message type, the returned error is a MessageError or ErrAbusiveConnection
+func (ml *MessageLimiter) allow(ctx context.Context, msgType string) error {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+
+	limit, ok := messageLimits[msgType]
+	if !ok {
+		limit = defaultMessageLimit
+		msgType = "" // unknown types share a bucket, so random types can not create buckets
+	}
+	limiter, ok := ml.limiters[msgType]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(limit.Every), limit.Burst)
+		ml.limiters[msgType] = limiter
+	}
+	if limiter.Allow() {
+		return nil
+	}
+
+	ml.count++
+	if !ml.violations.Allow() {
+		slog.WarnContext(ctx, "disconnecting abusive websocket connection",
+			"recipient", ml.recipient, "id", ml.id, "type", msgType, "violations", ml.count)
+		return ErrAbusiveConnection
+	}
+	slog.WarnContext(ctx, "websocket message rate limited",
+		"recipient", ml.recipient, "id", ml.id, "type", msgType, "violations", ml.count)
+	return newMessageError(ErrCodeRateLimited, "too many messages, slow down")
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"time"
+)
+
+// MessageVersion version of the websocket envelope, bumped on breaking changes of the envelope or a payload
+const MessageVersion = 1
+
+// message types sent by the server
+const (
+	AckMessage         = "ack"
+	ErrorMessage       = "error"
+	NewOrderMessage    = "new_order"
+	OrderTakenMessage  = "order_taken"
+	TripUpdatedMessage = "trip_updated"
+	OrderStatusMessage = "order_status"
+	StopUpdatedMessage = "stop_updated"
+	LocationMessage    = "rider_location"
+	DeliveryOtpMessage = "delivery_otp"
+	ResyncMessage      = "resync"
+
+	// sent to restaurant dashboards
+	OrderPlacedMessage    = "order_placed"
+	OrderCancelledMessage = "order_cancelled"
+	RiderAssignedMessage  = "rider_assigned"
+	RiderArrivedMessage   = "rider_arrived"
+)
+
+// error codes of ErrorPayload
+const (
+	ErrCodeInvalidMessage     = "invalid_message"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeInvalidPayload     = "invalid_payload"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeConflict           = "conflict"
+	ErrCodeInvalidState       = "invalid_state" // order or trip is not in a state allowing the message
+	ErrCodeInvalidProof       = "invalid_proof"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeInternal           = "internal"
+)
+
+// Envelope every websocket message in either direction, payload depends on the type.
+// Replies to a client message carry the id of that message as correlation id.
+// Server messages which can be resumed carry a sequence, increasing per recipient, to send back on reconnect.
+type Envelope struct {
+	Version       int             `json:"v" validate:"required"`
+	Type          string          `json:"type" validate:"required"`
+	Id            string          `json:"id" validate:"required"`
+	CorrelationId string          `json:"correlation_id,omitempty"`
+	Seq           string          `json:"seq,omitempty"`
+	Timestamp     time.Time       `json:"timestamp"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+// AckPayload client message was processed
+type AckPayload struct {
+	Type string `json:"type"` // type of the acknowledged message
+}
+
+// ErrorPayload client message was rejected
+type ErrorPayload struct {
+	Type    string `json:"type,omitempty"` // type of the rejected message
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// MessageError error of a client message, sent back to the sender as an error message
+type MessageError struct {
+	Code    string
+	Message string
+}
+
+func (e *MessageError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newMessageError(code, message string) error {
+	return &MessageError{Code: code, Message: message}
+}
+
+// errOrderCancelled rider message about an order cancelled in the meantime
+var errOrderCancelled = errors.Join(custom_errors.PreconditionError, newMessageError(ErrCodeInvalidState, "order cancelled"))
+
+// errorPayload sender facing error, unexpected errors are not leaked to the client
+func errorPayload(msgType string, err error) ErrorPayload {
+	var msgErr *MessageError
+	if errors.As(err, &msgErr) {
+		return ErrorPayload{Type: msgType, Code: msgErr.Code, Message: msgErr.Message}
+	}
+	return ErrorPayload{Type: msgType, Code: ErrCodeInternal, Message: "something went wrong"}
+}
+
+// newEnvelope wraps the payload in an envelope of the current version
+func newEnvelope(msgType string, correlationId string, payload interface{}) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		Version:       MessageVersion,
+		Type:          msgType,
+		Id:            primitive.NewObjectID().Hex(),
+		CorrelationId: correlationId,
+		Timestamp:     time.Now(),
+		Payload:       body,
+	})
+}
+
+// parseEnvelope decodes a client message, the returned error is a MessageError
+func parseEnvelope(message []byte) (Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return Envelope{}, newMessageError(ErrCodeInvalidMessage, "message is not a valid envelope")
+	}
+	if envelope.Version != MessageVersion {
+		return envelope, newMessageError(ErrCodeUnsupportedVersion, "supported version is 1")
+	}
+	if envelope.Type == "" || envelope.Id == "" {
+		return envelope, newMessageError(ErrCodeInvalidMessage, "type and id are required")
+	}
+	return envelope, nil
+}
+
+// decodePayload decodes the payload of a client message into req
+func decodePayload(envelope Envelope, req interface{}) error {
+	if len(envelope.Payload) == 0 {
+		return newMessageError(ErrCodeInvalidPayload, "payload is required")
+	}
+	if err := json.Unmarshal(envelope.Payload, req); err != nil {
+		return newMessageError(ErrCodeInvalidPayload, err.Error())
+	}
+	return nil
+}
+
+// sendToRiders sends a server message to the riders, riders reconnecting shortly after get it on resume
+func sendToRiders(rm model.WebSocketManager, msgType string, payload interface{}, riderIds ...primitive.ObjectID) {
+	deliver(rm, riderRecipient, msgType, payload, riderIds)
+}
+
+// sendToUsers sends a server message to the users, users reconnecting shortly after get it on resume
+func sendToUsers(rm model.WebSocketManager, msgType string, payload interface{}, userIds ...primitive.ObjectID) {
+	deliver(rm, userRecipient, msgType, payload, userIds)
+}
+
+// sendToRestaurants sends a server message to the restaurant dashboards
+func sendToRestaurants(rm model.WebSocketManager, msgType string, payload interface{}, restaurantIds ...primitive.ObjectID) {
+	deliver(rm, restaurantRecipient, msgType, payload, restaurantIds)
+}
+
+// newReply ack of the client message, or the error when it was rejected
+func newReply(envelope Envelope, err error) ([]byte, error) {
+	if err != nil {
+		return newEnvelope(ErrorMessage, envelope.Id, errorPayload(envelope.Type, err))
+	}
+	return newEnvelope(AckMessage, envelope.Id, AckPayload{Type: envelope.Type})
+}
+
+// replyToRider acknowledges the client message of the rider, or sends back the error
+func replyToRider(rm model.WebSocketManager, riderId primitive.ObjectID, envelope Envelope, err error) {
+	msg, err := newReply(envelope, err)
+	if err != nil {
+		slog.Error("error creating websocket reply", "err", err)
+		return
+	}
+	rm.BroadcastToRiders(string(msg), []primitive.ObjectID{riderId})
+}
+
+// replyToUser acknowledges the client message of the user, or sends back the error
+func replyToUser(rm model.WebSocketManager, userId primitive.ObjectID, envelope Envelope, err error) {
+	msg, err := newReply(envelope, err)
+	if err != nil {
+		slog.Error("error creating websocket reply", "err", err)
+		return
+	}
+	rm.BroadcastToUsers(string(msg), []primitive.ObjectID{userId})
+}
+
+// replyToRestaurant acknowledges the client message of the restaurant dashboard, or sends back the error
+func replyToRestaurant(rm model.WebSocketManager, restaurantId primitive.ObjectID, envelope Envelope, err error) {
+	msg, err := newReply(envelope, err)
+	if err != nil {
+		slog.Error("error creating websocket reply", "err", err)
+		return
+	}
+	rm.BroadcastToRestaurants(string(msg), []primitive.ObjectID{restaurantId})
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"food-eats/cmd/web/db"
+	"food-eats/cmd/web/model"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+const (
+	riderRecipient      = "rider"
+	userRecipient       = "user"
+	restaurantRecipient = "restaurant"
+	orderRecipient      = "order" // streams tracking a single order
+
+	// messages are kept for resuming only this long, a client away for longer has to refetch its state
+	resumeWindow = 2 * time.Minute
+	// messages kept per recipient
+	resumeMaxLen = 200
+	// messages replayed on a single reconnect, a client which missed more has to refetch its state
+	resumeBatch = 100
+
+	// messages waiting to be saved for resume, senders do not wait on redis
+	deliveryQueueSize = 1024
+	// queued messages saved in a single pipeline
+	deliveryBatch = 64
+)
+
+// reasons of ResyncPayload
+const (
+	ResyncTruncated = "truncated" // more messages were missed than are replayed
+	ResyncExpired   = "expired"   // the last seen message is no longer kept
+)
+
+// resumeTTL how long a message type is replayed after a reconnect, 0 messages are never replayed.
+// Offers go stale fast and only the latest location matters, so those are not worth replaying for long.
+var resumeTTL = map[string]time.Duration{
+	NewOrderMessage:   time.Minute,
+	OrderTakenMessage: time.Minute,
+	LocationMessage:   0,
+}
+
+// ResyncPayload missed messages can not be replayed, the client has to refetch its state
+type ResyncPayload struct {
+	Reason string `json:"reason"`
+}
+
+func resumeKey(recipient string, id primitive.ObjectID) string {
+	return "ws_stream:" + recipient + ":" + id.Hex()
+}
+
+// streamEntry message appended to the stream of a recipient
+type streamEntry struct {
+	key string
+	msg []byte
+	ttl time.Duration
+}
+
+// resumeStreams streams of the messages kept for resuming
+type resumeStreams interface {
+	// Append adds the entries to their streams and returns the entry ids
+	Append(ctx context.Context, entries []streamEntry) ([]string, error)
+	// Range returns at most count entries of the stream from start on, start included
+	Range(ctx context.Context, key string, start string, count int64) ([]redis.XMessage, error)
+}
+
+// delivery message waiting to be saved for resume and sent
+type delivery struct {
+	broadcast func(message string, ids []primitive.ObjectID)
+	recipient string
+	ids       []primitive.ObjectID
+	msg       []byte
+	ttl       time.Duration
+}
+
+// ResumableSockets WebSocketManager which keeps the messages sent to riders, users and restaurants for a while, so a
+// client reconnecting shortly after gets what it missed. Messages are queued and saved by a single worker started with
+// Run, messages sent through any other WebSocketManager are not kept for resume.
+type ResumableSockets struct {
+	model.WebSocketManager
+	streams    resumeStreams
+	deliveries chan delivery
+}
+
+// NewResumableSockets keeps the messages sent through sm in redis streams
+func NewResumableSockets(sm model.WebSocketManager) *ResumableSockets {
+	return newResumableSockets(sm, redisResumeStreams{})
+}
+
+func newResumableSockets(sm model.WebSocketManager, streams resumeStreams) *ResumableSockets {
+	return &ResumableSockets{
+		WebSocketManager: sm,
+		streams:          streams,
+		deliveries:       make(chan delivery, deliveryQueueSize),
+	}
+}
+
+// Run sends the queued messages until the context is done, saving whatever is queued at once in a single pipeline
+func (s *ResumableSockets) Run(ctx context.Context) {
+	for {
+		var batch []delivery
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-s.deliveries:
+			batch = append(batch, d)
+		}
+	drain:
+		for len(batch) < deliveryBatch {
+			select {
+			case d := <-s.deliveries:
+				batch = append(batch, d)
+			default:
+				break drain
+			}
+		}
+		sendSequenced(ctx, s.streams, batch)
+	}
+}
+
+// deliver sends the message to the recipients, through ResumableSockets it is queued to be appended to the stream of
+// every recipient and the stream entry id is the sequence of the message for that recipient. A single worker sends the
+// queued messages, so every recipient gets them in the order of their sequence. Delivery falls back to sending without
+// a sequence when the queue is full or redis fails.
+func deliver(rm model.WebSocketManager, recipient string, msgType string, payload interface{}, ids []primitive.ObjectID) {
+	if len(ids) == 0 {
+		return
+	}
+	broadcast := rm.BroadcastToRiders
+	switch recipient {
+	case userRecipient:
+		broadcast = rm.BroadcastToUsers
+	case restaurantRecipient:
+		broadcast = rm.BroadcastToRestaurants
+	case orderRecipient:
+		broadcast = rm.BroadcastToOrderStreams
+	}
+
+	msg, err := newEnvelope(msgType, "", payload)
+	if err != nil {
+		slog.Error("error creating websocket message", "type", msgType, "err", err)
+		return
+	}
+	ttl, ok := resumeTTL[msgType]
+	if !ok {
+		ttl = resumeWindow
+	}
+	sockets, resumable := rm.(*ResumableSockets)
+	if ttl == 0 || !resumable {
+		broadcast(string(msg), ids)
+		return
+	}
+
+	select {
+	case sockets.deliveries <- delivery{broadcast: broadcast, recipient: recipient, ids: ids, msg: msg, ttl: ttl}:
+	default:
+		slog.Error("websocket delivery queue is full, sending without resume", "type", msgType)
+		broadcast(string(msg), ids)
+	}
+}
+
+// sendSequenced appends the messages to the streams of their recipients and sends them with the entry id as sequence
+func sendSequenced(ctx context.Context, store resumeStreams, batch []delivery) {
+	var entries []streamEntry
+	for _, d := range batch {
+		for _, id := range d.ids {
+			entries = append(entries, streamEntry{key: resumeKey(d.recipient, id), msg: d.msg, ttl: d.ttl})
+		}
+	}
+	seqs, err := store.Append(ctx, entries)
+	if err != nil {
+		slog.Error("error saving websocket messages for resume", "count", len(entries), "err", err)
+		for _, d := range batch {
+			d.broadcast(string(d.msg), d.ids)
+		}
+		return
+	}
+
+	for _, d := range batch {
+		var envelope Envelope
+		if err := json.Unmarshal(d.msg, &envelope); err != nil {
+			seqs = seqs[len(d.ids):]
+			continue
+		}
+		for i, id := range d.ids {
+			envelope.Seq = seqs[i]
+			sequenced, err := json.Marshal(envelope)
+			if err != nil {
+				continue
+			}
+			d.broadcast(string(sequenced), []primitive.ObjectID{id})
+		}
+		seqs = seqs[len(d.ids):]
+	}
+}
+
+// ResumeRider sends the messages the rider missed after lastSeq to the new connection, when rm keeps them
+func ResumeRider(ctx context.Context, rm model.WebSocketManager, client *model.WebSocketClient, riderId primitive.ObjectID, lastSeq string) {
+	if sockets, ok := rm.(*ResumableSockets); ok {
+		replay(ctx, sockets.streams, riderRecipient, riderId, lastSeq, client.Send)
+	}
+}
+
+// ResumeUser sends the messages the user missed after lastSeq to the new connection, when rm keeps them
+func ResumeUser(ctx context.Context, rm model.WebSocketManager, client *model.WebSocketClient, userId primitive.ObjectID, lastSeq string) {
+	if sockets, ok := rm.(*ResumableSockets); ok {
+		replay(ctx, sockets.streams, userRecipient, userId, lastSeq, client.Send)
+	}
+}
+
+// ResumeRestaurant sends the messages the restaurant dashboard missed after lastSeq to the new connection, when rm
+// keeps them
+func ResumeRestaurant(ctx context.Context, rm model.WebSocketManager, client *model.WebSocketClient, restaurantId primitive.ObjectID, lastSeq string) {
+	if sockets, ok := rm.(*ResumableSockets); ok {
+		replay(ctx, sockets.streams, restaurantRecipient, restaurantId, lastSeq, client.Send)
+	}
+}
+
+// ResumeOrderStream sends the updates of the order missed after lastSeq to the new stream, when rm keeps them
+func ResumeOrderStream(ctx context.Context, rm model.WebSocketManager, client *model.WebSocketClient, orderId primitive.ObjectID, lastSeq string) {
+	if sockets, ok := rm.(*ResumableSockets); ok {
+		replay(ctx, sockets.streams, orderRecipient, orderId, lastSeq, client.Send)
+	}
+}
+
+// replay sends unexpired messages after lastSeq, messages sent while replaying may arrive in between,
+// so clients drop messages with a sequence not after the last one they have seen. When the missed messages can not
+// all be replayed, because there are too many or the last seen one is gone, only a resync message is sent.
+func replay(ctx context.Context, store resumeStreams, recipient string, id primitive.ObjectID, lastSeq string, send func([]byte) bool) {
+	if lastSeq == "" {
+		return
+	}
+
+	// the range is inclusive, the last seen message itself is skipped below, one more tells if there are too many
+	entries, err := store.Range(ctx, resumeKey(recipient, id), lastSeq, resumeBatch+2)
+	if err != nil {
+		slog.InfoContext(ctx, "error reading missed websocket messages", "error", err.Error(), "last_seq", lastSeq)
+		return
+	}
+	if len(entries) == 0 || entries[0].ID != lastSeq {
+		resync(ctx, recipient, id, ResyncExpired, send)
+		return
+	}
+	entries = entries[1:]
+	if len(entries) > resumeBatch {
+		resync(ctx, recipient, id, ResyncTruncated, send)
+		return
+	}
+
+	currTime := time.Now().UnixMilli()
+	replayed := 0
+	for _, entry := range entries {
+		expiresAt, _ := entry.Values["expires_at"].(string)
+		if at, err := strconv.ParseInt(expiresAt, 10, 64); err != nil || at < currTime {
+			continue
+		}
+		msg, ok := entry.Values["msg"].(string)
+		if !ok {
+			continue
+		}
+		var envelope Envelope
+		if err := json.Unmarshal([]byte(msg), &envelope); err != nil {
+			continue
+		}
+		envelope.Seq = entry.ID
+		sequenced, err := json.Marshal(envelope)
+		if err != nil {
+			continue
+		}
+		if !send(sequenced) {
+			return
+		}
+		replayed++
+	}
+	slog.InfoContext(ctx, "replayed missed websocket messages", "recipient", recipient, "id", id.Hex(), "count", replayed)
+}
+
+// resync tells the client to refetch its state instead of waiting for the missed messages
+func resync(ctx context.Context, recipient string, id primitive.ObjectID, reason string, send func([]byte) bool) {
+	slog.InfoContext(ctx, "missed websocket messages can not be replayed", "recipient", recipient, "id", id.Hex(), "reason", reason)
+	msg, err := newEnvelope(ResyncMessage, "", ResyncPayload{Reason: reason})
+	if err != nil {
+		slog.ErrorContext(ctx, "error creating websocket message", "type", ResyncMessage, "err", err)
+		return
+	}
+	send(msg)
+}
+
+// redisResumeStreams streams in redis, trimmed to resumeMaxLen and removed resumeWindow after the last message
+type redisResumeStreams struct{}
+
+func (redisResumeStreams) Append(ctx context.Context, entries []streamEntry) ([]string, error) {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(redisConn)
+
+	now := time.Now()
+	pipe := redisConn.Pipeline()
+	cmds := make([]*redis.StringCmd, 0, len(entries))
+	for _, entry := range entries {
+		cmds = append(cmds, pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: entry.key,
+			MaxLen: resumeMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{"msg": entry.msg, "expires_at": strconv.FormatInt(now.Add(entry.ttl).UnixMilli(), 10)},
+		}))
+		pipe.Expire(ctx, entry.key, resumeWindow)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	seqs := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		seqs = append(seqs, cmd.Val())
+	}
+	return seqs, nil
+}
+
+func (redisResumeStreams) Range(ctx context.Context, key string, start string, count int64) ([]redis.XMessage, error) {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close(redisConn)
+
+	return redisConn.XRangeN(ctx, key, start, "+", count).Result()
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStreams resume streams in memory, entry ids are increasing numbers shared by all streams
+type memoryStreams struct {
+	streams   map[string][]redis.XMessage
+	last      int
+	appendErr error // returned by Append when set
+}
+
+func newMemoryStreams() *memoryStreams {
+	return &memoryStreams{streams: map[string][]redis.XMessage{}}
+}
+
+func (m *memoryStreams) Append(_ context.Context, entries []streamEntry) ([]string, error) {
+	if m.appendErr != nil {
+		return nil, m.appendErr
+	}
+	var ids []string
+	for _, entry := range entries {
+		m.last++
+		id := strconv.Itoa(m.last) + "-0"
+		m.streams[entry.key] = append(m.streams[entry.key], redis.XMessage{ID: id, Values: map[string]interface{}{
+			"msg":        string(entry.msg),
+			"expires_at": strconv.FormatInt(time.Now().Add(entry.ttl).UnixMilli(), 10),
+		}})
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (m *memoryStreams) Range(_ context.Context, key string, start string, count int64) ([]redis.XMessage, error) {
+	from, _ := strconv.Atoi(strings.TrimSuffix(start, "-0"))
+	var entries []redis.XMessage
+	for _, entry := range m.streams[key] {
+		id, _ := strconv.Atoi(strings.TrimSuffix(entry.ID, "-0"))
+		if id >= from && int64(len(entries)) < count {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// sentMessages messages a broadcast or send function was called with
+type sentMessages []Envelope
+
+func (s *sentMessages) broadcast(message string, _ []primitive.ObjectID) {
+	var envelope Envelope
+	_ = json.Unmarshal([]byte(message), &envelope)
+	*s = append(*s, envelope)
+}
+
+func (s *sentMessages) send(message []byte) bool {
+	s.broadcast(string(message), nil)
+	return true
+}
+
+func (s *sentMessages) seqs() []string {
+	var seqs []string
+	for _, envelope := range *s {
+		seqs = append(seqs, envelope.Seq)
+	}
+	return seqs
+}
+
+func queuedMessage(t *testing.T, sent *sentMessages, msgType string, ids ...primitive.ObjectID) delivery {
+	msg, err := newEnvelope(msgType, "", AckPayload{})
+	assert.NoError(t, err)
+	return delivery{broadcast: sent.broadcast, recipient: riderRecipient, ids: ids, msg: msg, ttl: resumeWindow}
+}
+
+func TestSendSequenced(t *testing.T) {
+	a, b := primitive.NewObjectID(), primitive.NewObjectID()
+
+	t.Run("every recipient gets its own sequence in order", func(t *testing.T) {
+		store := newMemoryStreams()
+		var sent sentMessages
+		sendSequenced(context.TODO(), store, []delivery{
+			queuedMessage(t, &sent, NewOrderMessage, a, b),
+			queuedMessage(t, &sent, OrderTakenMessage, a),
+		})
+		assert.Equal(t, []string{"1-0", "2-0", "3-0"}, sent.seqs())
+		assert.Equal(t, OrderTakenMessage, sent[2].Type)
+		assert.Len(t, store.streams[resumeKey(riderRecipient, a)], 2)
+		assert.Len(t, store.streams[resumeKey(riderRecipient, b)], 1)
+	})
+
+	t.Run("sent without a sequence when redis fails", func(t *testing.T) {
+		store := newMemoryStreams()
+		store.appendErr = errors.New("connection refused")
+		var sent sentMessages
+		sendSequenced(context.TODO(), store, []delivery{queuedMessage(t, &sent, NewOrderMessage, a, b)})
+		assert.Equal(t, []string{""}, sent.seqs())
+	})
+}
+
+func TestReplay(t *testing.T) {
+	riderId := primitive.NewObjectID()
+	missed := func(store *memoryStreams, count int) {
+		var ignored sentMessages
+		for i := 0; i < count; i++ {
+			sendSequenced(context.TODO(), store, []delivery{queuedMessage(t, &ignored, TripUpdatedMessage, riderId)})
+		}
+	}
+
+	t.Run("messages after the last seen one in order", func(t *testing.T) {
+		store := newMemoryStreams()
+		missed(store, 4)
+		var sent sentMessages
+		replay(context.TODO(), store, riderRecipient, riderId, "2-0", sent.send)
+		assert.Equal(t, []string{"3-0", "4-0"}, sent.seqs())
+	})
+
+	t.Run("nothing without a last seen message", func(t *testing.T) {
+		store := newMemoryStreams()
+		missed(store, 2)
+		var sent sentMessages
+		replay(context.TODO(), store, riderRecipient, riderId, "", sent.send)
+		assert.Empty(t, sent)
+	})
+
+	t.Run("expired messages are skipped", func(t *testing.T) {
+		store := newMemoryStreams()
+		missed(store, 3)
+		key := resumeKey(riderRecipient, riderId)
+		store.streams[key][1].Values["expires_at"] = strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+		var sent sentMessages
+		replay(context.TODO(), store, riderRecipient, riderId, "1-0", sent.send)
+		assert.Equal(t, []string{"3-0"}, sent.seqs())
+	})
+
+	t.Run("all missed messages up to the batch", func(t *testing.T) {
+		store := newMemoryStreams()
+		missed(store, resumeBatch+1)
+		var sent sentMessages
+		replay(context.TODO(), store, riderRecipient, riderId, "1-0", sent.send)
+		assert.Len(t, sent, resumeBatch)
+		assert.Equal(t, TripUpdatedMessage, sent[resumeBatch-1].Type)
+	})
+
+	t.Run("resync when more were missed than the batch", func(t *testing.T) {
+		store := newMemoryStreams()
+		missed(store, resumeBatch+2)
+		var sent sentMessages
+		replay(context.TODO(), store, riderRecipient, riderId, "1-0", sent.send)
+		assert.Len(t, sent, 1)
+		assert.Equal(t, ResyncMessage, sent[0].Type)
+		assert.JSONEq(t, `{"reason":"truncated"}`, string(sent[0].Payload))
+	})
+
+	t.Run("resync when the last seen message was trimmed", func(t *testing.T) {
+		store := newMemoryStreams()
+		missed(store, 3)
+		key := resumeKey(riderRecipient, riderId)
+		store.streams[key] = store.streams[key][1:]
+		var sent sentMessages
+		replay(context.TODO(), store, riderRecipient, riderId, "1-0", sent.send)
+		assert.Len(t, sent, 1)
+		assert.Equal(t, ResyncMessage, sent[0].Type)
+		assert.JSONEq(t, `{"reason":"expired"}`, string(sent[0].Payload))
+	})
+}
+
+func TestResumableSockets(t *testing.T) {
+	riderId := primitive.NewObjectID()
+
+	t.Run("messages are saved for resume by the worker", func(t *testing.T) {
+		store := newMemoryStreams()
+		sockets := newResumableSockets(newRecordingSockets(), store)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go sockets.Run(ctx)
+
+		sendToRiders(sockets, TripUpdatedMessage, TripInfo{}, riderId)
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual([]string{TripUpdatedMessage}, sockets.WebSocketManager.(*recordingSockets).sent(riderId))
+		}, time.Second, 10*time.Millisecond)
+		assert.Len(t, store.streams[resumeKey(riderRecipient, riderId)], 1)
+	})
+
+	t.Run("messages not kept for resume are sent right away", func(t *testing.T) {
+		store := newMemoryStreams()
+		sockets := newResumableSockets(newRecordingSockets(), store)
+		sendToRiders(sockets, LocationMessage, LocationSyncReq{}, riderId)
+		assert.Equal(t, []string{LocationMessage}, sockets.WebSocketManager.(*recordingSockets).sent(riderId))
+		assert.Empty(t, store.streams)
+	})
+
+	t.Run("other socket managers send right away", func(t *testing.T) {
+		sm := newRecordingSockets()
+		sendToRiders(sm, TripUpdatedMessage, TripInfo{}, riderId)
+		assert.Equal(t, []string{TripUpdatedMessage}, sm.sent(riderId))
+	})
+}
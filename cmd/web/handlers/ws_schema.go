@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// clientMessages payload of every message type a rider or user can send
+var clientMessages = map[string]interface{}{
+	"accept_order":          AcceptOrderId{},
+	"send_location":         LocationSyncReq{},
+	"delivered":             DeliveredReq{},
+	"update_stop":           StopStatusReq{},
+	"arrived_at_restaurant": PickupReq{},
+	"picked_up":             PickupReq{},
+	"subscribe_order":       SubscribeOrderReq{},
+	"unsubscribe_order":     UnsubscribeOrderReq{},
+}
+
+// serverMessages payload of every message type sent by the server
+var serverMessages = map[string]interface{}{
+	AckMessage:         AckPayload{},
+	ErrorMessage:       ErrorPayload{},
+	NewOrderMessage:    NewOrderBroadCast{},
+	OrderTakenMessage:  NewOrderBroadCast{},
+	TripUpdatedMessage: TripInfo{},
+	OrderStatusMessage: OrderStatusInfo{},
+	StopUpdatedMessage: StopStatusInfo{},
+	LocationMessage:    NewLocationInfo{},
+	DeliveryOtpMessage: DeliveryOtpInfo{},
+	ResyncMessage:      ResyncPayload{},
+
+	OrderPlacedMessage:    RestaurantOrderInfo{},
+	OrderCancelledMessage: RestaurantOrderInfo{},
+	RiderAssignedMessage:  RestaurantOrderInfo{},
+	RiderArrivedMessage:   RestaurantOrderInfo{},
+}
+
+// MessageSchemas JSON Schemas of the websocket envelope and the payload of every message type
+type MessageSchemas struct {
+	Version  int                    `json:"version"`
+	Envelope map[string]interface{} `json:"envelope"`
+	Client   map[string]interface{} `json:"client"`
+	Server   map[string]interface{} `json:"server"`
+}
+
+// GetMessageSchemas schemas are generated from the go types, so they can not drift from what is sent
+func GetMessageSchemas() MessageSchemas {
+	schemas := MessageSchemas{
+		Version:  MessageVersion,
+		Envelope: jsonSchema(reflect.TypeOf(Envelope{})),
+		Client:   map[string]interface{}{},
+		Server:   map[string]interface{}{},
+	}
+	schemas.Envelope["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	for msgType, payload := range clientMessages {
+		schemas.Client[msgType] = jsonSchema(reflect.TypeOf(payload))
+	}
+	for msgType, payload := range serverMessages {
+		schemas.Server[msgType] = jsonSchema(reflect.TypeOf(payload))
+	}
+	return schemas
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	objectIdType   = reflect.TypeOf(primitive.ObjectID{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// jsonSchema schema of a type as encoding/json marshals it
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case objectIdType:
+		return map[string]interface{}{"type": "string", "pattern": "^[0-9a-f]{24}$"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = jsonSchema(field.Type)
+		if strings.Contains(field.Tag.Get("validate"), "required") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -43,11 +43,17 @@ func (ch ContextHandler) addRequestId(ctx context.Context) []slog.Attr {
 	method := getDefaultValueFromContext(ctx, "request_method")
 	path := getDefaultValueFromContext(ctx, "request_path")
 	agent := getDefaultValueFromContext(ctx, "request_user_agent")
+	principalId := getDefaultValueFromContext(ctx, "principal_id")
+	principalType := getDefaultValueFromContext(ctx, "principal_type")
+	principalRole := getDefaultValueFromContext(ctx, "principal_role")
 
 	group := slog.Group("meta_information", slog.String("correlation_id", correlation),
 		slog.String("request_method", method),
 		slog.String("request_path", path),
-		slog.String("request_user_agent", agent))
+		slog.String("request_user_agent", agent),
+		slog.String("principal_id", principalId),
+		slog.String("principal_type", principalType),
+		slog.String("principal_role", principalRole))
 	as = append(as, group)
 	return as
 }
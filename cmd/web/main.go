@@ -2,38 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
 	"food-eats/cmd/web/db"
+	"food-eats/cmd/web/handlers"
 	"food-eats/cmd/web/logger"
 	middleware2 "food-eats/cmd/web/middelwares"
 	"food-eats/cmd/web/model"
+	"food-eats/cmd/web/notify"
 	"food-eats/cmd/web/routes"
-	"github.com/go-playground/validator/v10"
+	"food-eats/cmd/web/validation"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"go.mongodb.org/mongo-driver/mongo"
 	"log"
 	"log/slog"
-	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
-// CustomValidator to have more control on our validator
-type CustomValidator struct {
-	validator *validator.Validate
-}
-
-func (cv *CustomValidator) Validate(i interface{}) error {
-	if err := cv.validator.Struct(i); err != nil {
-		// Optionally, you could return the error to give each route more control over the status code
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
-	}
-	return nil
-}
-
 func main() {
 	e := echo.New()
-	e.Validator = &CustomValidator{validator: validator.New()}
+	e.Validator = validation.New()
+	e.HTTPErrorHandler = custom_errors.HTTPErrorHandler
 
 	// setting Long date, Long time, Long Microseconds, and Long file path for log
 	opts := slog.HandlerOptions{
@@ -49,95 +44,234 @@ func main() {
 	uri := flag.String("mongo-uri", "mongodb://127.0.0.1:27017", "Mongodb uri")
 	mongodb := flag.String("mongo db", "food-eats", "Mongodb database")
 	redisUri := flag.String("redis-uri", "127.0.0.1:6380", "Redis uri")
+	geofenceRadius := flag.Float64("geofence-radius", 100, "Radius in meters to detect rider arrival, 0 to disable")
+	geofenceDwell := flag.Duration("geofence-dwell", 30*time.Second, "Time a rider has to stay inside the geofence")
+	distributedWs := flag.Bool("distributed-ws", false, "Fan out websocket messages through redis when running multiple instances")
+	hostname, _ := os.Hostname()
+	nodeId := flag.String("node-id", hostname, "Unique id of this instance, used in the websocket registry")
+	jwtSecret := flag.String("jwt-secret", "", "Secret signing the access tokens, same on every instance")
+	wsAllowedOrigins := flag.String("ws-allowed-origins", "", "Comma separated origins allowed to open websockets from a browser, * for any")
+	adminPhone := flag.String("admin-phone", "", "Phone number of the first admin, created on start if missing")
+	rateLimits := flag.String("rate-limits", "/v1/restaurant/search_restaurant=60/1m,/v1/auth=20/1m,/v1=600/1m", "Comma separated requests per window a caller can make to the routes under a path, the longest path applies")
+	smsSender := flag.String("sms-sender", notify.LogSender, "Sender of text messages, log logs them with otps redacted, dev-log logs them in full for local runs")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma separated CIDRs of the load balancers in front, X-Forwarded-For is only read from them")
+	idempotencyTTL := flag.Duration("idempotency-ttl", 24*time.Hour, "Time the response of a request with an Idempotency-Key is replayed to retries")
 	flag.Parse()
 
+	if *jwtSecret == "" {
+		// fine for local runs, tokens stop working on restart and on other instances
+		slog.Warn("no jwt secret configured, using a random one")
+		*jwtSecret = randomSecret()
+	}
+	tokens := auth.NewTokenManager(*jwtSecret)
+
+	limits, err := middleware2.ParseRateLimits(*rateLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ipExtractor, err := middleware2.IPExtractor(*trustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mongoDatabase, err := db.GetMongoClient(context.TODO(), *uri, *mongodb)
 	if err != nil {
 		panic("unable to connect to mongo db")
 	}
 
+	if err := model.CreateLocationHistoryIndexes(context.TODO(), mongoDatabase); err != nil {
+		panic("unable to create location history indexes")
+	}
+
+	if err := model.CreateApiKeyIndexes(context.TODO(), mongoDatabase); err != nil {
+		panic("unable to create api key indexes")
+	}
+	if err := model.CreateAuditIndexes(context.TODO(), mongoDatabase); err != nil {
+		panic("unable to create audit log indexes")
+	}
+	tokens.EnableApiKeys(auth.NewApiKeyVerifier(handlers.ApiKeyStore{Repository: model.ApiKeyMongoRepo(mongoDatabase)}))
+
+	if *adminPhone != "" {
+		if err := handlers.BootstrapAdmin(context.TODO(), model.AdminMongoRepo(mongoDatabase), *adminPhone); err != nil {
+			panic("unable to create admin")
+		}
+	}
+
 	// init redis
 	db.InitRedisPool(*redisUri, 100, 200)
 
-	sm := model.NewWebSocketManager(mongoDatabase)
+	var sm model.WebSocketManager = model.NewWebSocketManager(mongoDatabase)
+	if *distributedWs {
+		sm = model.NewDistributedSocketManager(model.NewWebSocketManager(mongoDatabase), model.NewRedisSocketBus(db.GetRedisClient()), *nodeId)
+	}
+	// messages sent to the sockets are kept in redis for clients reconnecting shortly after
+	sockets := handlers.NewResumableSockets(sm)
+	go sockets.Run(context.Background())
+
+	// the rate limits and idempotency keys of anonymous callers go by ip, which clients must not be able to spoof
+	e.IPExtractor = ipExtractor
 
 	// adding middlewares
 	e.Pre(middleware2.RequestIDMiddleware)
 	e.Pre(middleware2.AddMetaData)
 	e.Use(middleware.Recover())
+	e.Use(middleware2.RateLimiter(middleware2.RedisRateLimitStore{}, tokens, limits))
 
 	// using GZIP to compress the result
 	e.Use(middleware.Gzip())
 
-	initRoutes(mongoDatabase, sm, e)
+	geofence := handlers.GeofenceConfig{Radius: *geofenceRadius, Dwell: *geofenceDwell}
+	// todo plug in the sms provider, messages are only logged for now
+	sms, err := notify.NewSMSSender(*smsSender)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	idempotent := middleware2.Idempotency(middleware2.RedisIdempotencyStore{}, *idempotencyTTL)
+
+	initRoutes(mongoDatabase, sockets, geofence, tokens, sms, idempotent, strings.Split(*wsAllowedOrigins, ","), e)
 
 	log.Println("Server starting....")
 	log.Panic(e.Start(":8080"))
 }
 
-func initRoutes(mongoDatabase *mongo.Database, sm *model.SocketManager, e *echo.Echo) {
-	initUserEndPoints(mongoDatabase, sm, e)
-	initRestaurantEndPoints(mongoDatabase, sm, e)
-	initRiderEndPoints(mongoDatabase, sm, e)
-	initOrderEndPoints(mongoDatabase, sm, e)
-	initRatingEndPoints(mongoDatabase, e)
-	initWebSocketConnect(mongoDatabase, sm, e)
+// randomSecret used when no jwt secret is configured
+func randomSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func initRoutes(mongoDatabase *mongo.Database, sm model.WebSocketManager, geofence handlers.GeofenceConfig, tokens *auth.TokenManager, sms notify.SMSSender, idempotent echo.MiddlewareFunc, allowedOrigins []string, e *echo.Echo) {
+	initAuthEndPoints(mongoDatabase, tokens, sms, e)
+	initUserEndPoints(mongoDatabase, sm, tokens, idempotent, e)
+	initRestaurantEndPoints(mongoDatabase, sm, tokens, sms, idempotent, e)
+	initRiderEndPoints(mongoDatabase, sm, tokens, idempotent, e)
+	initOrderEndPoints(mongoDatabase, sm, tokens, idempotent, e)
+	initRatingEndPoints(mongoDatabase, tokens, idempotent, e)
+	initAdminEndPoints(mongoDatabase, sm, tokens, idempotent, e)
+	initWebSocketConnect(mongoDatabase, sm, geofence, tokens, allowedOrigins, e)
 }
 
-func initUserEndPoints(mongodb *mongo.Database, sm *model.SocketManager, e *echo.Echo) {
+func initAuthEndPoints(mongodb *mongo.Database, tokens *auth.TokenManager, sms notify.SMSSender, e *echo.Echo) {
+	authGroup := e.Group("/v1/auth")
+	authApplication := routes.AuthApplication{MongoDb: mongodb, Tokens: tokens, SMS: sms}
+	phoneAuth := middleware2.Authorize(tokens, auth.PermAccountPhone)
+	authGroup.POST("/otp/send", authApplication.SendLoginOtp)
+	authGroup.POST("/login", authApplication.Login)
+	authGroup.POST("/refresh", authApplication.RefreshToken)
+	authGroup.POST("/phone/otp/send", authApplication.SendPhoneOtp, phoneAuth)
+	authGroup.POST("/phone/verify", authApplication.VerifyPhone, phoneAuth)
+}
+
+func initUserEndPoints(mongodb *mongo.Database, sm model.WebSocketManager, tokens *auth.TokenManager, idempotent echo.MiddlewareFunc, e *echo.Echo) {
 	userGroup := e.Group("/v1/user")
 	userApplication := routes.UserApplication{MongoDb: mongodb}
-	userGroup.POST("/create", userApplication.CreateUser)
-	userGroup.PUT("/edit", userApplication.UpdateUser)
-	userGroup.GET("/get", userApplication.GetUser)
-	userGroup.DELETE("/delete", userApplication.DeleteUser)
+	userAuth := middleware2.Authorize(tokens, auth.PermUserProfile)
+	userGroup.POST("/create", userApplication.CreateUser, idempotent)
+	userGroup.PUT("/edit", userApplication.UpdateUser, userAuth)
+	userGroup.GET("/get", userApplication.GetUser, userAuth)
+	userGroup.DELETE("/delete", userApplication.DeleteUser, userAuth)
 }
 
-func initRestaurantEndPoints(mongodb *mongo.Database, sm *model.SocketManager, e *echo.Echo) {
+func initRestaurantEndPoints(mongodb *mongo.Database, sm model.WebSocketManager, tokens *auth.TokenManager, sms notify.SMSSender, idempotent echo.MiddlewareFunc, e *echo.Echo) {
 	restaurantGroup := e.Group("/v1/restaurant")
 	restaurantApplication := routes.RestaurantApplication{
 		MongoDb: mongodb,
 		Cache:   db.GetRestaurantCache(),
+		SMS:     sms,
 	}
-	restaurantGroup.POST("/create", restaurantApplication.CreateRestaurant)
-	restaurantGroup.PUT("/edit", restaurantApplication.UpdateRestaurant)
+	restaurantAuth := middleware2.Authorize(tokens, auth.PermRestaurantProfile)
+	payoutAuth := middleware2.Authorize(tokens, auth.PermRestaurantPayout)
+	staffAuth := middleware2.Authorize(tokens, auth.PermRestaurantStaff)
+	restaurantGroup.POST("/create", restaurantApplication.CreateRestaurant, idempotent)
+	restaurantGroup.PUT("/edit", restaurantApplication.UpdateRestaurant, restaurantAuth)
 	restaurantGroup.GET("/get", restaurantApplication.GetRestaurant)
-	restaurantGroup.DELETE("/delete", restaurantApplication.DeleteRestaurant)
+	restaurantGroup.DELETE("/delete", restaurantApplication.DeleteRestaurant, restaurantAuth)
+	restaurantGroup.PUT("/menu/edit", restaurantApplication.UpdateMenu, middleware2.Authorize(tokens, auth.PermRestaurantMenu))
+	restaurantGroup.PUT("/payout/edit", restaurantApplication.UpdatePayout, payoutAuth)
+	restaurantGroup.GET("/payout/get", restaurantApplication.GetPayout, payoutAuth)
+	restaurantGroup.POST("/staff/invite", restaurantApplication.InviteStaff, staffAuth, idempotent)
+	restaurantGroup.GET("/staff/get", restaurantApplication.GetStaff, staffAuth)
+	restaurantGroup.DELETE("/staff/remove", restaurantApplication.RemoveStaff, staffAuth)
+
+	apiKeyApplication := routes.ApiKeyApplication{MongoDb: mongodb}
+	apiKeyAuth := middleware2.Authorize(tokens, auth.PermRestaurantApiKeys)
+	// not idempotent, the response carries the secret which must not be kept to replay
+	restaurantGroup.POST("/api_key/create", apiKeyApplication.CreateApiKey, apiKeyAuth)
+	restaurantGroup.GET("/api_key/get", apiKeyApplication.GetApiKeys, apiKeyAuth)
+	restaurantGroup.POST("/api_key/rotate", apiKeyApplication.RotateApiKey, apiKeyAuth)
+	restaurantGroup.POST("/api_key/revoke", apiKeyApplication.RevokeApiKey, apiKeyAuth, idempotent)
+	restaurantGroup.GET("/api_key/usage", apiKeyApplication.GetApiKeyUsage, apiKeyAuth)
 
 	restaurantGroup.POST("/search_restaurant", restaurantApplication.SearchRestaurant)
 }
 
-func initOrderEndPoints(mongodb *mongo.Database, sm *model.SocketManager, e *echo.Echo) {
+func initOrderEndPoints(mongodb *mongo.Database, sm model.WebSocketManager, tokens *auth.TokenManager, idempotent echo.MiddlewareFunc, e *echo.Echo) {
 	userGroup := e.Group("/v1/order")
 	orderApplication := routes.OrderApplication{
 		MongoDb: mongodb,
 		SM:      sm,
+		Tokens:  tokens,
 	}
-	userGroup.POST("/create", orderApplication.CreateOrder)
-	userGroup.GET("/restaurant/get_pending_orders", orderApplication.GetRestaurantPendingOrder)
-	userGroup.POST("/restaurant/accept_order", orderApplication.AcceptOrder)
-	userGroup.POST("/search/get_orders", orderApplication.SearchOrder)
+	restaurantAuth := middleware2.Authorize(tokens, auth.PermRestaurantOrders)
+	trackAuth := middleware2.Authorize(tokens, auth.PermOrderTrack)
+	createAuth := middleware2.Authorize(tokens, auth.PermOrderCreate)
+	userGroup.POST("/create", orderApplication.CreateOrder, createAuth, idempotent)
+	userGroup.POST("/cancel", orderApplication.CancelOrder, createAuth, idempotent)
+	userGroup.GET("/restaurant/get_pending_orders", orderApplication.GetRestaurantPendingOrder, restaurantAuth)
+	userGroup.POST("/restaurant/accept_order", orderApplication.AcceptOrder, restaurantAuth, idempotent)
+	userGroup.POST("/restaurant/food_ready", orderApplication.MarkFoodReady, restaurantAuth, idempotent)
+	userGroup.POST("/search/get_orders", orderApplication.SearchOrder, middleware2.Authorize(tokens, auth.PermOrderSearch))
+	userGroup.GET("/delivery_otp", orderApplication.GetDeliveryOtp, trackAuth)
+	userGroup.POST("/share", orderApplication.ShareOrder, trackAuth, idempotent)
+	userGroup.GET("/track/stream", orderApplication.TrackOrderStream)
+	userGroup.GET("/route", orderApplication.GetOrderRoute, middleware2.Authorize(tokens, auth.PermOrderRoute))
+	userGroup.GET("/route/replay", orderApplication.ReplayOrder, middleware2.Authorize(tokens, auth.PermOrderReplay))
 }
 
-func initRiderEndPoints(mongodb *mongo.Database, sm *model.SocketManager, e *echo.Echo) {
+func initRiderEndPoints(mongodb *mongo.Database, sm model.WebSocketManager, tokens *auth.TokenManager, idempotent echo.MiddlewareFunc, e *echo.Echo) {
 	riderGroup := e.Group("/v1/rider")
 	userApplication := routes.RiderApplication{MongoDb: mongodb}
-	riderGroup.POST("/create", userApplication.CreateRider)
-	riderGroup.PUT("/edit", userApplication.UpdateRider)
-	riderGroup.GET("/get", userApplication.GetRider)
-	riderGroup.DELETE("/delete", userApplication.DeleteRider)
+	riderAuth := middleware2.Authorize(tokens, auth.PermRiderProfile)
+	riderGroup.POST("/create", userApplication.CreateRider, idempotent)
+	riderGroup.PUT("/edit", userApplication.UpdateRider, riderAuth)
+	riderGroup.GET("/get", userApplication.GetRider, riderAuth)
+	riderGroup.DELETE("/delete", userApplication.DeleteRider, riderAuth)
 }
 
-func initWebSocketConnect(mongodb *mongo.Database, sm *model.SocketManager, e *echo.Echo) {
+func initWebSocketConnect(mongodb *mongo.Database, sm model.WebSocketManager, geofence handlers.GeofenceConfig, tokens *auth.TokenManager, allowedOrigins []string, e *echo.Echo) {
 	wsGroup := e.Group("/v1/websocket")
-	wsApplication := routes.NewWsApplication(mongodb, sm)
+	wsApplication := routes.NewWsApplication(mongodb, sm, geofence, tokens, allowedOrigins)
 	wsGroup.GET("/rider", wsApplication.ConnectRiderWebSocket)
 	wsGroup.GET("/user", wsApplication.ConnectUserWebSocket)
+	wsGroup.GET("/restaurant", wsApplication.ConnectRestaurantWebSocket)
+	wsGroup.GET("/metrics", wsApplication.GetWebSocketStats, middleware2.Authorize(tokens, auth.PermAdminView))
+	wsGroup.GET("/schema", wsApplication.GetMessageSchemas)
 }
 
-func initRatingEndPoints(mongodb *mongo.Database, e *echo.Echo) {
+func initRatingEndPoints(mongodb *mongo.Database, tokens *auth.TokenManager, idempotent echo.MiddlewareFunc, e *echo.Echo) {
 	ratingGroup := e.Group("/v1/rating")
 	userApplication := routes.RatingApplication{MongoDb: mongodb}
-	ratingGroup.POST("/create", userApplication.CreateNewRating)
+	ratingGroup.POST("/create", userApplication.CreateNewRating, middleware2.Authorize(tokens, auth.PermRatingCreate), idempotent)
 	ratingGroup.GET("/get", userApplication.GetRating)
 }
+
+func initAdminEndPoints(mongodb *mongo.Database, sm model.WebSocketManager, tokens *auth.TokenManager, idempotent echo.MiddlewareFunc, e *echo.Echo) {
+	adminGroup := e.Group("/v1/admin")
+	adminApplication := routes.AdminApplication{
+		MongoDb: mongodb,
+		SM:      sm,
+		Cache:   db.GetRestaurantCache(),
+	}
+	suspendAuth := middleware2.Authorize(tokens, auth.PermAdminSuspend)
+	adminGroup.GET("/get", adminApplication.GetEntity, middleware2.Authorize(tokens, auth.PermAdminView))
+	adminGroup.GET("/audit/get", adminApplication.GetAuditLogs, middleware2.Authorize(tokens, auth.PermAdminView))
+	adminGroup.POST("/account/suspend", adminApplication.SuspendAccount, suspendAuth, idempotent)
+	adminGroup.POST("/account/reinstate", adminApplication.ReinstateAccount, suspendAuth, idempotent)
+	adminGroup.POST("/order/cancel", adminApplication.CancelOrder, middleware2.Authorize(tokens, auth.PermAdminCancelOrder), idempotent)
+	adminGroup.POST("/order/reassign_rider", adminApplication.ReassignRider, middleware2.Authorize(tokens, auth.PermAdminReassignRider), idempotent)
+	adminGroup.POST("/agent/create", adminApplication.CreateAgent, middleware2.Authorize(tokens, auth.PermAdminManageAgents), idempotent)
+}
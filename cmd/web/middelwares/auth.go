@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/model"
+	"github.com/labstack/echo/v4"
+	"log/slog"
+)
+
+// Authorize requires an access token whose role has the permission, or a request signed with an api key having
+// the permission as scope. The principal is put into the request context, requests of api keys are logged per key.
+func Authorize(tokens *auth.TokenManager, permission string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, err := tokens.Authenticate(c.Request(), auth.PrincipalTypes...)
+			if err != nil {
+				return custom_errors.Respond(c.Request().Context(), c, custom_errors.Unauthorized, err.Error())
+			}
+			if principal.Type == auth.ApiKeyPrincipal {
+				defer recordUsage(c, tokens.ApiKeys(), principal)
+			}
+			if !principal.Can(permission) {
+				return custom_errors.Respond(c.Request().Context(), c, custom_errors.ForbiddenError, auth.ErrForbidden.Error())
+			}
+
+			ctx := WithPrincipal(c.Request().Context(), principal)
+			request := c.Request().Clone(ctx)
+			c.SetRequest(request)
+			err = next(c)
+			if err != nil && principal.Type == auth.ApiKeyPrincipal {
+				// the error handler runs after the middlewares, calling it here so the logged status is the one sent
+				c.Error(err)
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// WithPrincipal context of an authenticated request, changes made under it are audited as made by the principal
+func WithPrincipal(ctx context.Context, principal auth.Principal) context.Context {
+	ctx = auth.WithPrincipal(ctx, principal)
+	return model.WithAuditActor(ctx, model.AuditActor{Id: principal.Id, Type: principal.Type, Role: principal.Role})
+}
+
+// recordUsage adds the request to the usage log of the api key, failing to log does not fail the request
+func recordUsage(c echo.Context, apiKeys *auth.ApiKeyVerifier, principal auth.Principal) {
+	if err := apiKeys.RecordUsage(c.Request(), principal, c.Response().Status, c.RealIP()); err != nil {
+		slog.ErrorContext(c.Request().Context(), "error recording api key usage", "api_key", principal.Id.Hex(), "error", err.Error())
+	}
+}
@@ -0,0 +1,51 @@
+package middleware_test
+
+import (
+	"food-eats/cmd/web/auth"
+	middleware "food-eats/cmd/web/middelwares"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAuthorize(t *testing.T) {
+	tm := auth.NewTokenManager("secret")
+	principal := auth.Principal{Id: primitive.NewObjectID(), Type: auth.UserPrincipal, Role: auth.RoleCustomer}
+	token, err := tm.IssueToken(principal, auth.AccessToken, time.Minute)
+	assert.NoError(t, err)
+
+	var got auth.Principal
+	handler := middleware.Authorize(tm, auth.PermUserProfile)(func(c echo.Context) error {
+		got, _ = auth.PrincipalFromContext(c.Request().Context())
+		assert.Equal(t, principal.Id.Hex(), c.Request().Context().Value("principal_id"))
+		return c.NoContent(http.StatusOK)
+	})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/v1/user/get", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, handler(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, principal, got)
+
+	// customers are not allowed on rider or admin routes
+	for _, permission := range []string{auth.PermRiderProfile, auth.PermAdminView} {
+		forbidden := middleware.Authorize(tm, permission)(func(c echo.Context) error {
+			t.Error("handler called without the permission")
+			return nil
+		})
+		rec = httptest.NewRecorder()
+		assert.NoError(t, forbidden(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	assert.NoError(t, handler(e.NewContext(httptest.NewRequest(http.MethodGet, "/v1/user/get", nil), rec)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
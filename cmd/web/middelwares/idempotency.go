@@ -0,0 +1,260 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/db"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// idempotencyRefreshInterval how often the key of a running request is reserved again, well within the ttl
+var idempotencyRefreshInterval = idempotencyInProgressTTL / 3
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyInProgressTTL  = time.Minute // a crashed request frees its key after this
+	idempotencyRedisKeyPrefix = "idempotency:"
+)
+
+// IdempotentResponse request hash and response stored under an idempotency key, Done is false while the first
+// request is still running
+type IdempotentResponse struct {
+	RequestHash string `json:"request_hash"`
+	Done        bool   `json:"done"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// IdempotencyStore storage of idempotency keys
+type IdempotencyStore interface {
+	// Reserve stores the response under the key unless the key is taken, then the stored response is returned
+	Reserve(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) (IdempotentResponse, bool, error)
+	// Refresh extends the reservation of a running request to ttl from now
+	Refresh(ctx context.Context, key string, ttl time.Duration) error
+	Save(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) error
+	Release(ctx context.Context, key string) error
+}
+
+// Idempotency makes POST requests carrying an Idempotency-Key header safe to retry. The first response is kept for
+// ttl and replayed to retries with the same body, the key can not be reused for a different request. Keys are per
+// principal, so the middleware goes after Authorize, anonymous callers are told apart by ip. Server errors are not
+// kept so the request can be retried.
+func Idempotency(store IdempotencyStore, ttl time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			idempotencyKey := c.Request().Header.Get(IdempotencyKeyHeader)
+			if c.Request().Method != http.MethodPost || idempotencyKey == "" {
+				return next(c)
+			}
+			if len(idempotencyKey) > maxIdempotencyKeyLength {
+				return custom_errors.Respond(c.Request().Context(), c, custom_errors.ClientError, "idempotency key too long")
+			}
+
+			requestHash, err := hashRequest(c.Request())
+			if err != nil {
+				return err
+			}
+
+			ctx := c.Request().Context()
+			key := idempotencyScope(c) + ":" + idempotencyKey
+			stored, reserved, err := store.Reserve(ctx, key, IdempotentResponse{RequestHash: requestHash}, idempotencyInProgressTTL)
+			if err != nil {
+				return err
+			}
+			if !reserved {
+				switch {
+				case stored.RequestHash != requestHash:
+					return custom_errors.Respond(ctx, c, custom_errors.UnprocessableError, "idempotency key already used for a different request")
+				case !stored.Done:
+					return custom_errors.Respond(ctx, c, custom_errors.ConflictError, "request with this idempotency key is in progress")
+				}
+				c.Response().Header().Set(IdempotentReplayedHeader, "true")
+				return c.Blob(stored.Status, stored.ContentType, stored.Body)
+			}
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+			stopRefresh := keepReserved(ctx, store, key)
+			err = next(c)
+			stopRefresh()
+			if err != nil {
+				// nothing is written yet, the error handler decides the response
+				releaseIdempotencyKey(ctx, store, key)
+				return err
+			}
+
+			status := c.Response().Status
+			if status >= http.StatusInternalServerError {
+				releaseIdempotencyKey(ctx, store, key)
+				return nil
+			}
+			response := IdempotentResponse{
+				RequestHash: requestHash,
+				Done:        true,
+				Status:      status,
+				ContentType: c.Response().Header().Get(echo.HeaderContentType),
+				Body:        recorder.body.Bytes(),
+			}
+			if err := store.Save(ctx, key, response, ttl); err != nil {
+				slog.ErrorContext(ctx, "error saving idempotent response", "error", err.Error())
+			}
+			return nil
+		}
+	}
+}
+
+// idempotencyScope principal the key belongs to, the ip for anonymous callers
+func idempotencyScope(c echo.Context) string {
+	if principal, ok := auth.PrincipalFromContext(c.Request().Context()); ok {
+		return principal.Type + ":" + principal.Id.Hex()
+	}
+	return "ip:" + c.RealIP()
+}
+
+// hashRequest hex SHA-256 of the method, the path with query and the body, the body is put back for the handler
+func hashRequest(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// keepReserved reserves the key again every idempotencyRefreshInterval until stopped, so a request running longer
+// than idempotencyInProgressTTL does not lose its key to a retry. Stopping waits for a refresh in flight.
+func keepReserved(ctx context.Context, store IdempotencyStore, key string) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(idempotencyRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := store.Refresh(ctx, key, idempotencyInProgressTTL); err != nil {
+					slog.ErrorContext(ctx, "error refreshing idempotency key", "error", err.Error())
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func releaseIdempotencyKey(ctx context.Context, store IdempotencyStore, key string) {
+	if err := store.Release(ctx, key); err != nil {
+		slog.ErrorContext(ctx, "error releasing idempotency key", "error", err.Error())
+	}
+}
+
+// responseRecorder keeps a copy of the response body
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// RedisIdempotencyStore idempotency keys in redis
+type RedisIdempotencyStore struct{}
+
+func (RedisIdempotencyStore) Reserve(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) (IdempotentResponse, bool, error) {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+	defer db.Close(redisConn)
+
+	value, err := json.Marshal(response)
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+	reserved, err := redisConn.SetNX(ctx, idempotencyRedisKeyPrefix+key, value, ttl).Result()
+	if err != nil || reserved {
+		return response, reserved, err
+	}
+
+	stored, err := redisConn.Get(ctx, idempotencyRedisKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// expired in between, the caller retries like for a running request
+		return response, false, nil
+	}
+	if err != nil {
+		return IdempotentResponse{}, false, err
+	}
+	var existing IdempotentResponse
+	if err := json.Unmarshal(stored, &existing); err != nil {
+		return IdempotentResponse{}, false, err
+	}
+	return existing, false, nil
+}
+
+func (RedisIdempotencyStore) Refresh(ctx context.Context, key string, ttl time.Duration) error {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	return redisConn.Expire(ctx, idempotencyRedisKeyPrefix+key, ttl).Err()
+}
+
+func (RedisIdempotencyStore) Save(ctx context.Context, key string, response IdempotentResponse, ttl time.Duration) error {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	value, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return redisConn.Set(ctx, idempotencyRedisKeyPrefix+key, value, ttl).Err()
+}
+
+func (RedisIdempotencyStore) Release(ctx context.Context, key string) error {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	return redisConn.Del(ctx, idempotencyRedisKeyPrefix+key).Err()
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// refreshCountingStore counts the refreshes of every key
+type refreshCountingStore struct {
+	lock      sync.Mutex
+	refreshes map[string]int
+	saved     map[string]IdempotentResponse
+}
+
+func (s *refreshCountingStore) Reserve(_ context.Context, _ string, response IdempotentResponse, _ time.Duration) (IdempotentResponse, bool, error) {
+	return response, true, nil
+}
+
+func (s *refreshCountingStore) Refresh(_ context.Context, key string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if ttl == idempotencyInProgressTTL {
+		s.refreshes[key]++
+	}
+	return nil
+}
+
+func (s *refreshCountingStore) Save(_ context.Context, key string, response IdempotentResponse, _ time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.saved[key] = response
+	return nil
+}
+
+func (s *refreshCountingStore) Release(_ context.Context, _ string) error {
+	return nil
+}
+
+func (s *refreshCountingStore) count(key string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.refreshes[key]
+}
+
+func TestIdempotency_RefreshesRunningRequest(t *testing.T) {
+	defer func(interval time.Duration) { idempotencyRefreshInterval = interval }(idempotencyRefreshInterval)
+	idempotencyRefreshInterval = 10 * time.Millisecond
+
+	store := &refreshCountingStore{refreshes: map[string]int{}, saved: map[string]IdempotentResponse{}}
+	handler := Idempotency(store, time.Hour)(func(c echo.Context) error {
+		time.Sleep(60 * time.Millisecond)
+		return c.JSON(http.StatusCreated, map[string]string{"status": "created"})
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/order/create", strings.NewReader(`{}`))
+	req.Header.Set(IdempotencyKeyHeader, "slow")
+	assert.NoError(t, handler(echo.New().NewContext(req, httptest.NewRecorder())))
+
+	key := "ip:192.0.2.1:slow"
+	refreshes := store.count(key)
+	assert.GreaterOrEqual(t, refreshes, 2)
+	assert.True(t, store.saved[key].Done)
+
+	// refreshing stops with the request
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, refreshes, store.count(key))
+}
@@ -0,0 +1,103 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	middleware "food-eats/cmd/web/middelwares"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeIdempotencyStore struct {
+	responses map[string]middleware.IdempotentResponse
+}
+
+func (s *fakeIdempotencyStore) Reserve(_ context.Context, key string, response middleware.IdempotentResponse, _ time.Duration) (middleware.IdempotentResponse, bool, error) {
+	if existing, ok := s.responses[key]; ok {
+		return existing, false, nil
+	}
+	s.responses[key] = response
+	return response, true, nil
+}
+
+func (s *fakeIdempotencyStore) Refresh(_ context.Context, _ string, _ time.Duration) error {
+	return nil
+}
+
+func (s *fakeIdempotencyStore) Save(_ context.Context, key string, response middleware.IdempotentResponse, _ time.Duration) error {
+	s.responses[key] = response
+	return nil
+}
+
+func (s *fakeIdempotencyStore) Release(_ context.Context, key string) error {
+	delete(s.responses, key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	store := &fakeIdempotencyStore{responses: map[string]middleware.IdempotentResponse{}}
+	calls := 0
+	status := http.StatusCreated
+	handler := middleware.Idempotency(store, time.Hour)(func(c echo.Context) error {
+		calls++
+		if status == 0 {
+			return errors.New("failed")
+		}
+		return c.JSON(status, map[string]int{"order": calls})
+	})
+
+	e := echo.New()
+	send := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/order/create", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		if err := handler(e.NewContext(req, rec)); err != nil {
+			e.HTTPErrorHandler(err, e.NewContext(req, rec))
+		}
+		return rec
+	}
+
+	first := send("k1", `{"restaurant_id":"1"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	// a retry gets the first response without running the handler
+	retry := send("k1", `{"restaurant_id":"1"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(middleware.IdempotentReplayedHeader))
+	assert.Equal(t, 1, calls)
+
+	rec := send("k1", `{"restaurant_id":"2"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, 1, calls)
+
+	// requests without a key are not deduplicated
+	send("", `{"restaurant_id":"1"}`)
+	send("", `{"restaurant_id":"1"}`)
+	assert.Equal(t, 3, calls)
+
+	// failures are not kept so the client can retry
+	status = http.StatusInternalServerError
+	assert.Equal(t, http.StatusInternalServerError, send("k2", `{}`).Code)
+	status = 0
+	assert.Equal(t, http.StatusInternalServerError, send("k2", `{}`).Code)
+	status = http.StatusCreated
+	assert.Equal(t, http.StatusCreated, send("k2", `{}`).Code)
+	assert.Equal(t, 6, calls)
+
+	// the first request is still running
+	running := store.responses["ip:192.0.2.1:k1"]
+	running.Done = false
+	store.responses["ip:192.0.2.1:k1"] = running
+	assert.Equal(t, http.StatusConflict, send("k1", `{"restaurant_id":"1"}`).Code)
+	assert.Equal(t, 6, calls)
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"food-eats/cmd/web/model"
 	"github.com/hashicorp/go-uuid"
 	"github.com/labstack/echo/v4"
 )
@@ -14,6 +15,7 @@ func RequestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 
 		// Set the request ID in the request context
 		ctx := context.WithValue(c.Request().Context(), "correlation_id", requestID)
+		ctx = model.WithCorrelationId(ctx, requestID)
 
 		request := c.Request().Clone(ctx)
 		c.SetRequest(request)
@@ -21,10 +23,11 @@ func RequestIDMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
-// AddMetaData adding meta-information about the route. Method, Path, UserId Agent
+// AddMetaData adding meta-information about the route. Method, Path, UserId Agent.
+// The query is not logged, websocket and event stream clients send their token as the access_token query.
 func AddMetaData(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		path := c.Request().RequestURI
+		path := c.Request().URL.Path
 		method := c.Request().Method
 		userAgent := c.Request().UserAgent()
 		ctx := context.WithValue(c.Request().Context(), "request_path", path)
@@ -0,0 +1,26 @@
+package middleware_test
+
+import (
+	middleware "food-eats/cmd/web/middelwares"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddMetaData_PathWithoutQuery(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/v1/order/track/stream?order_id=1&access_token=secret-token", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	var path interface{}
+	handler := middleware.AddMetaData(func(c echo.Context) error {
+		path = c.Request().Context().Value("request_path")
+		return nil
+	})
+	assert.NoError(t, handler(c))
+	assert.Equal(t, "/v1/order/track/stream", path)
+}
@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/db"
+	"github.com/hashicorp/go-uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headers of rate limited responses
+const (
+	RateLimitPolicyHeader    = "RateLimit-Policy"    // 30;w=60
+	RateLimitLimitHeader     = "RateLimit-Limit"     // requests allowed in the window
+	RateLimitRemainingHeader = "RateLimit-Remaining" // requests left in the window
+	RateLimitResetHeader     = "RateLimit-Reset"     // seconds until a request leaves the window
+)
+
+// RateLimit requests a caller can make to the routes under the prefix in a sliding window
+type RateLimit struct {
+	Prefix string // like /v1/order, callers are counted per prefix
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitResult state of the window of a caller after a request
+type RateLimitResult struct {
+	Allowed bool
+	Count   int           // requests in the window, the rejected one not included
+	Reset   time.Duration // until the oldest request leaves the window
+}
+
+// RateLimitStore counts the requests of callers
+type RateLimitStore interface {
+	// Allow adds the request to the window of the key unless the limit is reached
+	Allow(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimiter rejects callers making more requests than the limit of the longest matching prefix allows in its
+// window with 429, paths without a limit are not limited. Callers are counted by principal, taken from the context or
+// from the access token so the limiter can go before Authorize, anonymous callers and api keys by ip. When the
+// store fails requests are let through.
+func RateLimiter(store RateLimitStore, tokens *auth.TokenManager, limits []RateLimit) echo.MiddlewareFunc {
+	limits = slices.Clone(limits)
+	slices.SortFunc(limits, func(a, b RateLimit) int {
+		return len(b.Prefix) - len(a.Prefix)
+	})
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			path := c.Request().URL.Path
+			i := slices.IndexFunc(limits, func(limit RateLimit) bool {
+				return strings.HasPrefix(path, limit.Prefix)
+			})
+			if i < 0 {
+				return next(c)
+			}
+			limit := limits[i]
+
+			ctx := c.Request().Context()
+			key := "ratelimit:" + limit.Prefix + ":" + rateLimitCaller(c, tokens)
+			result, err := store.Allow(ctx, key, limit, time.Now())
+			if err != nil {
+				slog.ErrorContext(ctx, "error checking rate limit", "prefix", limit.Prefix, "error", err.Error())
+				return next(c)
+			}
+
+			reset := strconv.Itoa(int(math.Ceil(result.Reset.Seconds())))
+			header := c.Response().Header()
+			header.Set(RateLimitPolicyHeader, fmt.Sprintf("%d;w=%d", limit.Limit, int(limit.Window.Seconds())))
+			header.Set(RateLimitLimitHeader, strconv.Itoa(limit.Limit))
+			header.Set(RateLimitRemainingHeader, strconv.Itoa(max(limit.Limit-result.Count, 0)))
+			header.Set(RateLimitResetHeader, reset)
+			if !result.Allowed {
+				header.Set(echo.HeaderRetryAfter, reset)
+				return custom_errors.Respond(ctx, c, custom_errors.TooManyRequests, "too many requests, retry in "+reset+" seconds")
+			}
+			return next(c)
+		}
+	}
+}
+
+// rateLimitCaller principal of the request, the ip when there is none
+func rateLimitCaller(c echo.Context, tokens *auth.TokenManager) string {
+	principal, ok := auth.PrincipalFromContext(c.Request().Context())
+	if !ok && !auth.IsSigned(c.Request()) {
+		if token := auth.TokenFromRequest(c.Request()); token != "" && tokens != nil {
+			var err error
+			principal, err = tokens.ParseToken(token, auth.AccessToken)
+			ok = err == nil
+		}
+	}
+	if ok {
+		return principal.Type + ":" + principal.Id.Hex()
+	}
+	return "ip:" + c.RealIP()
+}
+
+// IPExtractor ip of the caller of a request, the limits and anonymous idempotency keys go by it. X-Forwarded-For is
+// only read when the request comes from one of the trusted proxies, a comma separated list of CIDRs, without any the
+// address of the connection is used so clients can not pick their ip.
+func IPExtractor(trustedProxies string) (echo.IPExtractor, error) {
+	var proxies []echo.TrustOption
+	for _, cidr := range strings.Split(trustedProxies, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		proxies = append(proxies, echo.TrustIPRange(ipRange))
+	}
+	if len(proxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	// echo trusts private networks by default, only the configured ranges are
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	return echo.ExtractIPFromXFFHeader(append(options, proxies...)...), nil
+}
+
+// ParseRateLimits limits written as /v1/restaurant/search_restaurant=30/1m,/v1=300/1m
+func ParseRateLimits(value string) ([]RateLimit, error) {
+	var limits []RateLimit
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, rest, found := strings.Cut(entry, "=")
+		count, window, found2 := strings.Cut(rest, "/")
+		if !found || !found2 || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid rate limit %q, expected /prefix=limit/window", entry)
+		}
+		limit, err := strconv.Atoi(count)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit in %q", entry)
+		}
+		duration, err := time.ParseDuration(window)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid window in %q", entry)
+		}
+		limits = append(limits, RateLimit{Prefix: prefix, Limit: limit, Window: duration})
+	}
+	return limits, nil
+}
+
+// slidingWindowScript keeps the requests of a caller in a sorted set scored by time in milliseconds, requests
+// older than the window are dropped before counting
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, window)
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+// RedisRateLimitStore sliding window log in redis
+type RedisRateLimitStore struct{}
+
+func (RedisRateLimitStore) Allow(ctx context.Context, key string, limit RateLimit, now time.Time) (RateLimitResult, error) {
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	defer db.Close(redisConn)
+
+	// unique member, requests in the same millisecond are all counted
+	member, err := uuid.GenerateUUID()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	values, err := slidingWindowScript.Run(ctx, redisConn, []string{key},
+		now.UnixMilli(), limit.Window.Milliseconds(), limit.Limit, member).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if len(values) != 3 {
+		return RateLimitResult{}, errors.New("unexpected rate limit script result")
+	}
+	return RateLimitResult{
+		Allowed: values[0] == 1,
+		Count:   int(values[1]),
+		Reset:   time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,152 @@
+package middleware_test
+
+import (
+	"context"
+	"food-eats/cmd/web/auth"
+	middleware "food-eats/cmd/web/middelwares"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeRateLimitStore sliding window log in memory
+type fakeRateLimitStore struct {
+	requests map[string][]time.Time
+}
+
+func (s *fakeRateLimitStore) Allow(_ context.Context, key string, limit middleware.RateLimit, now time.Time) (middleware.RateLimitResult, error) {
+	var window []time.Time
+	for _, at := range s.requests[key] {
+		if now.Sub(at) < limit.Window {
+			window = append(window, at)
+		}
+	}
+	allowed := len(window) < limit.Limit
+	if allowed {
+		window = append(window, now)
+	}
+	s.requests[key] = window
+	return middleware.RateLimitResult{Allowed: allowed, Count: len(window), Reset: limit.Window - now.Sub(window[0])}, nil
+}
+
+func TestRateLimiter(t *testing.T) {
+	tm := auth.NewTokenManager("secret")
+	limits, err := middleware.ParseRateLimits("/v1/restaurant/search_restaurant=2/1m, /v1=3/1m")
+	assert.NoError(t, err)
+
+	store := &fakeRateLimitStore{requests: map[string][]time.Time{}}
+	handler := middleware.RateLimiter(store, tm, limits)(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e := echo.New()
+	send := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		assert.NoError(t, handler(e.NewContext(req, rec)))
+		return rec
+	}
+
+	rec := send("/v1/restaurant/search_restaurant", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get(middleware.RateLimitLimitHeader))
+	assert.Equal(t, "1", rec.Header().Get(middleware.RateLimitRemainingHeader))
+	assert.Equal(t, "2;w=60", rec.Header().Get(middleware.RateLimitPolicyHeader))
+
+	assert.Equal(t, http.StatusOK, send("/v1/restaurant/search_restaurant", "").Code)
+	rec = send("/v1/restaurant/search_restaurant", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get(middleware.RateLimitRemainingHeader))
+	assert.Equal(t, "60", rec.Header().Get(echo.HeaderRetryAfter))
+
+	// other routes have their own window
+	assert.Equal(t, http.StatusOK, send("/v1/restaurant/get", "").Code)
+
+	// logged in callers are counted on their own, not by ip
+	token, err := tm.IssueToken(auth.Principal{Id: primitive.NewObjectID(), Type: auth.UserPrincipal}, auth.AccessToken, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, send("/v1/restaurant/search_restaurant", token).Code)
+
+	// paths without a limit
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, send("/health", "").Code)
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := middleware.ParseRateLimits("/v1/auth=20/1m,/v1=600/30s")
+	assert.NoError(t, err)
+	assert.Equal(t, []middleware.RateLimit{
+		{Prefix: "/v1/auth", Limit: 20, Window: time.Minute},
+		{Prefix: "/v1", Limit: 600, Window: 30 * time.Second},
+	}, limits)
+
+	for _, invalid := range []string{"v1=20/1m", "/v1=20", "/v1=0/1m", "/v1=20/week"} {
+		_, err := middleware.ParseRateLimits(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRateLimiter_SpoofedForwardedFor(t *testing.T) {
+	limits, err := middleware.ParseRateLimits("/v1=2/1m")
+	assert.NoError(t, err)
+
+	send := func(e *echo.Echo, handler echo.HandlerFunc, remoteAddr string, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/auth/otp/send", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		assert.NoError(t, handler(e.NewContext(req, rec)))
+		return rec.Code
+	}
+	newHandler := func() echo.HandlerFunc {
+		store := &fakeRateLimitStore{requests: map[string][]time.Time{}}
+		return middleware.RateLimiter(store, nil, limits)(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+	}
+
+	t.Run("without trusted proxies the header is ignored", func(t *testing.T) {
+		e := echo.New()
+		e.IPExtractor, err = middleware.IPExtractor("")
+		assert.NoError(t, err)
+		handler := newHandler()
+
+		assert.Equal(t, http.StatusOK, send(e, handler, "203.0.113.7:4000", ""))
+		assert.Equal(t, http.StatusOK, send(e, handler, "203.0.113.7:4000", "198.51.100.1"))
+		assert.Equal(t, http.StatusTooManyRequests, send(e, handler, "203.0.113.7:4000", "198.51.100.2"))
+	})
+
+	t.Run("the header is only read from trusted proxies", func(t *testing.T) {
+		e := echo.New()
+		e.IPExtractor, err = middleware.IPExtractor("10.0.0.0/8")
+		assert.NoError(t, err)
+		handler := newHandler()
+
+		// clients behind the load balancer get a window each
+		assert.Equal(t, http.StatusOK, send(e, handler, "10.0.0.5:4000", "198.51.100.1"))
+		assert.Equal(t, http.StatusOK, send(e, handler, "10.0.0.5:4000", "198.51.100.1"))
+		assert.Equal(t, http.StatusTooManyRequests, send(e, handler, "10.0.0.5:4000", "198.51.100.1"))
+		assert.Equal(t, http.StatusOK, send(e, handler, "10.0.0.5:4000", "198.51.100.2"))
+
+		// a client prepending its own entry is still told apart by the one the proxy added
+		assert.Equal(t, http.StatusTooManyRequests, send(e, handler, "10.0.0.5:4000", "192.0.2.50, 198.51.100.1"))
+
+		// a client talking to the server directly can not pick its ip
+		assert.Equal(t, http.StatusOK, send(e, handler, "203.0.113.7:4000", "198.51.100.9"))
+		assert.Equal(t, http.StatusOK, send(e, handler, "203.0.113.7:4000", "198.51.100.10"))
+		assert.Equal(t, http.StatusTooManyRequests, send(e, handler, "203.0.113.7:4000", "198.51.100.11"))
+	})
+
+	_, err = middleware.IPExtractor("10.0.0.0/8,load-balancer")
+	assert.Error(t, err)
+}
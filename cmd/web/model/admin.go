@@ -0,0 +1,98 @@
+package model
+
+import (
+	"context"
+	"errors"
+	errors2 "food-eats/cmd/web/custom-errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// Admin support agent or admin operating the platform
+type Admin struct {
+	Id          primitive.ObjectID `json:"id" bson:"_id,omitempty"` // omitempty so a mongo-driver can generate unique id
+	Name        string             `json:"name" bson:"name"`
+	EmailId     string             `json:"email_id" bson:"emailId"`
+	PhoneNumber string             `json:"phone_number" bson:"phoneNumber"`
+	Verified    bool               `json:"verified" bson:"verified"`
+	Role        string             `json:"role" bson:"role"` // SUPPORT_AGENT or ADMIN
+	CreatedAt   time.Time          `json:"created_at" bson:"createdAt"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updatedAt"`
+
+	Status string `json:"status" bson:"status"`
+}
+
+// AdminRepository will be the admin repository, a database needs to implement this contract
+type AdminRepository interface {
+	CreateAdmin(ctx context.Context, admin Admin) (Admin, error)
+	GetAdmin(ctx context.Context, id primitive.ObjectID) (Admin, error)
+	GetAdminByPhone(ctx context.Context, phoneNumber string) (Admin, error)
+	SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error
+}
+
+func AdminMongoRepo(DB *mongo.Database) AdminMongoDb {
+	return AdminMongoDb{DB: DB}
+}
+
+// AdminMongoDb type with embedded mongo.Database
+type AdminMongoDb struct {
+	DB *mongo.Database
+}
+
+func (u AdminMongoDb) CreateAdmin(ctx context.Context, admin Admin) (Admin, error) {
+	insertedId, err := u.DB.Collection("Admin").InsertOne(ctx, admin)
+	if err != nil {
+		return Admin{}, duplicateKeyError(err, "phone number already registered")
+	}
+	if insertedId == nil {
+		return Admin{}, errors.Join(errors2.ServerError, errors.New("empty inserted id"))
+	}
+	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
+	admin.Id = id
+	return admin, nil
+}
+
+func (u AdminMongoDb) GetAdmin(ctx context.Context, id primitive.ObjectID) (Admin, error) {
+	var admin Admin
+	err := u.DB.Collection("Admin").FindOne(ctx, bson.M{"_id": id}).Decode(&admin)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return admin, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return admin, errors.Join(errors2.ServerError, err)
+	}
+	return admin, nil
+}
+
+// GetAdminByPhone admin registered with the phone number which is not deleted, used on login
+func (u AdminMongoDb) GetAdminByPhone(ctx context.Context, phoneNumber string) (Admin, error) {
+	var admin Admin
+	filter := bson.M{"phoneNumber": phoneNumber, "status": bson.M{"$ne": "DELETED"}}
+	err := u.DB.Collection("Admin").FindOne(ctx, filter).Decode(&admin)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return admin, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return admin, errors.Join(errors2.ServerError, err)
+	}
+	return admin, nil
+}
+
+// SetPhoneVerified marks the phone number of the admin as verified by an otp
+func (u AdminMongoDb) SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error {
+	updateResult, err := u.DB.Collection("Admin").UpdateByID(ctx, id, bson.M{"$set": bson.M{"verified": true, "updatedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	if updateResult == nil {
+		return errors.Join(errors2.ServerError, errors.New("no update result"))
+	}
+	if updateResult.MatchedCount != 1 {
+		return errors.Join(errors2.NotFoundError, errors.New("no matching document"))
+	}
+
+	return nil
+}
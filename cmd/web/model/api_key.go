@@ -0,0 +1,177 @@
+package model
+
+import (
+	"context"
+	"errors"
+	errors2 "food-eats/cmd/web/custom-errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// apiKeyUsageRetention usage logs are removed by mongo after this
+const apiKeyUsageRetention = 30 * 24 * time.Hour
+
+// ApiKey key of a partner system acting for a restaurant, the secret is needed to verify signatures so it is
+// stored as is and only returned when the key is created or rotated
+type ApiKey struct {
+	Id           primitive.ObjectID `json:"id" bson:"_id,omitempty"`           // omitempty so a mongo-driver can generate unique id
+	RestaurantId primitive.ObjectID `json:"restaurant_id" bson:"restaurantId"` // index
+	Name         string             `json:"name" bson:"name"`
+	KeyId        string             `json:"key_id" bson:"keyId"` // unique index, sent as X-Api-Key
+	Secret       string             `json:"-" bson:"secret"`
+	Scopes       []string           `json:"scopes" bson:"scopes"`
+
+	CreatedBy  primitive.ObjectID `json:"created_by" bson:"createdBy"`
+	CreatedAt  time.Time          `json:"created_at" bson:"createdAt"`
+	UpdatedAt  time.Time          `json:"updated_at" bson:"updatedAt"`
+	ExpiresAt  time.Time          `json:"expires_at,omitempty" bson:"expiresAt,omitempty"` // set on rotation, the key works until then
+	RotatedTo  primitive.ObjectID `json:"rotated_to,omitempty" bson:"rotatedTo,omitempty"`
+	LastUsedAt time.Time          `json:"last_used_at,omitempty" bson:"lastUsedAt,omitempty"`
+
+	Status string `json:"status" bson:"status"` // ACTIVE or REVOKED
+}
+
+// IsActive key can sign requests
+func (k ApiKey) IsActive(at time.Time) bool {
+	return k.Status == "ACTIVE" && (k.ExpiresAt.IsZero() || at.Before(k.ExpiresAt))
+}
+
+// ApiKeyUsage request made with an api key
+type ApiKeyUsage struct {
+	Id         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ApiKeyId   primitive.ObjectID `json:"api_key_id" bson:"apiKeyId"` // index
+	Method     string             `json:"method" bson:"method"`
+	Path       string             `json:"path" bson:"path"`
+	Status     int                `json:"status" bson:"status"`
+	RemoteAddr string             `json:"remote_addr" bson:"remoteAddr"`
+	At         time.Time          `json:"at" bson:"at"` // ttl index
+}
+
+// ApiKeyRepository will be the api key repository, a database needs to implement this contract
+type ApiKeyRepository interface {
+	CreateApiKey(ctx context.Context, key ApiKey) (ApiKey, error)
+	UpdateApiKey(ctx context.Context, key ApiKey) error
+	GetApiKey(ctx context.Context, id primitive.ObjectID) (ApiKey, error)
+	GetApiKeyByKeyId(ctx context.Context, keyId string) (ApiKey, error)
+	GetRestaurantApiKeys(ctx context.Context, restaurantId primitive.ObjectID) ([]ApiKey, error)
+	AddUsage(ctx context.Context, usage ApiKeyUsage) error
+	GetUsage(ctx context.Context, apiKeyId primitive.ObjectID, limit int) ([]ApiKeyUsage, error)
+}
+
+func ApiKeyMongoRepo(DB *mongo.Database) ApiKeyMongoDb {
+	return ApiKeyMongoDb{DB: DB}
+}
+
+// ApiKeyMongoDb type with embedded mongo.Database
+type ApiKeyMongoDb struct {
+	DB *mongo.Database
+}
+
+// CreateApiKeyIndexes key ids have to be unique and usage logs expire
+func CreateApiKeyIndexes(ctx context.Context, DB *mongo.Database) error {
+	_, err := DB.Collection("ApiKey").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "keyId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "restaurantId", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = DB.Collection("ApiKeyUsage").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "apiKeyId", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(apiKeyUsageRetention.Seconds()))},
+	})
+	return err
+}
+
+func (u ApiKeyMongoDb) CreateApiKey(ctx context.Context, key ApiKey) (ApiKey, error) {
+	insertedId, err := u.DB.Collection("ApiKey").InsertOne(ctx, key)
+	if err != nil {
+		return ApiKey{}, duplicateKeyError(err, "api key already exists")
+	}
+	if insertedId == nil {
+		return ApiKey{}, errors.Join(errors2.ServerError, errors.New("empty inserted id"))
+	}
+	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
+	key.Id = id
+	return key, nil
+}
+
+func (u ApiKeyMongoDb) UpdateApiKey(ctx context.Context, key ApiKey) error {
+	updateResult, err := u.DB.Collection("ApiKey").UpdateOne(ctx, bson.M{"_id": key.Id}, bson.M{"$set": key})
+	if err != nil {
+		return err
+	}
+
+	if updateResult == nil {
+		return errors.Join(errors2.ServerError, errors.New("no update result"))
+	}
+	if updateResult.MatchedCount != 1 {
+		return errors.Join(errors2.NotFoundError, errors.New("no matching document"))
+	}
+
+	return nil
+}
+
+func (u ApiKeyMongoDb) GetApiKey(ctx context.Context, id primitive.ObjectID) (ApiKey, error) {
+	return u.findOne(ctx, bson.M{"_id": id})
+}
+
+func (u ApiKeyMongoDb) GetApiKeyByKeyId(ctx context.Context, keyId string) (ApiKey, error) {
+	return u.findOne(ctx, bson.M{"keyId": keyId})
+}
+
+func (u ApiKeyMongoDb) findOne(ctx context.Context, filter bson.M) (ApiKey, error) {
+	var key ApiKey
+	err := u.DB.Collection("ApiKey").FindOne(ctx, filter).Decode(&key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return key, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return key, errors.Join(errors2.ServerError, err)
+	}
+	return key, nil
+}
+
+// GetRestaurantApiKeys every key of the restaurant, revoked ones included, newest first
+func (u ApiKeyMongoDb) GetRestaurantApiKeys(ctx context.Context, restaurantId primitive.ObjectID) ([]ApiKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := u.DB.Collection("ApiKey").Find(ctx, bson.M{"restaurantId": restaurantId}, opts)
+	if err != nil {
+		return nil, errors.Join(errors2.ServerError, err)
+	}
+	defer cursor.Close(ctx)
+
+	keys := []ApiKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, errors.Join(errors2.ServerError, err)
+	}
+	return keys, nil
+}
+
+// AddUsage logs the request and updates the last use of the key
+func (u ApiKeyMongoDb) AddUsage(ctx context.Context, usage ApiKeyUsage) error {
+	if _, err := u.DB.Collection("ApiKeyUsage").InsertOne(ctx, usage); err != nil {
+		return err
+	}
+	_, err := u.DB.Collection("ApiKey").UpdateByID(ctx, usage.ApiKeyId, bson.M{"$set": bson.M{"lastUsedAt": usage.At}})
+	return err
+}
+
+// GetUsage latest requests made with the key, newest first
+func (u ApiKeyMongoDb) GetUsage(ctx context.Context, apiKeyId primitive.ObjectID, limit int) ([]ApiKeyUsage, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := u.DB.Collection("ApiKeyUsage").Find(ctx, bson.M{"apiKeyId": apiKeyId}, opts)
+	if err != nil {
+		return nil, errors.Join(errors2.ServerError, err)
+	}
+	defer cursor.Close(ctx)
+
+	usage := []ApiKeyUsage{}
+	if err := cursor.All(ctx, &usage); err != nil {
+		return nil, errors.Join(errors2.ServerError, err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,324 @@
+package model
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	errors2 "food-eats/cmd/web/custom-errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log/slog"
+	"reflect"
+	"slices"
+	"time"
+)
+
+// actions recorded in the audit log
+const (
+	AuditCreate = "CREATE"
+	AuditUpdate = "UPDATE"
+	AuditDelete = "DELETE"
+)
+
+// SystemActor type of the actor for changes made without a logged-in caller, like sign ups and background jobs
+const SystemActor = "SYSTEM"
+
+// redacted value recorded for fields which must not end up in the audit log
+const redacted = "[REDACTED]"
+
+// redactedFields changes of these fields are recorded without their values
+var redactedFields = []string{"payout", "deliveryOtp"}
+
+// ignoredFields change on every write and are not worth recording, restaurants store their update time as updated_at
+var ignoredFields = []string{"_id", "updatedAt", "updated_at"}
+
+// AuditActor who made a change
+type AuditActor struct {
+	Id   primitive.ObjectID `json:"id,omitempty" bson:"id,omitempty"`
+	Type string             `json:"type" bson:"type"` // USER, RIDER, RESTAURANT, STAFF, ADMIN, API_KEY or SYSTEM
+	Role string             `json:"role,omitempty" bson:"role,omitempty"`
+}
+
+// AuditChange value of a field before and after a change, nested fields are written as menu.items
+type AuditChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before,omitempty" bson:"before,omitempty"`
+	After  interface{} `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// AuditLog a create, update or delete of a user, rider, restaurant, order or rating
+type AuditLog struct {
+	Id            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Actor         AuditActor         `json:"actor" bson:"actor"`
+	Action        string             `json:"action" bson:"action"` // CREATE, UPDATE or DELETE
+	Entity        string             `json:"entity" bson:"entity"` // User, Rider, Restaurant, Order or Rating
+	EntityId      primitive.ObjectID `json:"entity_id" bson:"entityId"`
+	Changes       []AuditChange      `json:"changes" bson:"changes"`
+	CorrelationId string             `json:"correlation_id,omitempty" bson:"correlationId,omitempty"`
+	At            time.Time          `json:"at" bson:"at"`
+}
+
+// AuditQuery filters of the audit log, empty filters match everything
+type AuditQuery struct {
+	Entity   string
+	EntityId primitive.ObjectID
+	ActorId  primitive.ObjectID
+	Action   string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Skip     int
+}
+
+// AuditRepository will be the audit log repository, a database needs to implement this contract
+type AuditRepository interface {
+	AddAuditLog(ctx context.Context, log AuditLog) error
+	SearchAuditLogs(ctx context.Context, query AuditQuery) ([]AuditLog, int64, error)
+}
+
+func AuditMongoRepo(DB *mongo.Database) AuditMongoDb {
+	return AuditMongoDb{DB: DB}
+}
+
+// AuditMongoDb type with embedded mongo.Database
+type AuditMongoDb struct {
+	DB *mongo.Database
+}
+
+// CreateAuditIndexes the history of an entity and the changes of an actor are looked up newest first
+func CreateAuditIndexes(ctx context.Context, DB *mongo.Database) error {
+	_, err := DB.Collection("AuditLog").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "entityId", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor.id", Value: 1}, {Key: "at", Value: -1}}},
+		{Keys: bson.D{{Key: "at", Value: -1}}},
+	})
+	return err
+}
+
+func (u AuditMongoDb) AddAuditLog(ctx context.Context, log AuditLog) error {
+	_, err := u.DB.Collection("AuditLog").InsertOne(ctx, log)
+	return err
+}
+
+// SearchAuditLogs matching logs newest first with the total count of matches
+func (u AuditMongoDb) SearchAuditLogs(ctx context.Context, query AuditQuery) ([]AuditLog, int64, error) {
+	filter := bson.M{}
+	if query.Entity != "" {
+		filter["entity"] = query.Entity
+	}
+	if !query.EntityId.IsZero() {
+		filter["entityId"] = query.EntityId
+	}
+	if !query.ActorId.IsZero() {
+		filter["actor.id"] = query.ActorId
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	at := bson.M{}
+	if !query.From.IsZero() {
+		at["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		at["$lt"] = query.To
+	}
+	if len(at) > 0 {
+		filter["at"] = at
+	}
+
+	totalCount, err := u.DB.Collection("AuditLog").CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	options := options.Find()
+	options.SetSort(bson.D{{Key: "at", Value: -1}})
+	options.SetLimit(int64(query.Limit))
+	options.SetSkip(int64(query.Skip))
+
+	cursor, err := u.DB.Collection("AuditLog").Find(ctx, filter, options)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var logs []AuditLog
+	if err := cursor.All(ctx, &logs); err != nil {
+		return nil, 0, err
+	}
+
+	return logs, totalCount, nil
+}
+
+// auditedUpdate sets the fields of set on the document with the id in the collection of the entity and records the
+// change in the audit log. The document before the change is returned by the same write, so a concurrent write can
+// not end up in the snapshot, and the document after it is that snapshot with the fields set. Returns whether the
+// document changed. Failing to record is logged and does not fail the change.
+func auditedUpdate(ctx context.Context, DB *mongo.Database, entity string, action string, id primitive.ObjectID, set interface{}) (bool, error) {
+	return auditedUpdateWhere(ctx, DB, entity, action, id, bson.M{}, set)
+}
+
+// auditedUpdateWhere auditedUpdate of the document only while it also matches the conditions, NotFoundError when the
+// document is missing or does not match them, the unset fields are removed in the same update
+func auditedUpdateWhere(ctx context.Context, DB *mongo.Database, entity string, action string, id primitive.ObjectID, conditions bson.M, set interface{}, unset ...string) (bool, error) {
+	filter := bson.M{"_id": id}
+	for field, value := range conditions {
+		filter[field] = value
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		fields := bson.M{}
+		for _, field := range unset {
+			fields[field] = ""
+		}
+		update["$unset"] = fields
+	}
+	var before bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := DB.Collection(entity).FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, errors.Join(errors2.NotFoundError, errors.New("no matching document"))
+		}
+		return false, err
+	}
+
+	after, err := ApplySet(before, set, unset...)
+	if err != nil {
+		slog.ErrorContext(ctx, "error recording audit log", "entity", entity, "id", id.Hex(), "error", err.Error())
+		return true, nil
+	}
+	recordAudit(ctx, DB, entity, action, id, DiffDocuments(before, after))
+	return !reflect.DeepEqual(before, after), nil
+}
+
+// ApplySet the document as it is after a $set of the fields of set and an $unset of the unset fields,
+// the document itself is not changed
+func ApplySet(document bson.M, set interface{}, unset ...string) (bson.M, error) {
+	raw, err := bson.Marshal(set)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	after := make(bson.M, len(document)+len(fields))
+	for field, value := range document {
+		after[field] = value
+	}
+	for field, value := range fields {
+		after[field] = value
+	}
+	for _, field := range unset {
+		delete(after, field)
+	}
+	return after, nil
+}
+
+// auditCreated records the inserted document, all of its fields are recorded as changed
+func auditCreated(ctx context.Context, DB *mongo.Database, entity string, id primitive.ObjectID, document interface{}) {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		slog.ErrorContext(ctx, "error recording audit log", "entity", entity, "id", id.Hex(), "error", err.Error())
+		return
+	}
+	var after bson.M
+	if err := bson.Unmarshal(raw, &after); err != nil {
+		slog.ErrorContext(ctx, "error recording audit log", "entity", entity, "id", id.Hex(), "error", err.Error())
+		return
+	}
+	recordAudit(ctx, DB, entity, AuditCreate, id, DiffDocuments(nil, after))
+}
+
+type auditActorKey struct{}
+
+type correlationIdKey struct{}
+
+// WithAuditActor context whose changes are recorded as made by the actor, SYSTEM when there is none
+func WithAuditActor(ctx context.Context, actor AuditActor) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// WithCorrelationId context whose changes are recorded with the correlation id of the request
+func WithCorrelationId(ctx context.Context, correlationId string) context.Context {
+	return context.WithValue(ctx, correlationIdKey{}, correlationId)
+}
+
+// recordAudit writes the changes with the actor and correlation id of the request, updates changing nothing are
+// not recorded
+func recordAudit(ctx context.Context, DB *mongo.Database, entity string, action string, id primitive.ObjectID, changes []AuditChange) {
+	if len(changes) == 0 && action == AuditUpdate {
+		return
+	}
+	log := AuditLog{
+		Actor:    AuditActor{Type: SystemActor},
+		Action:   action,
+		Entity:   entity,
+		EntityId: id,
+		Changes:  changes,
+		At:       time.Now(),
+	}
+	if actor, ok := ctx.Value(auditActorKey{}).(AuditActor); ok {
+		log.Actor = actor
+	}
+	log.CorrelationId, _ = ctx.Value(correlationIdKey{}).(string)
+
+	if err := AuditMongoRepo(DB).AddAuditLog(ctx, log); err != nil {
+		slog.ErrorContext(ctx, "error recording audit log", "entity", entity, "id", id.Hex(), "action", action, "error", err.Error())
+	}
+}
+
+// DiffDocuments changed fields between two versions of a document, embedded documents are compared field by field
+// and lists as a whole. Values of sensitive fields are redacted.
+func DiffDocuments(before, after bson.M) []AuditChange {
+	changes := diffDocuments("", before, after)
+	slices.SortFunc(changes, func(a, b AuditChange) int {
+		return cmp.Compare(a.Field, b.Field)
+	})
+	return changes
+}
+
+func diffDocuments(prefix string, before, after bson.M) []AuditChange {
+	var changes []AuditChange
+	fields := map[string]struct{}{}
+	for field := range before {
+		fields[field] = struct{}{}
+	}
+	for field := range after {
+		fields[field] = struct{}{}
+	}
+
+	for field := range fields {
+		if prefix == "" && slices.Contains(ignoredFields, field) {
+			continue
+		}
+		name := prefix + field
+		oldValue, newValue := before[field], after[field]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		if prefix == "" && slices.Contains(redactedFields, field) {
+			changes = append(changes, AuditChange{Field: name, Before: redactedValue(oldValue), After: redactedValue(newValue)})
+			continue
+		}
+		oldDocument, oldIsDocument := oldValue.(bson.M)
+		newDocument, newIsDocument := newValue.(bson.M)
+		if oldIsDocument && newIsDocument {
+			changes = append(changes, diffDocuments(name+".", oldDocument, newDocument)...)
+			continue
+		}
+		changes = append(changes, AuditChange{Field: name, Before: oldValue, After: newValue})
+	}
+	return changes
+}
+
+// redactedValue keeps whether a sensitive field was set, not its value
+func redactedValue(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return redacted
+}
@@ -0,0 +1,87 @@
+package model_test
+
+import (
+	"food-eats/cmd/web/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// document restaurant as it is read from mongo
+func document(t *testing.T, restaurant model.Restaurant) bson.M {
+	raw, err := bson.Marshal(restaurant)
+	assert.NoError(t, err)
+	var doc bson.M
+	assert.NoError(t, bson.Unmarshal(raw, &doc))
+	return doc
+}
+
+func TestDiffDocuments(t *testing.T) {
+	before := model.Restaurant{
+		Id:       primitive.NewObjectID(),
+		Name:     "Dosa Point",
+		Status:   "ACTIVE",
+		Location: model.NewLocationFromLongLat(77.59, 12.97),
+		Menu:     model.Menu{Items: []model.Item{{Id: "masala-dosa", Name: "Masala Dosa", Price: 90}}},
+		Payout:   model.PayoutDetails{AccountNumber: "123456789"},
+	}
+	after := before
+	after.Status = "SUSPENDED"
+	after.UpdatedAt = time.Now()
+	after.Location = model.NewLocationFromLongLat(77.6, 12.97)
+	after.Menu = model.Menu{Items: []model.Item{{Id: "masala-dosa", Name: "Masala Dosa", Price: 110}}}
+	after.Payout = model.PayoutDetails{AccountNumber: "987654321"}
+
+	changes := model.DiffDocuments(document(t, before), document(t, after))
+	assert.Equal(t, []model.AuditChange{
+		{Field: "location.coordinates", Before: bson.A{77.59, 12.97}, After: bson.A{77.6, 12.97}},
+		{Field: "menu.items", Before: document(t, before)["menu"].(bson.M)["items"], After: document(t, after)["menu"].(bson.M)["items"]},
+		{Field: "payout", Before: "[REDACTED]", After: "[REDACTED]"},
+		{Field: "status", Before: "ACTIVE", After: "SUSPENDED"},
+	}, changes)
+
+	// nothing changed but the update time
+	assert.Empty(t, model.DiffDocuments(document(t, before), document(t, before)))
+
+	// created documents have every field without a before value
+	created := model.DiffDocuments(nil, document(t, before))
+	assert.Contains(t, created, model.AuditChange{Field: "name", After: "Dosa Point"})
+	assert.Contains(t, created, model.AuditChange{Field: "payout", After: "[REDACTED]"})
+	for _, change := range created {
+		assert.Nil(t, change.Before)
+		assert.NotEqual(t, "_id", change.Field)
+	}
+}
+
+func TestApplySet(t *testing.T) {
+	before := model.Restaurant{Id: primitive.NewObjectID(), Name: "Dosa Point", Status: "ACTIVE", AverageRating: 4.1}
+	stored := document(t, before)
+
+	// a whole document set ends up as that document
+	after := before
+	after.Name = "Dosa Corner"
+	applied, err := model.ApplySet(stored, after)
+	assert.NoError(t, err)
+	assert.Equal(t, document(t, after), applied)
+
+	// fields set are replaced, the others kept
+	applied, err = model.ApplySet(stored, bson.M{"status": "DELETED"})
+	assert.NoError(t, err)
+	assert.Equal(t, []model.AuditChange{{Field: "status", Before: "ACTIVE", After: "DELETED"}}, model.DiffDocuments(stored, applied))
+	assert.Equal(t, "ACTIVE", stored["status"])
+
+	// setting the stored values changes nothing
+	applied, err = model.ApplySet(stored, bson.M{"averageRating": 4.1})
+	assert.NoError(t, err)
+	assert.Equal(t, stored, applied)
+
+	// unset fields are removed
+	applied, err = model.ApplySet(stored, bson.M{"status": "DELETED"}, "averageRating")
+	assert.NoError(t, err)
+	assert.NotContains(t, applied, "averageRating")
+	assert.Equal(t, "DELETED", applied["status"])
+	assert.Contains(t, stored, "averageRating")
+}
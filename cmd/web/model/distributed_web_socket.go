@@ -0,0 +1,422 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	riderChannelPrefix      = "ws:rider:"
+	userChannelPrefix       = "ws:user:"
+	restaurantChannelPrefix = "ws:restaurant:"
+	orderChannelPrefix      = "ws:order:"
+
+	// registry entries expire unless the node holding the socket keeps refreshing them,
+	// so sockets of a crashed node are not reported for long
+	registryTTL     = 2 * time.Minute
+	registryRefresh = time.Minute
+
+	// evictChannel every node listens on, a node taking over a socket with the REPLACE policy asks the
+	// other nodes to close their connection of the same id
+	evictChannel = "ws:evict"
+)
+
+// BusMessage message published on a channel
+type BusMessage struct {
+	Channel string
+	Payload string
+}
+
+// SocketBus pub/sub between the nodes and the registry of the node holding a socket
+type SocketBus interface {
+	Subscribe(ctx context.Context, channel string) error
+	Unsubscribe(ctx context.Context, channel string) error
+	// Messages published on the subscribed channels
+	Messages() <-chan BusMessage
+	Publish(ctx context.Context, messages ...BusMessage) error
+	// Register records the node as holding the sockets of the channels for the ttl, next to the other nodes holding them
+	Register(ctx context.Context, node string, ttl time.Duration, channels ...string) error
+	// Take records the node as the only one holding the socket of the channel for the ttl
+	Take(ctx context.Context, node string, ttl time.Duration, channel string) error
+	// Deregister removes the entry of the node for the channel, the entries of other nodes are kept
+	Deregister(ctx context.Context, node string, channel string) error
+	// Nodes holding each of the channels, empty for channels not connected anywhere
+	Nodes(ctx context.Context, channels ...string) ([][]string, error)
+}
+
+// evictMessage published on the evict channel
+type evictMessage struct {
+	Node    string `json:"node"`    // node which took over the socket
+	Channel string `json:"channel"` // channel of the rider, user or restaurant
+}
+
+// DistributedSocketManager WebSocketManager which works across multiple server instances.
+// Every message is published on a redis channel of the rider or user, the node holding the socket
+// is subscribed to that channel and writes it to the socket. A registry in redis records which node
+// holds each socket, broadcasts are only published for riders and users connected somewhere.
+type DistributedSocketManager struct {
+	local  *SocketManager
+	bus    SocketBus
+	nodeId string
+	lock   sync.Mutex
+}
+
+// NewDistributedSocketManager creates a DistributedSocketManager and starts listening for messages of this node
+func NewDistributedSocketManager(local *SocketManager, bus SocketBus, nodeId string) *DistributedSocketManager {
+	dm := &DistributedSocketManager{
+		local:  local,
+		bus:    bus,
+		nodeId: nodeId,
+	}
+	if err := bus.Subscribe(context.Background(), evictChannel); err != nil {
+		slog.Error("error subscribing to socket channel", "channel", evictChannel, "err", err)
+	}
+	go dm.listen()
+	go dm.refreshRegistry()
+	return dm
+}
+
+// RegisterRider registers the socket locally and subscribes to the rider channel, connections of the rider on other
+// nodes are closed
+func (dm *DistributedSocketManager) RegisterRider(riderID primitive.ObjectID, conn *WebSocketClient) {
+	dm.subscribe(riderChannelPrefix+riderID.Hex(), dm.local.RiderPolicy, func() { dm.local.RegisterRider(riderID, conn) })
+}
+
+// RegisterUser registers the socket locally and subscribes to the user channel
+func (dm *DistributedSocketManager) RegisterUser(userID primitive.ObjectID, conn *WebSocketClient) {
+	dm.subscribe(userChannelPrefix+userID.Hex(), dm.local.UserPolicy, func() { dm.local.RegisterUser(userID, conn) })
+}
+
+// RegisterRestaurant registers the socket locally and subscribes to the restaurant channel
+func (dm *DistributedSocketManager) RegisterRestaurant(restaurantID primitive.ObjectID, conn *WebSocketClient) {
+	dm.subscribe(restaurantChannelPrefix+restaurantID.Hex(), dm.local.RestaurantPolicy, func() { dm.local.RegisterRestaurant(restaurantID, conn) })
+}
+
+// RegisterOrderStream registers the stream locally and subscribes to the order channel
+func (dm *DistributedSocketManager) RegisterOrderStream(orderID primitive.ObjectID, conn *WebSocketClient) {
+	dm.subscribe(orderChannelPrefix+orderID.Hex(), MultiDevice, func() { dm.local.RegisterOrderStream(orderID, conn) })
+}
+
+// UnregisterRider removes the local socket and stops listening on the rider channel once the rider
+// has no other socket on this node
+func (dm *DistributedSocketManager) UnregisterRider(riderID primitive.ObjectID, conn *WebSocketClient) {
+	dm.local.UnregisterRider(riderID, conn)
+	if !dm.local.hasRider(riderID) {
+		dm.unsubscribe(riderChannelPrefix + riderID.Hex())
+	}
+}
+
+// UnregisterUser removes the local socket and stops listening on the user channel once the user
+// has no other socket on this node
+func (dm *DistributedSocketManager) UnregisterUser(userID primitive.ObjectID, conn *WebSocketClient) {
+	dm.local.UnregisterUser(userID, conn)
+	if !dm.local.hasUser(userID) {
+		dm.unsubscribe(userChannelPrefix + userID.Hex())
+	}
+}
+
+// UnregisterRestaurant removes the local socket and stops listening on the restaurant channel once the
+// restaurant has no other socket on this node
+func (dm *DistributedSocketManager) UnregisterRestaurant(restaurantID primitive.ObjectID, conn *WebSocketClient) {
+	dm.local.UnregisterRestaurant(restaurantID, conn)
+	if !dm.local.hasRestaurant(restaurantID) {
+		dm.unsubscribe(restaurantChannelPrefix + restaurantID.Hex())
+	}
+}
+
+// UnregisterOrderStream removes the local stream and stops listening on the order channel once the order
+// has no other stream on this node
+func (dm *DistributedSocketManager) UnregisterOrderStream(orderID primitive.ObjectID, conn *WebSocketClient) {
+	dm.local.UnregisterOrderStream(orderID, conn)
+	if !dm.local.hasOrderStream(orderID) {
+		dm.unsubscribe(orderChannelPrefix + orderID.Hex())
+	}
+}
+
+// BroadcastToRiders publishes the message to the channel of every connected rider
+func (dm *DistributedSocketManager) BroadcastToRiders(message string, riderIDs []primitive.ObjectID) {
+	dm.publish(riderChannelPrefix, message, riderIDs)
+}
+
+// BroadcastToUsers publishes the message to the channel of every connected user
+func (dm *DistributedSocketManager) BroadcastToUsers(message string, userIDs []primitive.ObjectID) {
+	dm.publish(userChannelPrefix, message, userIDs)
+}
+
+// BroadcastToRestaurants publishes the message to the channel of every connected restaurant
+func (dm *DistributedSocketManager) BroadcastToRestaurants(message string, restaurantIDs []primitive.ObjectID) {
+	dm.publish(restaurantChannelPrefix, message, restaurantIDs)
+}
+
+// BroadcastToOrderStreams publishes the message to the channel of every order tracked somewhere
+func (dm *DistributedSocketManager) BroadcastToOrderStreams(message string, orderIDs []primitive.ObjectID) {
+	dm.publish(orderChannelPrefix, message, orderIDs)
+}
+
+// Stats connection counts of the sockets held by this node
+func (dm *DistributedSocketManager) Stats() SocketStats {
+	return dm.local.Stats()
+}
+
+// subscribe registers the socket locally, listens on the channel and registers this node as holding it. With the
+// REPLACE policy this node takes the channel over and the other nodes are told to close their connection of the same
+// id, after taking it so an evict can be told apart from a stale one.
+func (dm *DistributedSocketManager) subscribe(channel string, policy ConnectionPolicy, register func()) {
+	ctx := context.Background()
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	register()
+	if err := dm.bus.Subscribe(ctx, channel); err != nil {
+		slog.Error("error subscribing to socket channel", "channel", channel, "err", err)
+		return
+	}
+	if policy != ReplaceConnection {
+		if err := dm.bus.Register(ctx, dm.nodeId, registryTTL, channel); err != nil {
+			slog.Error("error registering socket", "channel", channel, "err", err)
+		}
+		return
+	}
+	if err := dm.bus.Take(ctx, dm.nodeId, registryTTL, channel); err != nil {
+		slog.Error("error registering socket", "channel", channel, "err", err)
+	}
+	evict, _ := json.Marshal(evictMessage{Node: dm.nodeId, Channel: channel})
+	if err := dm.bus.Publish(ctx, BusMessage{Channel: evictChannel, Payload: string(evict)}); err != nil {
+		slog.Error("error evicting socket on other nodes", "channel", channel, "err", err)
+	}
+}
+
+func (dm *DistributedSocketManager) unsubscribe(channel string) {
+	ctx := context.Background()
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	if err := dm.bus.Unsubscribe(ctx, channel); err != nil {
+		slog.Error("error unsubscribing from socket channel", "channel", channel, "err", err)
+	}
+	if err := dm.bus.Deregister(ctx, dm.nodeId, channel); err != nil {
+		slog.Error("error removing socket from registry", "channel", channel, "err", err)
+	}
+}
+
+func (dm *DistributedSocketManager) publish(prefix string, message string, ids []primitive.ObjectID) {
+	if len(ids) == 0 {
+		return
+	}
+	ctx := context.Background()
+
+	channels := make([]string, 0, len(ids))
+	for _, id := range ids {
+		channels = append(channels, prefix+id.Hex())
+	}
+	nodes, err := dm.bus.Nodes(ctx, channels...)
+	if err != nil {
+		slog.Error("error reading socket registry", "err", err)
+		return
+	}
+
+	var messages []BusMessage
+	for i, channel := range channels {
+		if len(nodes[i]) == 0 {
+			// not connected to any node
+			continue
+		}
+		messages = append(messages, BusMessage{Channel: channel, Payload: message})
+	}
+	if len(messages) == 0 {
+		return
+	}
+	if err := dm.bus.Publish(ctx, messages...); err != nil {
+		slog.Error("error publishing socket message", "err", err)
+	}
+}
+
+// listen writes messages published on the subscribed channels to the local sockets
+func (dm *DistributedSocketManager) listen() {
+	for msg := range dm.bus.Messages() {
+		switch {
+		case msg.Channel == evictChannel:
+			dm.evict(msg.Payload)
+		case strings.HasPrefix(msg.Channel, riderChannelPrefix):
+			id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(msg.Channel, riderChannelPrefix))
+			if err != nil {
+				continue
+			}
+			dm.local.BroadcastToRiders(msg.Payload, []primitive.ObjectID{id})
+		case strings.HasPrefix(msg.Channel, userChannelPrefix):
+			id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(msg.Channel, userChannelPrefix))
+			if err != nil {
+				continue
+			}
+			dm.local.BroadcastToUsers(msg.Payload, []primitive.ObjectID{id})
+		case strings.HasPrefix(msg.Channel, restaurantChannelPrefix):
+			id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(msg.Channel, restaurantChannelPrefix))
+			if err != nil {
+				continue
+			}
+			dm.local.BroadcastToRestaurants(msg.Payload, []primitive.ObjectID{id})
+		case strings.HasPrefix(msg.Channel, orderChannelPrefix):
+			id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(msg.Channel, orderChannelPrefix))
+			if err != nil {
+				continue
+			}
+			dm.local.BroadcastToOrderStreams(msg.Payload, []primitive.ObjectID{id})
+		}
+	}
+}
+
+// evict closes the local connections of a socket another node took over. An evict delivered after this node took
+// the socket back is stale, the evicting node is no longer in the registry.
+func (dm *DistributedSocketManager) evict(payload string) {
+	var evict evictMessage
+	if err := json.Unmarshal([]byte(payload), &evict); err != nil || evict.Node == dm.nodeId {
+		return
+	}
+	dm.lock.Lock()
+	defer dm.lock.Unlock()
+	nodes, err := dm.bus.Nodes(context.Background(), evict.Channel)
+	if err != nil {
+		slog.Error("error reading socket registry", "err", err)
+		return
+	}
+	if !slices.Contains(nodes[0], evict.Node) {
+		return
+	}
+	switch {
+	case strings.HasPrefix(evict.Channel, riderChannelPrefix):
+		dm.local.closeAll(dm.local.riderClients, strings.TrimPrefix(evict.Channel, riderChannelPrefix))
+	case strings.HasPrefix(evict.Channel, userChannelPrefix):
+		dm.local.closeAll(dm.local.userClients, strings.TrimPrefix(evict.Channel, userChannelPrefix))
+	case strings.HasPrefix(evict.Channel, restaurantChannelPrefix):
+		dm.local.closeAll(dm.local.restaurantClients, strings.TrimPrefix(evict.Channel, restaurantChannelPrefix))
+	}
+}
+
+// refreshRegistry keeps the registry entries of the local sockets alive
+func (dm *DistributedSocketManager) refreshRegistry() {
+	ticker := time.NewTicker(registryRefresh)
+	defer ticker.Stop()
+	for range ticker.C {
+		dm.local.lock.Lock()
+		var channels []string
+		for id := range dm.local.riderClients {
+			channels = append(channels, riderChannelPrefix+id)
+		}
+		for id := range dm.local.userClients {
+			channels = append(channels, userChannelPrefix+id)
+		}
+		for id := range dm.local.restaurantClients {
+			channels = append(channels, restaurantChannelPrefix+id)
+		}
+		for id := range dm.local.orderClients {
+			channels = append(channels, orderChannelPrefix+id)
+		}
+		dm.local.lock.Unlock()
+
+		if len(channels) == 0 {
+			continue
+		}
+		if err := dm.bus.Register(context.Background(), dm.nodeId, registryTTL, channels...); err != nil {
+			slog.Error("error refreshing socket registry", "err", err)
+		}
+	}
+}
+
+// RedisSocketBus SocketBus on redis pub/sub. The registry keeps a sorted set per channel with the nodes holding it,
+// scored by the time the entry of the node expires.
+type RedisSocketBus struct {
+	client   *redis.Client
+	pubSub   *redis.PubSub
+	messages chan BusMessage
+}
+
+func NewRedisSocketBus(client *redis.Client) *RedisSocketBus {
+	bus := &RedisSocketBus{
+		client:   client,
+		pubSub:   client.Subscribe(context.Background()),
+		messages: make(chan BusMessage),
+	}
+	go func() {
+		for msg := range bus.pubSub.Channel() {
+			bus.messages <- BusMessage{Channel: msg.Channel, Payload: msg.Payload}
+		}
+		close(bus.messages)
+	}()
+	return bus
+}
+
+func registryKey(channel string) string {
+	return "ws_registry:" + channel
+}
+
+func (r *RedisSocketBus) Subscribe(ctx context.Context, channel string) error {
+	return r.pubSub.Subscribe(ctx, channel)
+}
+
+func (r *RedisSocketBus) Unsubscribe(ctx context.Context, channel string) error {
+	return r.pubSub.Unsubscribe(ctx, channel)
+}
+
+func (r *RedisSocketBus) Messages() <-chan BusMessage {
+	return r.messages
+}
+
+func (r *RedisSocketBus) Publish(ctx context.Context, messages ...BusMessage) error {
+	pipe := r.client.Pipeline()
+	for _, message := range messages {
+		pipe.Publish(ctx, message.Channel, message.Payload)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisSocketBus) Register(ctx context.Context, node string, ttl time.Duration, channels ...string) error {
+	now := time.Now()
+	pipe := r.client.Pipeline()
+	for _, channel := range channels {
+		key := registryKey(channel)
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(ttl).UnixMilli()), Member: node})
+		pipe.Expire(ctx, key, ttl)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisSocketBus) Take(ctx context.Context, node string, ttl time.Duration, channel string) error {
+	key := registryKey(channel)
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().Add(ttl).UnixMilli()), Member: node})
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisSocketBus) Deregister(ctx context.Context, node string, channel string) error {
+	return r.client.ZRem(ctx, registryKey(channel), node).Err()
+}
+
+func (r *RedisSocketBus) Nodes(ctx context.Context, channels ...string) ([][]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, 0, len(channels))
+	for _, channel := range channels {
+		cmds = append(cmds, pipe.ZRangeByScore(ctx, registryKey(channel), &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	nodes := make([][]string, len(cmds))
+	for i, cmd := range cmds {
+		nodes[i] = cmd.Val()
+	}
+	return nodes, nil
+}
@@ -0,0 +1,178 @@
+package model_test
+
+import (
+	"context"
+	"food-eats/cmd/web/model"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryHub pub/sub and registry shared by the buses of the nodes in a test
+type memoryHub struct {
+	lock     sync.Mutex
+	buses    []*memoryBus
+	registry map[string][]string
+}
+
+func newMemoryHub() *memoryHub {
+	return &memoryHub{registry: map[string][]string{}}
+}
+
+func (h *memoryHub) bus() *memoryBus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	bus := &memoryBus{hub: h, channels: map[string]bool{}, messages: make(chan model.BusMessage, 64)}
+	h.buses = append(h.buses, bus)
+	return bus
+}
+
+// memoryBus SocketBus of a single node
+type memoryBus struct {
+	hub      *memoryHub
+	channels map[string]bool
+	messages chan model.BusMessage
+}
+
+func (b *memoryBus) Subscribe(_ context.Context, channel string) error {
+	b.hub.lock.Lock()
+	defer b.hub.lock.Unlock()
+	b.channels[channel] = true
+	return nil
+}
+
+func (b *memoryBus) Unsubscribe(_ context.Context, channel string) error {
+	b.hub.lock.Lock()
+	defer b.hub.lock.Unlock()
+	delete(b.channels, channel)
+	return nil
+}
+
+func (b *memoryBus) Messages() <-chan model.BusMessage {
+	return b.messages
+}
+
+func (b *memoryBus) Publish(_ context.Context, messages ...model.BusMessage) error {
+	b.hub.lock.Lock()
+	defer b.hub.lock.Unlock()
+	for _, message := range messages {
+		for _, bus := range b.hub.buses {
+			if bus.channels[message.Channel] {
+				bus.messages <- message
+			}
+		}
+	}
+	return nil
+}
+
+func (b *memoryBus) Register(_ context.Context, node string, _ time.Duration, channels ...string) error {
+	b.hub.lock.Lock()
+	defer b.hub.lock.Unlock()
+	for _, channel := range channels {
+		if !slices.Contains(b.hub.registry[channel], node) {
+			b.hub.registry[channel] = append(b.hub.registry[channel], node)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBus) Take(_ context.Context, node string, _ time.Duration, channel string) error {
+	b.hub.lock.Lock()
+	defer b.hub.lock.Unlock()
+	b.hub.registry[channel] = []string{node}
+	return nil
+}
+
+func (b *memoryBus) Deregister(_ context.Context, node string, channel string) error {
+	b.hub.lock.Lock()
+	defer b.hub.lock.Unlock()
+	b.hub.registry[channel] = slices.DeleteFunc(b.hub.registry[channel], func(n string) bool { return n == node })
+	return nil
+}
+
+func (b *memoryBus) Nodes(_ context.Context, channels ...string) ([][]string, error) {
+	b.hub.lock.Lock()
+	defer b.hub.lock.Unlock()
+	nodes := make([][]string, len(channels))
+	for i, channel := range channels {
+		nodes[i] = slices.Clone(b.hub.registry[channel])
+	}
+	return nodes, nil
+}
+
+func TestDistributedSocketManager_RegisterRiderEvictsOtherNodes(t *testing.T) {
+	hub := newMemoryHub()
+	nodeA := model.NewDistributedSocketManager(model.NewWebSocketManager(nil), hub.bus(), "a")
+	nodeB := model.NewDistributedSocketManager(model.NewWebSocketManager(nil), hub.bus(), "b")
+	oldConn, oldClient := newTestSocket(t)
+	newConn, newClient := newTestSocket(t)
+
+	riderId := primitive.NewObjectID()
+	nodeA.RegisterRider(riderId, oldClient)
+	nodeB.RegisterRider(riderId, newClient)
+
+	// the connection on the other node is closed by the server
+	_ = oldConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := oldConn.ReadMessage()
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool { return nodeA.Stats().RiderConnections == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(1), nodeA.Stats().Replaced)
+	assert.Equal(t, 1, nodeB.Stats().RiderConnections)
+
+	// a broadcast from any node reaches the new connection
+	nodeA.BroadcastToRiders("New order for pickup", []primitive.ObjectID{riderId})
+	_ = newConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := newConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "New order for pickup", string(msg))
+}
+
+func TestDistributedSocketManager_RegisterUserKeepsOtherNodes(t *testing.T) {
+	hub := newMemoryHub()
+	nodeA := model.NewDistributedSocketManager(model.NewWebSocketManager(nil), hub.bus(), "a")
+	nodeB := model.NewDistributedSocketManager(model.NewWebSocketManager(nil), hub.bus(), "b")
+	phoneConn, phoneClient := newTestSocket(t)
+	_, laptopClient := newTestSocket(t)
+
+	userId := primitive.NewObjectID()
+	nodeA.RegisterUser(userId, phoneClient)
+	nodeB.RegisterUser(userId, laptopClient)
+
+	// users keep a socket per device, nothing is evicted
+	nodeB.BroadcastToUsers("Order picked up", []primitive.ObjectID{userId})
+	_ = phoneConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := phoneConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "Order picked up", string(msg))
+	assert.Equal(t, 1, nodeA.Stats().UserConnections)
+	assert.Equal(t, int64(0), nodeA.Stats().Replaced)
+}
+
+func TestDistributedSocketManager_UnregisterUserKeepsOtherNodes(t *testing.T) {
+	hub := newMemoryHub()
+	nodeA := model.NewDistributedSocketManager(model.NewWebSocketManager(nil), hub.bus(), "a")
+	nodeB := model.NewDistributedSocketManager(model.NewWebSocketManager(nil), hub.bus(), "b")
+	phoneConn, phoneClient := newTestSocket(t)
+	_, laptopClient := newTestSocket(t)
+
+	userId := primitive.NewObjectID()
+	nodeA.RegisterUser(userId, phoneClient)
+	nodeB.RegisterUser(userId, laptopClient)
+
+	// the laptop disconnects from the node which registered last, the phone on the other node still gets messages
+	nodeB.UnregisterUser(userId, laptopClient)
+	assert.Equal(t, []string{"a"}, hub.registry["ws:user:"+userId.Hex()])
+
+	nodeB.BroadcastToUsers("Order picked up", []primitive.ObjectID{userId})
+	_ = phoneConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := phoneConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "Order picked up", string(msg))
+
+	nodeA.UnregisterUser(userId, phoneClient)
+	assert.Empty(t, hub.registry["ws:user:"+userId.Hex()])
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"errors"
+	errors2 "food-eats/cmd/web/custom-errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// duplicateKeyError turns a violated unique index into a conflict with a message for the client
+func duplicateKeyError(err error, message string) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.Join(errors2.ConflictError, errors.New(message))
+	}
+	return err
+}
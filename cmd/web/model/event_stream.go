@@ -0,0 +1,63 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// NewEventStreamClient creates a client writing messages as server-sent events and starts its write pump.
+// Event streams are registered with the WebSocketManager like sockets, so both transports get the same messages.
+// The handler serving the stream must not return before Wait, the response is written by the write pump.
+func NewEventStreamClient(w http.ResponseWriter) (*WebSocketClient, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming not supported")
+	}
+
+	rc := http.NewResponseController(w)
+	client := newClient(
+		func(message []byte) error {
+			_ = rc.SetWriteDeadline(time.Now().Add(writeWait))
+			// the sequence and type of the message are used as event id and name, so browsers resume
+			// with the Last-Event-ID header
+			var event struct {
+				Type string `json:"type"`
+				Seq  string `json:"seq"`
+			}
+			_ = json.Unmarshal(message, &event)
+			if event.Seq != "" {
+				if _, err := fmt.Fprintf(w, "id: %s\n", event.Seq); err != nil {
+					return err
+				}
+			}
+			if event.Type != "" {
+				if _, err := fmt.Fprintf(w, "event: %s\n", event.Type); err != nil {
+					return err
+				}
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", message); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		},
+		func() error {
+			_ = rc.SetWriteDeadline(time.Now().Add(writeWait))
+			// comments keep proxies from closing an idle stream
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		},
+		func() error {
+			// the connection may be reused once the stream ends
+			return rc.SetWriteDeadline(time.Time{})
+		},
+	)
+	go client.writePump()
+	return client, nil
+}
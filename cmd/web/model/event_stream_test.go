@@ -0,0 +1,55 @@
+package model_test
+
+import (
+	"bufio"
+	"context"
+	"food-eats/cmd/web/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEventStreamClient(t *testing.T) {
+	message := `{"v":1,"type":"order_status","seq":"1711792800000-0"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, err := model.NewEventStreamClient(w)
+		if err != nil {
+			t.Errorf("event stream failed: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		client.Send([]byte(message))
+
+		<-r.Context().Done()
+		client.Close()
+		client.Wait()
+	}))
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		assert.NoError(t, err)
+		if line == "\n" {
+			break
+		}
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{
+		"id: 1711792800000-0\n",
+		"event: order_status\n",
+		"data: " + message + "\n",
+	}, lines)
+}
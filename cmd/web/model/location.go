@@ -1,6 +1,9 @@
 package model
 
-import "strconv"
+import (
+	"math"
+	"strconv"
+)
 
 // Location type for saving location in mongo db
 type Location struct {
@@ -43,3 +46,29 @@ func (l Location) GetLongitude() float64 {
 	}
 	return 0
 }
+
+// EncodePolyline encodes locations using the google encoded polyline algorithm with 5 decimal precision
+func EncodePolyline(locations []Location) string {
+	var encoded []byte
+	previousLat, previousLong := int64(0), int64(0)
+	for _, location := range locations {
+		lat := int64(math.Round(location.GetLatitude() * 1e5))
+		long := int64(math.Round(location.GetLongitude() * 1e5))
+		encoded = appendPolylineValue(encoded, lat-previousLat)
+		encoded = appendPolylineValue(encoded, long-previousLong)
+		previousLat, previousLong = lat, long
+	}
+	return string(encoded)
+}
+
+func appendPolylineValue(encoded []byte, value int64) []byte {
+	shifted := value << 1
+	if value < 0 {
+		shifted = ^shifted
+	}
+	for shifted >= 0x20 {
+		encoded = append(encoded, byte((0x20|(shifted&0x1f))+63))
+		shifted >>= 5
+	}
+	return append(encoded, byte(shifted+63))
+}
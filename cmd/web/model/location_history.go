@@ -0,0 +1,98 @@
+package model
+
+import (
+	"context"
+	"errors"
+	errors2 "food-eats/cmd/web/custom-errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// location points are removed by mongo after this time
+const locationHistoryTTL = 30 * 24 * time.Hour
+
+// LocationPoint rider location recorded while the rider is on a trip
+type LocationPoint struct {
+	Id         primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	RiderId    primitive.ObjectID `json:"rider_id" bson:"riderId"`
+	TripId     primitive.ObjectID `json:"trip_id" bson:"tripId"` // index with recordedAt
+	Location   Location           `json:"location" bson:"location"`
+	RecordedAt time.Time          `json:"recorded_at" bson:"recordedAt"` // ttl index
+}
+
+type SearchLocationQuery struct {
+	TripId primitive.ObjectID
+	From   time.Time
+	To     time.Time
+}
+
+// LocationHistoryRepository will be the location history repository, a database needs to implement this contract
+type LocationHistoryRepository interface {
+	AddLocationPoint(ctx context.Context, point LocationPoint) error
+	SearchLocationPoints(ctx context.Context, query SearchLocationQuery) ([]LocationPoint, error)
+}
+
+// LocationHistoryMongo type with embedded mongo.Database
+type LocationHistoryMongo struct {
+	DB *mongo.Database
+}
+
+func LocationHistoryMongoRepo(DB *mongo.Database) LocationHistoryMongo {
+	return LocationHistoryMongo{DB: DB}
+}
+
+// CreateLocationHistoryIndexes location points are queried by trip and expire after locationHistoryTTL
+func CreateLocationHistoryIndexes(ctx context.Context, DB *mongo.Database) error {
+	_, err := DB.Collection("LocationHistory").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "tripId", Value: 1}, {Key: "recordedAt", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "recordedAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(locationHistoryTTL.Seconds())),
+		},
+	})
+	return err
+}
+
+func (u LocationHistoryMongo) AddLocationPoint(ctx context.Context, point LocationPoint) error {
+	insertedId, err := u.DB.Collection("LocationHistory").InsertOne(ctx, point)
+	if err != nil {
+		return err
+	}
+	if insertedId == nil {
+		return errors.Join(errors2.ServerError, errors.New("empty inserted id"))
+	}
+	return nil
+}
+
+// SearchLocationPoints location points of a trip in the order they were recorded
+func (u LocationHistoryMongo) SearchLocationPoints(ctx context.Context, query SearchLocationQuery) ([]LocationPoint, error) {
+	filter := bson.M{"tripId": query.TripId}
+	recordedAt := bson.M{}
+	if !query.From.IsZero() {
+		recordedAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		recordedAt["$lte"] = query.To
+	}
+	if len(recordedAt) > 0 {
+		filter["recordedAt"] = recordedAt
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"recordedAt": 1})
+	cursor, err := u.DB.Collection("LocationHistory").Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	points := []LocationPoint{}
+	if err := cursor.All(ctx, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
@@ -0,0 +1,19 @@
+package model_test
+
+import (
+	"food-eats/cmd/web/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodePolyline(t *testing.T) {
+	// example from the google encoded polyline algorithm format documentation
+	locations := []model.Location{
+		model.NewLocationFromLongLat(-120.2, 38.5),
+		model.NewLocationFromLongLat(-120.95, 40.7),
+		model.NewLocationFromLongLat(-126.453, 43.252),
+	}
+	assert.Equal(t, "_p~iF~ps|U_ulLnnqC_mqNvxq`@", model.EncodePolyline(locations))
+	assert.Equal(t, "", model.EncodePolyline(nil))
+}
@@ -17,6 +17,7 @@ type Order struct {
 	UserId       primitive.ObjectID `json:"user_id" bson:"userId"`                       // index
 	RiderId      primitive.ObjectID `json:"rider_id,omitempty" bson:"riderId,omitempty"` // index
 	RestaurantId primitive.ObjectID `json:"restaurant_id" bson:"restaurantId"`           // index
+	TripId       primitive.ObjectID `json:"trip_id,omitempty" bson:"tripId,omitempty"`   // set once a rider picks the order
 
 	// Delivery Information
 	DeliveryPhoneNumber string  `json:"delivery_phone_number" bson:"deliveryPhoneNumber"`
@@ -37,7 +38,15 @@ type Order struct {
 	Latitude  float64 `json:"latitude" bson:"latitude"`
 	Longitude float64 `json:"longitude" bson:"longitude"`
 
-	DeliveryTime float64 `json:"delivery_time" bson:"deliveryTime"` // in seconds
+	DeliveryTime  float64 `json:"delivery_time" bson:"deliveryTime"`    // in seconds
+	PrepTime      float64 `json:"prep_time" bson:"prepTime"`            // in seconds, from accepted to food ready
+	RiderWaitTime float64 `json:"rider_wait_time" bson:"riderWaitTime"` // in seconds, from rider arrival to pickup
+
+	// Proof of delivery, otp is only shared with the user
+	DeliveryOtp         string `json:"-" bson:"deliveryOtp"`
+	DeliveryOtpAttempts int    `json:"delivery_otp_attempts" bson:"deliveryOtpAttempts"`
+	DeliveryProof       string `json:"delivery_proof,omitempty" bson:"deliveryProof,omitempty"` // OTP or PHOTO
+	DeliveryPhotoUrl    string `json:"delivery_photo_url,omitempty" bson:"deliveryPhotoUrl,omitempty"`
 
 	CreatedAt       time.Time `json:"created_at" bson:"createdAt"`
 	AcceptedAt      time.Time `json:"accepted_at,omitempty" bson:"acceptedAt,omitempty"`
@@ -45,13 +54,35 @@ type Order struct {
 	DeliveryStarted time.Time `json:"delivery_started,omitempty" bson:"deliveryStarted,omitempty"`
 	DeliveredAt     time.Time `json:"delivered_at,omitempty" bson:"deliveredAt,omitempty"`
 
+	// Pickup timestamps, used to measure wait time at the restaurant
+	EstimatedReadyAt time.Time `json:"estimated_ready_at,omitempty" bson:"estimatedReadyAt,omitempty"`
+	FoodReadyAt      time.Time `json:"food_ready_at,omitempty" bson:"foodReadyAt,omitempty"`
+	RiderArrivedAt   time.Time `json:"rider_arrived_at,omitempty" bson:"riderArrivedAt,omitempty"`
+	PickedUpAt       time.Time `json:"picked_up_at,omitempty" bson:"pickedUpAt,omitempty"`
+
+	// Cancellation by support or by the user, the order can not be picked by a rider afterwards
+	CancelledAt  time.Time          `json:"cancelled_at,omitempty" bson:"cancelledAt,omitempty"`
+	CancelledBy  primitive.ObjectID `json:"cancelled_by,omitempty" bson:"cancelledBy,omitempty"`
+	CancelReason string             `json:"cancel_reason,omitempty" bson:"cancelReason,omitempty"`
+
+	// CREATED, ACCEPTED, RIDER_ASSIGNED, FOOD_READY, RIDER_ARRIVED, PICKED_UP, DELIVERED, CANCELLED
+	// holds the latest event, food ready and rider assignment can happen in any order
 	Status string `json:"status" bson:"status"`
 }
 
+// IsPickedUp rider has collected the order from the restaurant
+func (o Order) IsPickedUp() bool {
+	return !o.PickedUpAt.IsZero()
+}
+
 // OrderRepository will be the order repository, a database needs to implement this contract
 type OrderRepository interface {
 	CreateOrder(ctx context.Context, order Order) (Order, error)
 	UpdateOrder(ctx context.Context, order Order) error
+	// UpdateOrderFrom saves the order only while the stored order is still in the status from, ConflictError otherwise
+	UpdateOrderFrom(ctx context.Context, order Order, from string) error
+	// UnassignOrder saves the order with its rider assignment removed, the rider, trip, arrival and pickup are cleared
+	UnassignOrder(ctx context.Context, order Order) error
 	GetOrder(ctx context.Context, id primitive.ObjectID) (Order, error)
 	SearchOrder(ctx context.Context, query SearchOrderQuery) ([]Order, int64, error)
 }
@@ -85,22 +116,51 @@ func (u OrderMongo) CreateOrder(ctx context.Context, order Order) (Order, error)
 	}
 	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
 	order.Id = id
+	auditCreated(ctx, u.DB, "Order", id, order)
 	return order, nil
 }
 
 func (u OrderMongo) UpdateOrder(ctx context.Context, order Order) error {
-	updateResult, err := u.DB.Collection("Order").UpdateByID(ctx, order.Id, bson.M{"$set": order}) // ignoring the parameter as we don't require it
+	modified, err := auditedUpdate(ctx, u.DB, "Order", AuditUpdate, order.Id, order)
 	if err != nil {
 		return err
 	}
+	if !modified {
+		return errors.Join(errors2.ServerError, errors.New("update failed"))
+	}
+
+	return nil
+}
 
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
+func (u OrderMongo) UpdateOrderFrom(ctx context.Context, order Order, from string) error {
+	modified, err := auditedUpdateWhere(ctx, u.DB, "Order", AuditUpdate, order.Id, bson.M{"status": from}, order)
+	if errors.Is(err, errors2.NotFoundError) {
+		return errors.Join(errors2.ConflictError, errors.New("order is no longer "+from))
 	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
+	if err != nil {
+		return err
+	}
+	if !modified {
+		return errors.Join(errors2.ServerError, errors.New("update failed"))
+	}
+	return nil
+}
+
+// riderAssignmentFields fields of the order set while a rider holds it, left out of the saved order once empty
+var riderAssignmentFields = []string{"riderId", "tripId", "deliveryStarted", "riderArrivedAt", "pickedUpAt"}
+
+func (u OrderMongo) UnassignOrder(ctx context.Context, order Order) error {
+	// empty fields are left out of the $set, they would otherwise conflict with the $unset
+	order.RiderId = primitive.NilObjectID
+	order.TripId = primitive.NilObjectID
+	order.DeliveryStarted = time.Time{}
+	order.RiderArrivedAt = time.Time{}
+	order.PickedUpAt = time.Time{}
+	modified, err := auditedUpdateWhere(ctx, u.DB, "Order", AuditUpdate, order.Id, bson.M{}, order, riderAssignmentFields...)
+	if err != nil {
+		return err
 	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 	return nil
@@ -111,7 +171,7 @@ func (u OrderMongo) GetOrder(ctx context.Context, id primitive.ObjectID) (Order,
 	err := u.DB.Collection("Order").FindOne(ctx, bson.M{"_id": id}).Decode(&order)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return order, errors.Join(errors2.ClientError, errors.New("no such entry"))
+			return order, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
 		}
 		return order, errors.Join(errors2.ServerError, err)
 	}
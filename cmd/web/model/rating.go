@@ -48,13 +48,14 @@ type RatingMongoDb struct {
 func (u RatingMongoDb) CreateRating(ctx context.Context, rating Rating) (Rating, error) {
 	insertedId, err := u.DB.Collection("Rating").InsertOne(ctx, rating)
 	if err != nil {
-		return Rating{}, err
+		return Rating{}, duplicateKeyError(err, "rating already given")
 	}
 	if insertedId == nil {
 		return Rating{}, errors.Join(errors2.ServerError, errors.New("empty inserted id"))
 	}
 	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
 	rating.Id = id
+	auditCreated(ctx, u.DB, "Rating", id, rating)
 	return rating, nil
 }
 
@@ -63,7 +64,7 @@ func (u RatingMongoDb) GetRating(ctx context.Context, id primitive.ObjectID) (Ra
 	err := u.DB.Collection("Rating").FindOne(ctx, bson.M{"_id": id}).Decode(&rating)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return rating, errors.Join(errors2.ClientError, errors.New("no such entry"))
+			return rating, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
 		}
 		return rating, errors.Join(errors2.ServerError, err)
 	}
@@ -81,7 +82,7 @@ func (u RatingMongoDb) SearchRating(ctx context.Context, query SearchRatingBuild
 	cur, err := u.DB.Collection("Rating").Find(ctx, filter)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return []Rating{}, errors.Join(errors2.ClientError, errors.New("no such entry"))
+			return []Rating{}, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
 		}
 		return []Rating{}, errors.Join(errors2.ServerError, err)
 	}
@@ -12,15 +12,24 @@ import (
 
 // Item Individual food item
 type Item struct {
-	Name        string  `json:"name" bson:"name"`
+	Id          string  `json:"id" bson:"id" validate:"required,menu_item_id"` // unique on the menu
+	Name        string  `json:"name" bson:"name" validate:"required"`
 	Description string  `json:"description" bson:"description"`
-	Price       float32 `json:"price" bson:"price"`
+	Price       float32 `json:"price" bson:"price" validate:"gt=0"`
 	ItemType    string  `json:"item_type" bson:"itemType"`
 }
 
 // Menu of a food delivery app
 type Menu struct {
-	Items []Item `bson:"items" json:"items"`
+	Items []Item `bson:"items" json:"items" validate:"unique=Id,dive"`
+}
+
+// PayoutDetails bank account the restaurant is paid out to
+type PayoutDetails struct {
+	AccountHolder string    `json:"account_holder" bson:"accountHolder"`
+	AccountNumber string    `json:"account_number" bson:"accountNumber"`
+	Ifsc          string    `json:"ifsc" bson:"ifsc"`
+	UpdatedAt     time.Time `json:"updated_at" bson:"updatedAt"`
 }
 
 // Restaurant hold information for Restaurant
@@ -29,12 +38,14 @@ type Restaurant struct {
 	Name        string             `json:"name" bson:"name"`
 	EmailId     string             `json:"email_id" bson:"emailId"`
 	PhoneNumber string             `json:"phone_number" bson:"phoneNumber"`
+	Verified    bool               `json:"verified" bson:"verified"` // phone number proven with an otp
 	Website     string             `json:"website" bson:"website,omitempty"`
 	CreatedAt   time.Time          `json:"created_At" bson:"createdAt"`
 	UpdatedAt   time.Time          `bson:"updated_at" bson:"UpdatedAt"`
 
 	// Default address fields
 	Address       string   `json:"address" bson:"address"`
+	Pincode       string   `json:"pincode,omitempty" bson:"pincode,omitempty"`
 	Location      Location `json:"location" bson:"location"`
 	AverageRating float64  `json:"average_rating" bson:"averageRating"`
 	AverageTime   float64  `json:"-" bson:"averageTime"`
@@ -44,6 +55,8 @@ type Restaurant struct {
 	MealType string `json:"meal_type" bson:"mealType"`
 
 	Menu Menu `json:"menu" bson:"menu"`
+
+	Payout PayoutDetails `json:"-" bson:"payout"` // only shown to the owner
 }
 
 // RestaurantSearchResponse response used to serve to users on search
@@ -82,6 +95,8 @@ type RestaurantRepository interface {
 	CreateRestaurant(ctx context.Context, restaurant Restaurant) (Restaurant, error)
 	UpdateRestaurant(ctx context.Context, restaurant Restaurant) error
 	GetRestaurant(ctx context.Context, id primitive.ObjectID) (Restaurant, error)
+	GetRestaurantByPhone(ctx context.Context, phoneNumber string) (Restaurant, error)
+	SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error
 	DeleteRestaurant(ctx context.Context, id primitive.ObjectID) error
 	SearchRestaurant(ctx context.Context, query SearchRestaurantQuery) ([]RestaurantSearchResponse, int64, error)
 	UpdateAverageRating(ctx context.Context, id primitive.ObjectID, rating float64) error
@@ -102,30 +117,24 @@ func RestaurantMongoRepo(db *mongo.Database) *RestaurantMongo {
 func (u RestaurantMongo) CreateRestaurant(ctx context.Context, restaurant Restaurant) (Restaurant, error) {
 	insertedId, err := u.DB.Collection("Restaurant").InsertOne(ctx, restaurant) // ignoring the parameter as we don't require it
 	if err != nil {
-		return Restaurant{}, err
+		return Restaurant{}, duplicateKeyError(err, "restaurant already exists")
 	}
 	if insertedId == nil {
 		return Restaurant{}, errors.Join(errors2.ServerError, errors.New("empty inserted id"))
 	}
 	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
 	restaurant.Id = id
+	auditCreated(ctx, u.DB, "Restaurant", id, restaurant)
 	return restaurant, nil
 }
 
 func (u RestaurantMongo) UpdateRestaurant(ctx context.Context, restaurant Restaurant) error {
 	// todo instead of whole object set, we can use individual fields set
-	updateResult, err := u.DB.Collection("Restaurant").UpdateOne(ctx, bson.M{"_id": restaurant.Id}, bson.M{"$set": restaurant})
+	modified, err := auditedUpdate(ctx, u.DB, "Restaurant", AuditUpdate, restaurant.Id, restaurant)
 	if err != nil {
 		return err
 	}
-
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
-	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
@@ -137,7 +146,21 @@ func (u RestaurantMongo) GetRestaurant(ctx context.Context, id primitive.ObjectI
 	err := u.DB.Collection("Restaurant").FindOne(ctx, bson.M{"_id": id}).Decode(&restaurant)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return restaurant, errors.Join(errors2.ClientError, errors.New("no such entry"))
+			return restaurant, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return restaurant, errors.Join(errors2.ServerError, err)
+	}
+	return restaurant, nil
+}
+
+// GetRestaurantByPhone restaurant registered with the phone number which is not deleted, used on login
+func (u RestaurantMongo) GetRestaurantByPhone(ctx context.Context, phoneNumber string) (Restaurant, error) {
+	var restaurant Restaurant
+	filter := bson.M{"phoneNumber": phoneNumber, "status": bson.M{"$ne": "DELETED"}}
+	err := u.DB.Collection("Restaurant").FindOne(ctx, filter).Decode(&restaurant)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return restaurant, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
 		}
 		return restaurant, errors.Join(errors2.ServerError, err)
 	}
@@ -146,17 +169,11 @@ func (u RestaurantMongo) GetRestaurant(ctx context.Context, id primitive.ObjectI
 
 // DeleteRestaurant if we want to delete a restaurant, for now we are marking it as deleted, to delete as a whole we need to delete all records for that person
 func (u RestaurantMongo) DeleteRestaurant(ctx context.Context, id primitive.ObjectID) error {
-	updateResult, err := u.DB.Collection("Restaurant").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": "DELETED", "updatedAt": time.Now()}})
+	modified, err := auditedUpdate(ctx, u.DB, "Restaurant", AuditDelete, id, bson.M{"status": "DELETED", "updatedAt": time.Now()})
 	if err != nil {
-		return nil
-	}
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
+		return err
 	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
@@ -256,18 +273,11 @@ func (u RestaurantMongo) SearchRestaurant(ctx context.Context, query SearchResta
 }
 
 func (u RestaurantMongo) UpdateAverageRating(ctx context.Context, id primitive.ObjectID, rating float64) error {
-	updateResult, err := u.DB.Collection("Restaurant").UpdateByID(ctx, id, bson.M{"$set": bson.M{"averageRating": rating}})
+	modified, err := auditedUpdate(ctx, u.DB, "Restaurant", AuditUpdate, id, bson.M{"averageRating": rating})
 	if err != nil {
 		return err
 	}
-
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
-	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
@@ -275,20 +285,19 @@ func (u RestaurantMongo) UpdateAverageRating(ctx context.Context, id primitive.O
 }
 
 func (u RestaurantMongo) UpdateAverageDeliveryTime(ctx context.Context, id primitive.ObjectID, deliveryTime float64) error {
-	updateResult, err := u.DB.Collection("Restaurant").UpdateByID(ctx, id, bson.M{"$set": bson.M{"averageDeliveryTime": deliveryTime}})
+	modified, err := auditedUpdate(ctx, u.DB, "Restaurant", AuditUpdate, id, bson.M{"averageDeliveryTime": deliveryTime})
 	if err != nil {
 		return err
 	}
-
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
-	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
 	return nil
 }
+
+// SetPhoneVerified marks the phone number of the restaurant as verified by an otp
+func (u RestaurantMongo) SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error {
+	_, err := auditedUpdate(ctx, u.DB, "Restaurant", AuditUpdate, id, bson.M{"verified": true, "updatedAt": time.Now()})
+	return err
+}
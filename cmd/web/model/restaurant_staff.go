@@ -0,0 +1,136 @@
+package model
+
+import (
+	"context"
+	"errors"
+	errors2 "food-eats/cmd/web/custom-errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// RestaurantStaff login of a person working at a restaurant, a phone number can work at one restaurant only
+type RestaurantStaff struct {
+	Id           primitive.ObjectID `json:"id" bson:"_id,omitempty"`           // omitempty so a mongo-driver can generate unique id
+	RestaurantId primitive.ObjectID `json:"restaurant_id" bson:"restaurantId"` // index
+	Name         string             `json:"name" bson:"name"`
+	PhoneNumber  string             `json:"phone_number" bson:"phoneNumber"`
+	Verified     bool               `json:"verified" bson:"verified"`
+	Role         string             `json:"role" bson:"role"` // RESTAURANT_OWNER, RESTAURANT_MANAGER or RESTAURANT_KITCHEN
+
+	InvitedBy       primitive.ObjectID `json:"invited_by" bson:"invitedBy"`
+	InviteExpiresAt time.Time          `json:"invite_expires_at" bson:"inviteExpiresAt"`
+	AcceptedAt      time.Time          `json:"accepted_at,omitempty" bson:"acceptedAt,omitempty"`
+	CreatedAt       time.Time          `json:"created_at" bson:"createdAt"`
+	UpdatedAt       time.Time          `json:"updated_at" bson:"updatedAt"`
+
+	Status string `json:"status" bson:"status"` // INVITED until the first login, ACTIVE or DELETED
+}
+
+// RestaurantStaffRepository will be the staff repository, a database needs to implement this contract
+type RestaurantStaffRepository interface {
+	CreateStaff(ctx context.Context, staff RestaurantStaff) (RestaurantStaff, error)
+	UpdateStaff(ctx context.Context, staff RestaurantStaff) error
+	GetStaff(ctx context.Context, id primitive.ObjectID) (RestaurantStaff, error)
+	GetStaffByPhone(ctx context.Context, phoneNumber string) (RestaurantStaff, error)
+	GetRestaurantStaff(ctx context.Context, restaurantId primitive.ObjectID) ([]RestaurantStaff, error)
+	SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error
+}
+
+func RestaurantStaffMongoRepo(DB *mongo.Database) RestaurantStaffMongoDb {
+	return RestaurantStaffMongoDb{DB: DB}
+}
+
+// RestaurantStaffMongoDb type with embedded mongo.Database
+type RestaurantStaffMongoDb struct {
+	DB *mongo.Database
+}
+
+func (u RestaurantStaffMongoDb) CreateStaff(ctx context.Context, staff RestaurantStaff) (RestaurantStaff, error) {
+	insertedId, err := u.DB.Collection("RestaurantStaff").InsertOne(ctx, staff)
+	if err != nil {
+		return RestaurantStaff{}, duplicateKeyError(err, "phone number already works at a restaurant")
+	}
+	if insertedId == nil {
+		return RestaurantStaff{}, errors.Join(errors2.ServerError, errors.New("empty inserted id"))
+	}
+	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
+	staff.Id = id
+	return staff, nil
+}
+
+func (u RestaurantStaffMongoDb) UpdateStaff(ctx context.Context, staff RestaurantStaff) error {
+	updateResult, err := u.DB.Collection("RestaurantStaff").UpdateOne(ctx, bson.M{"_id": staff.Id}, bson.M{"$set": staff})
+	if err != nil {
+		return err
+	}
+
+	if updateResult == nil {
+		return errors.Join(errors2.ServerError, errors.New("no update result"))
+	}
+	if updateResult.MatchedCount != 1 {
+		return errors.Join(errors2.NotFoundError, errors.New("no matching document"))
+	}
+
+	return nil
+}
+
+func (u RestaurantStaffMongoDb) GetStaff(ctx context.Context, id primitive.ObjectID) (RestaurantStaff, error) {
+	var staff RestaurantStaff
+	err := u.DB.Collection("RestaurantStaff").FindOne(ctx, bson.M{"_id": id}).Decode(&staff)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return staff, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return staff, errors.Join(errors2.ServerError, err)
+	}
+	return staff, nil
+}
+
+// GetStaffByPhone staff member registered with the phone number which is not deleted, used on login
+func (u RestaurantStaffMongoDb) GetStaffByPhone(ctx context.Context, phoneNumber string) (RestaurantStaff, error) {
+	var staff RestaurantStaff
+	filter := bson.M{"phoneNumber": phoneNumber, "status": bson.M{"$ne": "DELETED"}}
+	err := u.DB.Collection("RestaurantStaff").FindOne(ctx, filter).Decode(&staff)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return staff, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return staff, errors.Join(errors2.ServerError, err)
+	}
+	return staff, nil
+}
+
+// GetRestaurantStaff staff of the restaurant which is not deleted, including pending invitations
+func (u RestaurantStaffMongoDb) GetRestaurantStaff(ctx context.Context, restaurantId primitive.ObjectID) ([]RestaurantStaff, error) {
+	filter := bson.M{"restaurantId": restaurantId, "status": bson.M{"$ne": "DELETED"}}
+	cursor, err := u.DB.Collection("RestaurantStaff").Find(ctx, filter)
+	if err != nil {
+		return nil, errors.Join(errors2.ServerError, err)
+	}
+	defer cursor.Close(ctx)
+
+	staff := []RestaurantStaff{}
+	if err := cursor.All(ctx, &staff); err != nil {
+		return nil, errors.Join(errors2.ServerError, err)
+	}
+	return staff, nil
+}
+
+// SetPhoneVerified marks the phone number of the staff member as verified by an otp
+func (u RestaurantStaffMongoDb) SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error {
+	updateResult, err := u.DB.Collection("RestaurantStaff").UpdateByID(ctx, id, bson.M{"$set": bson.M{"verified": true, "updatedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	if updateResult == nil {
+		return errors.Join(errors2.ServerError, errors.New("no update result"))
+	}
+	if updateResult.MatchedCount != 1 {
+		return errors.Join(errors2.NotFoundError, errors.New("no matching document"))
+	}
+
+	return nil
+}
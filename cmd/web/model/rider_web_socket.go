@@ -6,17 +6,137 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// WebSocketClient represents a WebSocket client connection.
+const (
+	// time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// messages queued for a client, a client with a full queue is too slow and is disconnected
+	sendBufferSize = 64
+	// time allowed to read the next pong or message from the client, a silent client is considered dead
+	pongWait = 60 * time.Second
+	// pings are sent before the read deadline of the client runs out
+	pingPeriod = pongWait * 9 / 10
+	// largest message accepted from a client, the connection is closed on larger ones
+	maxMessageSize = 4096
+)
+
+// WebSocketClient represents a WebSocket client connection, or an event stream managed the same way.
+// Only the write pump writes to the connection as gorilla websocket does not support concurrent writers.
 type WebSocketClient struct {
-	ws *websocket.Conn
+	ws        *websocket.Conn // nil for event streams
+	write     func(message []byte) error
+	ping      func() error
+	closeConn func() error
+	send      chan []byte
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
 }
 
-// NewWebSocketClient creates a new WebSocketClient instance.
+// NewWebSocketClient creates a new WebSocketClient instance and starts its write pump.
+// Every pong or message from the client extends the read deadline, reads on a dead connection fail after pongWait.
+// Reading a message larger than maxMessageSize fails with websocket.ErrReadLimit.
 func NewWebSocketClient(ws *websocket.Conn) *WebSocketClient {
+	client := newClient(
+		func(message []byte) error {
+			_ = ws.SetWriteDeadline(time.Now().Add(writeWait))
+			return ws.WriteMessage(websocket.TextMessage, message)
+		},
+		func() error {
+			_ = ws.SetWriteDeadline(time.Now().Add(writeWait))
+			return ws.WriteMessage(websocket.PingMessage, nil)
+		},
+		ws.Close,
+	)
+	client.ws = ws
+	ws.SetReadLimit(maxMessageSize)
+	_ = ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	go client.writePump()
+	return client
+}
+
+func newClient(write func(message []byte) error, ping func() error, closeConn func() error) *WebSocketClient {
 	return &WebSocketClient{
-		ws: ws,
+		write:     write,
+		ping:      ping,
+		closeConn: closeConn,
+		send:      make(chan []byte, sendBufferSize),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// ReadMessage reads the next message of the client and extends the read deadline.
+func (c *WebSocketClient) ReadMessage() ([]byte, error) {
+	_, message, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	_ = c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	return message, nil
+}
+
+// Send queues a message for the client without blocking, returns false if the client is too slow or closed.
+func (c *WebSocketClient) Send(message []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done is closed once the client is closed.
+func (c *WebSocketClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Wait blocks until the write pump of the client stopped.
+func (c *WebSocketClient) Wait() {
+	<-c.stopped
+}
+
+// Close closes the connection and stops the write pump, the read loop of the connection ends with an error.
+func (c *WebSocketClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.closeConn()
+	})
+}
+
+// writePump writes queued messages and periodic pings to the connection, a failed or timed out write closes the connection.
+func (c *WebSocketClient) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	defer close(c.stopped)
+	defer c.Close()
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			if err := c.write(message); err != nil {
+				slog.Error("error writing to websocket connection", "err", err)
+				return
+			}
+		case <-ticker.C:
+			if err := c.ping(); err != nil {
+				slog.Error("error pinging websocket connection", "err", err)
+				return
+			}
+		}
 	}
 }
 
@@ -24,83 +144,276 @@ func NewWebSocketClient(ws *websocket.Conn) *WebSocketClient {
 type WebSocketManager interface {
 	RegisterRider(riderID primitive.ObjectID, conn *WebSocketClient)
 	RegisterUser(userID primitive.ObjectID, conn *WebSocketClient)
-	UnregisterRider(riderID primitive.ObjectID)
-	UnregisterUser(userID primitive.ObjectID)
+	RegisterRestaurant(restaurantID primitive.ObjectID, conn *WebSocketClient)
+	RegisterOrderStream(orderID primitive.ObjectID, conn *WebSocketClient)
+	UnregisterRider(riderID primitive.ObjectID, conn *WebSocketClient)
+	UnregisterUser(userID primitive.ObjectID, conn *WebSocketClient)
+	UnregisterRestaurant(restaurantID primitive.ObjectID, conn *WebSocketClient)
+	UnregisterOrderStream(orderID primitive.ObjectID, conn *WebSocketClient)
 	BroadcastToRiders(message string, riderIDs []primitive.ObjectID)
 	BroadcastToUsers(message string, userIDs []primitive.ObjectID)
+	BroadcastToRestaurants(message string, restaurantIDs []primitive.ObjectID)
+	BroadcastToOrderStreams(message string, orderIDs []primitive.ObjectID)
+	Stats() SocketStats
+}
+
+// ConnectionPolicy what happens when the same rider or user connects again while already connected
+type ConnectionPolicy string
+
+const (
+	// ReplaceConnection the new connection replaces and closes the older ones
+	ReplaceConnection ConnectionPolicy = "REPLACE"
+	// MultiDevice every connection is kept and receives all messages
+	MultiDevice ConnectionPolicy = "MULTI_DEVICE"
+)
+
+// SocketStats live connection counts and counters since start of this instance
+type SocketStats struct {
+	Riders                int   `json:"riders"`                 // riders with at least one connection
+	Users                 int   `json:"users"`                  // users with at least one connection
+	Restaurants           int   `json:"restaurants"`            // restaurants with at least one connection
+	RiderConnections      int   `json:"rider_connections"`      // open rider connections
+	UserConnections       int   `json:"user_connections"`       // open user connections
+	RestaurantConnections int   `json:"restaurant_connections"` // open restaurant connections
+	OrderStreams          int   `json:"order_streams"`          // open streams tracking a single order
+	Connects              int64 `json:"connects"`
+	Disconnects           int64 `json:"disconnects"`
+	Replaced              int64 `json:"replaced"` // closed because the same id connected again
+	Evicted               int64 `json:"evicted"`  // closed because the client was too slow
 }
 
 // SocketManager is responsible for managing WebSocket connections.
 type SocketManager struct {
-	riderClients map[string]*WebSocketClient
-	userClients  map[string]*WebSocketClient
-	lock         sync.Mutex
-	OrderRepo    OrderRepository
+	riderClients      map[string][]*WebSocketClient
+	userClients       map[string][]*WebSocketClient
+	restaurantClients map[string][]*WebSocketClient
+	orderClients      map[string][]*WebSocketClient // streams of anyone tracking the order, only get its updates
+	lock              sync.Mutex
+	OrderRepo         OrderRepository
+	RiderPolicy       ConnectionPolicy // a rider works from one phone, older connections are stale
+	UserPolicy        ConnectionPolicy // a user may track an order from the phone and the web
+	RestaurantPolicy  ConnectionPolicy // a restaurant may run a dashboard on several counters
+
+	connects    atomic.Int64
+	disconnects atomic.Int64
+	replaced    atomic.Int64
+	evicted     atomic.Int64
 }
 
 // NewWebSocketManager creates a new SocketManager instance.
 func NewWebSocketManager(database *mongo.Database) *SocketManager {
 	return &SocketManager{
-		riderClients: make(map[string]*WebSocketClient),
-		userClients:  make(map[string]*WebSocketClient),
-		OrderRepo:    OrderRepository(OrderMongoRepo(database)),
+		riderClients:      make(map[string][]*WebSocketClient),
+		userClients:       make(map[string][]*WebSocketClient),
+		restaurantClients: make(map[string][]*WebSocketClient),
+		orderClients:      make(map[string][]*WebSocketClient),
+		OrderRepo:         OrderRepository(OrderMongoRepo(database)),
+		RiderPolicy:       ReplaceConnection,
+		UserPolicy:        MultiDevice,
+		RestaurantPolicy:  MultiDevice,
 	}
 }
 
 // RegisterRider registers a WebSocket connection for a rider.
 func (wm *SocketManager) RegisterRider(riderID primitive.ObjectID, conn *WebSocketClient) {
-	wm.lock.Lock()
-	defer wm.lock.Unlock()
-	wm.riderClients[riderID.Hex()] = conn
+	wm.register(wm.riderClients, wm.RiderPolicy, riderID.Hex(), conn)
 }
 
 // RegisterUser registers a WebSocket connection for a user.
 func (wm *SocketManager) RegisterUser(userID primitive.ObjectID, conn *WebSocketClient) {
+	wm.register(wm.userClients, wm.UserPolicy, userID.Hex(), conn)
+}
+
+// RegisterRestaurant registers a WebSocket connection for a restaurant dashboard.
+func (wm *SocketManager) RegisterRestaurant(restaurantID primitive.ObjectID, conn *WebSocketClient) {
+	wm.register(wm.restaurantClients, wm.RestaurantPolicy, restaurantID.Hex(), conn)
+}
+
+// RegisterOrderStream registers a stream tracking a single order, every stream of the order is kept.
+func (wm *SocketManager) RegisterOrderStream(orderID primitive.ObjectID, conn *WebSocketClient) {
+	wm.register(wm.orderClients, MultiDevice, orderID.Hex(), conn)
+}
+
+// UnregisterRider unregisters and closes a WebSocket connection of a rider, other connections of the rider are kept.
+func (wm *SocketManager) UnregisterRider(riderID primitive.ObjectID, conn *WebSocketClient) {
+	wm.unregister(wm.riderClients, riderID.Hex(), conn)
+}
+
+// UnregisterUser unregisters and closes a WebSocket connection of a user, other connections of the user are kept.
+func (wm *SocketManager) UnregisterUser(userID primitive.ObjectID, conn *WebSocketClient) {
+	wm.unregister(wm.userClients, userID.Hex(), conn)
+}
+
+// UnregisterRestaurant unregisters and closes a WebSocket connection of a restaurant, other dashboards are kept.
+func (wm *SocketManager) UnregisterRestaurant(restaurantID primitive.ObjectID, conn *WebSocketClient) {
+	wm.unregister(wm.restaurantClients, restaurantID.Hex(), conn)
+}
+
+// UnregisterOrderStream unregisters and closes a stream tracking an order, other streams of the order are kept.
+func (wm *SocketManager) UnregisterOrderStream(orderID primitive.ObjectID, conn *WebSocketClient) {
+	wm.unregister(wm.orderClients, orderID.Hex(), conn)
+}
+
+// BroadcastToRiders queues a message on WebSocket connections of riders, it never waits on the network.
+func (wm *SocketManager) BroadcastToRiders(message string, riderIDs []primitive.ObjectID) {
+	wm.broadcast(wm.riderClients, message, riderIDs)
+}
+
+// BroadcastToUsers queues a message on WebSocket connections of users, it never waits on the network.
+func (wm *SocketManager) BroadcastToUsers(message string, userIDs []primitive.ObjectID) {
+	wm.broadcast(wm.userClients, message, userIDs)
+}
+
+// BroadcastToRestaurants queues a message on WebSocket connections of restaurants, it never waits on the network.
+func (wm *SocketManager) BroadcastToRestaurants(message string, restaurantIDs []primitive.ObjectID) {
+	wm.broadcast(wm.restaurantClients, message, restaurantIDs)
+}
+
+// BroadcastToOrderStreams queues a message on the streams tracking the orders, it never waits on the network.
+func (wm *SocketManager) BroadcastToOrderStreams(message string, orderIDs []primitive.ObjectID) {
+	wm.broadcast(wm.orderClients, message, orderIDs)
+}
+
+// Stats connection counts of this instance
+func (wm *SocketManager) Stats() SocketStats {
+	wm.lock.Lock()
+	stats := SocketStats{
+		Riders:      len(wm.riderClients),
+		Users:       len(wm.userClients),
+		Restaurants: len(wm.restaurantClients),
+	}
+	for _, conns := range wm.riderClients {
+		stats.RiderConnections += len(conns)
+	}
+	for _, conns := range wm.userClients {
+		stats.UserConnections += len(conns)
+	}
+	for _, conns := range wm.restaurantClients {
+		stats.RestaurantConnections += len(conns)
+	}
+	for _, conns := range wm.orderClients {
+		stats.OrderStreams += len(conns)
+	}
+	wm.lock.Unlock()
+
+	stats.Connects = wm.connects.Load()
+	stats.Disconnects = wm.disconnects.Load()
+	stats.Replaced = wm.replaced.Load()
+	stats.Evicted = wm.evicted.Load()
+	return stats
+}
+
+// hasRider rider has at least one connection to this instance
+func (wm *SocketManager) hasRider(riderID primitive.ObjectID) bool {
 	wm.lock.Lock()
 	defer wm.lock.Unlock()
-	wm.userClients[userID.Hex()] = conn
+	return len(wm.riderClients[riderID.Hex()]) > 0
 }
 
-// UnregisterRider unregisters a WebSocket connection for a rider.
-func (wm *SocketManager) UnregisterRider(riderID primitive.ObjectID) {
+// hasUser user has at least one connection to this instance
+func (wm *SocketManager) hasUser(userID primitive.ObjectID) bool {
 	wm.lock.Lock()
 	defer wm.lock.Unlock()
-	delete(wm.riderClients, riderID.Hex())
+	return len(wm.userClients[userID.Hex()]) > 0
 }
 
-// UnregisterUser unregisters a WebSocket connection for a user.
-func (wm *SocketManager) UnregisterUser(userID primitive.ObjectID) {
+// hasRestaurant restaurant has at least one connection to this instance
+func (wm *SocketManager) hasRestaurant(restaurantID primitive.ObjectID) bool {
 	wm.lock.Lock()
 	defer wm.lock.Unlock()
-	delete(wm.userClients, userID.Hex())
+	return len(wm.restaurantClients[restaurantID.Hex()]) > 0
 }
 
-// BroadcastToRiders sends a message to WebSocket connections of riders.
-func (wm *SocketManager) BroadcastToRiders(message string, riderIDs []primitive.ObjectID) {
+// hasOrderStream order has at least one stream on this instance
+func (wm *SocketManager) hasOrderStream(orderID primitive.ObjectID) bool {
 	wm.lock.Lock()
 	defer wm.lock.Unlock()
-	for _, riderID := range riderIDs {
-		conn := wm.riderClients[riderID.Hex()]
-		if conn == nil {
+	return len(wm.orderClients[orderID.Hex()]) > 0
+}
+
+func (wm *SocketManager) register(clients map[string][]*WebSocketClient, policy ConnectionPolicy, id string, conn *WebSocketClient) {
+	wm.lock.Lock()
+	var replaced []*WebSocketClient
+	if policy == ReplaceConnection {
+		replaced = clients[id]
+		clients[id] = []*WebSocketClient{conn}
+	} else {
+		clients[id] = append(clients[id], conn)
+	}
+	wm.lock.Unlock()
+
+	wm.connects.Add(1)
+	for _, old := range replaced {
+		slog.Info("replacing websocket connection", "id", id)
+		wm.replaced.Add(1)
+		wm.disconnects.Add(1)
+		old.Close()
+	}
+}
+
+func (wm *SocketManager) unregister(clients map[string][]*WebSocketClient, id string, conn *WebSocketClient) {
+	if wm.remove(clients, id, conn) {
+		wm.disconnects.Add(1)
+	}
+	conn.Close()
+}
+
+// closeAll closes every connection of the id, they were replaced by a connection on another instance
+func (wm *SocketManager) closeAll(clients map[string][]*WebSocketClient, id string) {
+	wm.lock.Lock()
+	conns := clients[id]
+	delete(clients, id)
+	wm.lock.Unlock()
+
+	for _, conn := range conns {
+		slog.Info("replacing websocket connection held by another instance", "id", id)
+		wm.replaced.Add(1)
+		wm.disconnects.Add(1)
+		conn.Close()
+	}
+}
+
+// remove removes the connection from the id, returns false if the connection was already removed
+func (wm *SocketManager) remove(clients map[string][]*WebSocketClient, id string, conn *WebSocketClient) bool {
+	wm.lock.Lock()
+	defer wm.lock.Unlock()
+	conns := clients[id]
+	for i, c := range conns {
+		if c != conn {
 			continue
 		}
-		if err := conn.ws.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
-			slog.Error("error sending message to rider connection", "err", err)
+		conns = append(conns[:i:i], conns[i+1:]...)
+		if len(conns) == 0 {
+			delete(clients, id)
+		} else {
+			clients[id] = conns
 		}
+		return true
 	}
+	return false
 }
 
-// BroadcastToUsers sends a message to WebSocket connections of users.
-func (wm *SocketManager) BroadcastToUsers(message string, userIDs []primitive.ObjectID) {
+func (wm *SocketManager) broadcast(clients map[string][]*WebSocketClient, message string, ids []primitive.ObjectID) {
 	wm.lock.Lock()
-	defer wm.lock.Unlock()
-	for _, userID := range userIDs {
-		conn := wm.userClients[userID.Hex()]
-		if conn == nil {
-			continue
+	conns := make(map[string][]*WebSocketClient, len(ids))
+	for _, id := range ids {
+		if c := clients[id.Hex()]; len(c) > 0 {
+			conns[id.Hex()] = append([]*WebSocketClient(nil), c...)
 		}
-		if err := conn.ws.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
-			slog.Error("error sending message to user connection")
+	}
+	wm.lock.Unlock()
+
+	for id, idConns := range conns {
+		for _, conn := range idConns {
+			if conn.Send([]byte(message)) {
+				continue
+			}
+			slog.Error("evicting slow websocket connection", "id", id)
+			if wm.remove(clients, id, conn) {
+				wm.evicted.Add(1)
+				wm.disconnects.Add(1)
+			}
+			conn.Close()
 		}
 	}
 }
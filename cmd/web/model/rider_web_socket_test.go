@@ -0,0 +1,143 @@
+package model_test
+
+import (
+	"food-eats/cmd/web/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestSocket returns a client side connection and the server side WebSocketClient of it
+func newTestSocket(t *testing.T) (*websocket.Conn, *model.WebSocketClient) {
+	clients := make(chan *model.WebSocketClient, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		clients <- model.NewWebSocketClient(conn)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn, <-clients
+}
+
+func TestSocketManager_BroadcastToRiders(t *testing.T) {
+	sm := model.NewWebSocketManager(nil)
+	conn, client := newTestSocket(t)
+
+	riderId := primitive.NewObjectID()
+	sm.RegisterRider(riderId, client)
+	sm.BroadcastToRiders("New order for pickup", []primitive.ObjectID{riderId, primitive.NewObjectID()})
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "New order for pickup", string(msg))
+}
+
+func TestWebSocketClient_SendAfterClose(t *testing.T) {
+	_, client := newTestSocket(t)
+
+	assert.True(t, client.Send([]byte("hello")))
+	client.Close()
+	assert.False(t, client.Send([]byte("hello")))
+}
+
+func TestSocketManager_RegisterRiderReplacesConnection(t *testing.T) {
+	sm := model.NewWebSocketManager(nil)
+	oldConn, oldClient := newTestSocket(t)
+	newConn, newClient := newTestSocket(t)
+
+	riderId := primitive.NewObjectID()
+	sm.RegisterRider(riderId, oldClient)
+	sm.RegisterRider(riderId, newClient)
+	sm.BroadcastToRiders("New order for pickup", []primitive.ObjectID{riderId})
+
+	_ = newConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := newConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "New order for pickup", string(msg))
+
+	// the older connection is closed by the server
+	_ = oldConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = oldConn.ReadMessage()
+	assert.Error(t, err)
+
+	stats := sm.Stats()
+	assert.Equal(t, 1, stats.RiderConnections)
+	assert.Equal(t, int64(1), stats.Replaced)
+}
+
+func TestSocketManager_UnregisterUser(t *testing.T) {
+	sm := model.NewWebSocketManager(nil)
+	_, phone := newTestSocket(t)
+	_, web := newTestSocket(t)
+
+	userId := primitive.NewObjectID()
+	sm.RegisterUser(userId, phone)
+	sm.RegisterUser(userId, web)
+	assert.Equal(t, 2, sm.Stats().UserConnections)
+
+	sm.UnregisterUser(userId, phone)
+	sm.UnregisterUser(userId, phone)
+	stats := sm.Stats()
+	assert.Equal(t, 1, stats.Users)
+	assert.Equal(t, 1, stats.UserConnections)
+	assert.Equal(t, int64(1), stats.Disconnects)
+	assert.False(t, phone.Send([]byte("hello")))
+	assert.True(t, web.Send([]byte("hello")))
+}
+
+func TestSocketManager_BroadcastToRestaurants(t *testing.T) {
+	sm := model.NewWebSocketManager(nil)
+	counterConn, counterClient := newTestSocket(t)
+	kitchenConn, kitchenClient := newTestSocket(t)
+
+	restaurantId := primitive.NewObjectID()
+	sm.RegisterRestaurant(restaurantId, counterClient)
+	sm.RegisterRestaurant(restaurantId, kitchenClient)
+	sm.BroadcastToRestaurants("New order", []primitive.ObjectID{restaurantId})
+
+	// every dashboard of the restaurant gets the order
+	for _, conn := range []*websocket.Conn{counterConn, kitchenConn} {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "New order", string(msg))
+	}
+	assert.Equal(t, 2, sm.Stats().RestaurantConnections)
+}
+
+func TestSocketManager_BroadcastToOrderStreams(t *testing.T) {
+	sm := model.NewWebSocketManager(nil)
+	trackedConn, trackedClient := newTestSocket(t)
+	otherConn, otherClient := newTestSocket(t)
+
+	orderId, otherOrderId := primitive.NewObjectID(), primitive.NewObjectID()
+	sm.RegisterOrderStream(orderId, trackedClient)
+	sm.RegisterOrderStream(otherOrderId, otherClient)
+	sm.BroadcastToOrderStreams("Order picked up", []primitive.ObjectID{orderId})
+
+	_ = trackedConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := trackedConn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "Order picked up", string(msg))
+
+	// streams of other orders get nothing
+	_ = otherConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = otherConn.ReadMessage()
+	assert.Error(t, err)
+	assert.Equal(t, 2, sm.Stats().OrderStreams)
+}
@@ -16,11 +16,13 @@ type Rider struct {
 	Name        string             `json:"name" bson:"name"`
 	EmailId     string             `json:"email_id" bson:"emailId"`
 	PhoneNumber string             `json:"phone_number" bson:"phoneNumber"`
+	Verified    bool               `json:"verified" bson:"verified"` // phone number proven with an otp
 	CreatedAt   time.Time          `json:"created_at" bson:"createdAt"`
 	UpdatedAt   time.Time          `json:"updated_at" bson:"updatedAt"`
 
 	// default address fields for verification
 	Address       string   `json:"address" bson:"address"`
+	Pincode       string   `json:"pincode,omitempty" bson:"pincode,omitempty"`
 	Location      Location `json:"location" bson:"location"`
 	AverageRating float64  `json:"averageRating" bson:"averageRating"`
 
@@ -38,6 +40,8 @@ type RiderRepository interface {
 	CreateRider(ctx context.Context, rider Rider) (Rider, error)
 	UpdateRider(ctx context.Context, rider Rider) error
 	GetRider(ctx context.Context, id primitive.ObjectID) (Rider, error)
+	GetRiderByPhone(ctx context.Context, phoneNumber string) (Rider, error)
+	SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error
 	DeleteRider(ctx context.Context, id primitive.ObjectID) error
 	SearchRider(ctx context.Context, query SearchRiderQuery) ([]RiderSearchResponse, error)
 	UpdateAverageRating(ctx context.Context, id primitive.ObjectID, rating float64) error
@@ -56,30 +60,24 @@ type RiderMongoDb struct {
 func (u RiderMongoDb) CreateRider(ctx context.Context, rider Rider) (Rider, error) {
 	insertedId, err := u.DB.Collection("Rider").InsertOne(ctx, rider) // ignoring the parameter as we don't require it
 	if err != nil {
-		return Rider{}, err
+		return Rider{}, duplicateKeyError(err, "rider already exists")
 	}
 	if insertedId == nil {
 		return Rider{}, errors.Join(errors2.ServerError, errors.New("empty inserted id"))
 	}
 	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
 	rider.Id = id
+	auditCreated(ctx, u.DB, "Rider", id, rider)
 	return rider, nil
 }
 
 func (u RiderMongoDb) UpdateRider(ctx context.Context, rider Rider) error {
 	// todo instead of whole object set, we can use individual fields set
-	updateResult, err := u.DB.Collection("Rider").UpdateOne(ctx, bson.M{"_id": rider.Id}, bson.M{"$set": rider})
+	modified, err := auditedUpdate(ctx, u.DB, "Rider", AuditUpdate, rider.Id, rider)
 	if err != nil {
 		return err
 	}
-
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
-	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
@@ -91,7 +89,21 @@ func (u RiderMongoDb) GetRider(ctx context.Context, id primitive.ObjectID) (Ride
 	err := u.DB.Collection("Rider").FindOne(ctx, bson.M{"_id": id}).Decode(&rider)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return rider, errors.Join(errors2.ClientError, errors.New("no such entry"))
+			return rider, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return rider, errors.Join(errors2.ServerError, err)
+	}
+	return rider, nil
+}
+
+// GetRiderByPhone rider registered with the phone number which is not deleted, used on login
+func (u RiderMongoDb) GetRiderByPhone(ctx context.Context, phoneNumber string) (Rider, error) {
+	var rider Rider
+	filter := bson.M{"phoneNumber": phoneNumber, "status": bson.M{"$ne": "DELETED"}}
+	err := u.DB.Collection("Rider").FindOne(ctx, filter).Decode(&rider)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return rider, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
 		}
 		return rider, errors.Join(errors2.ServerError, err)
 	}
@@ -100,17 +112,11 @@ func (u RiderMongoDb) GetRider(ctx context.Context, id primitive.ObjectID) (Ride
 
 // DeleteRider if we want to delete a rider, for now we are marking it as deleted, to delete as a whole we need to delete all records for that person
 func (u RiderMongoDb) DeleteRider(ctx context.Context, id primitive.ObjectID) error {
-	updateResult, err := u.DB.Collection("Rider").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": "DELETED", "updatedAt": time.Now()}})
+	modified, err := auditedUpdate(ctx, u.DB, "Rider", AuditDelete, id, bson.M{"status": "DELETED", "updatedAt": time.Now()})
 	if err != nil {
-		return nil
-	}
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
+		return err
 	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
@@ -175,21 +181,19 @@ func (u RiderMongoDb) SearchRider(ctx context.Context, query SearchRiderQuery) (
 }
 
 func (u RiderMongoDb) UpdateAverageRating(ctx context.Context, id primitive.ObjectID, rating float64) error {
-
-	updateResult, err := u.DB.Collection("Rider").UpdateByID(ctx, id, bson.M{"$set": bson.M{"averageRating": rating}})
+	modified, err := auditedUpdate(ctx, u.DB, "Rider", AuditUpdate, id, bson.M{"averageRating": rating})
 	if err != nil {
 		return err
 	}
-
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
-	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
 	return nil
 }
+
+// SetPhoneVerified marks the phone number of the rider as verified by an otp
+func (u RiderMongoDb) SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error {
+	_, err := auditedUpdate(ctx, u.DB, "Rider", AuditUpdate, id, bson.M{"verified": true, "updatedAt": time.Now()})
+	return err
+}
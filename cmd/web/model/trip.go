@@ -0,0 +1,164 @@
+package model
+
+import (
+	"context"
+	"errors"
+	errors2 "food-eats/cmd/web/custom-errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// TripStop a single pickup or drop a rider has to make on a trip
+type TripStop struct {
+	OrderId   primitive.ObjectID `json:"order_id" bson:"orderId"`
+	Type      string             `json:"type" bson:"type"` // PICKUP or DROP
+	Latitude  float64            `json:"latitude" bson:"latitude"`
+	Longitude float64            `json:"longitude" bson:"longitude"`
+	Address   string             `json:"address" bson:"address"`
+	Status    string             `json:"status" bson:"status"` // PENDING, ARRIVED, COMPLETED
+
+	ArrivedAt   time.Time `json:"arrived_at,omitempty" bson:"arrivedAt,omitempty"`
+	CompletedAt time.Time `json:"completed_at,omitempty" bson:"completedAt,omitempty"`
+}
+
+// Trip groups one or more orders carried by a single rider, stops are kept in the order they should be visited
+type Trip struct {
+	Id       primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
+	RiderId  primitive.ObjectID   `json:"rider_id" bson:"riderId"` // index
+	OrderIds []primitive.ObjectID `json:"order_ids" bson:"orderIds"`
+	Stops    []TripStop           `json:"stops" bson:"stops"`
+
+	// last known rider location, updated whenever the rider reaches a stop
+	Latitude  float64 `json:"latitude" bson:"latitude"`
+	Longitude float64 `json:"longitude" bson:"longitude"`
+
+	CreatedAt   time.Time `json:"created_at" bson:"createdAt"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updatedAt"`
+	CompletedAt time.Time `json:"completed_at,omitempty" bson:"completedAt,omitempty"`
+
+	Status string `json:"status" bson:"status"` // ACTIVE or COMPLETED
+}
+
+// GetStop get the stop of the given type for an order
+func (t *Trip) GetStop(orderId primitive.ObjectID, stopType string) (*TripStop, bool) {
+	for i := range t.Stops {
+		if t.Stops[i].OrderId == orderId && t.Stops[i].Type == stopType {
+			return &t.Stops[i], true
+		}
+	}
+	return nil, false
+}
+
+// IsCompleted all stops of the trip are completed
+func (t *Trip) IsCompleted() bool {
+	for _, stop := range t.Stops {
+		if stop.Status != "COMPLETED" {
+			return false
+		}
+	}
+	return true
+}
+
+// TripRepository will be the trip repository, a database needs to implement this contract
+type TripRepository interface {
+	CreateTrip(ctx context.Context, trip Trip) (Trip, error)
+	UpdateTrip(ctx context.Context, trip Trip) error
+	GetTrip(ctx context.Context, id primitive.ObjectID) (Trip, error)
+	GetActiveTrip(ctx context.Context, riderId primitive.ObjectID) (Trip, error)
+	SearchActiveTrips(ctx context.Context, query SearchTripQuery) ([]Trip, error)
+}
+
+type SearchTripQuery struct {
+	RiderIds  []primitive.ObjectID
+	MaxOrders int // only trips carrying fewer orders than this
+}
+
+// TripMongo type with embedded mongo.Database
+type TripMongo struct {
+	DB *mongo.Database
+}
+
+func TripMongoRepo(DB *mongo.Database) TripMongo {
+	return TripMongo{DB: DB}
+}
+
+func (u TripMongo) CreateTrip(ctx context.Context, trip Trip) (Trip, error) {
+	insertedId, err := u.DB.Collection("Trip").InsertOne(ctx, trip)
+	if err != nil {
+		return Trip{}, err
+	}
+	if insertedId == nil {
+		return Trip{}, errors.Join(errors2.ServerError, errors.New("empty inserted id"))
+	}
+	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
+	trip.Id = id
+	return trip, nil
+}
+
+func (u TripMongo) UpdateTrip(ctx context.Context, trip Trip) error {
+	updateResult, err := u.DB.Collection("Trip").UpdateByID(ctx, trip.Id, bson.M{"$set": trip})
+	if err != nil {
+		return err
+	}
+
+	if updateResult == nil {
+		return errors.Join(errors2.ServerError, errors.New("no update result"))
+	}
+	if updateResult.MatchedCount != 1 {
+		return errors.Join(errors2.NotFoundError, errors.New("no matching document"))
+	}
+	if updateResult.ModifiedCount != 1 {
+		return errors.Join(errors2.ServerError, errors.New("update failed"))
+	}
+	return nil
+}
+
+func (u TripMongo) GetTrip(ctx context.Context, id primitive.ObjectID) (Trip, error) {
+	var trip Trip
+	err := u.DB.Collection("Trip").FindOne(ctx, bson.M{"_id": id}).Decode(&trip)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return trip, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return trip, errors.Join(errors2.ServerError, err)
+	}
+	return trip, nil
+}
+
+// GetActiveTrip get the trip a rider is currently on, a rider can only have one active trip
+func (u TripMongo) GetActiveTrip(ctx context.Context, riderId primitive.ObjectID) (Trip, error) {
+	var trip Trip
+	err := u.DB.Collection("Trip").FindOne(ctx, bson.M{"riderId": riderId, "status": "ACTIVE"}).Decode(&trip)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return trip, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return trip, errors.Join(errors2.ServerError, err)
+	}
+	return trip, nil
+}
+
+// SearchActiveTrips search trips which are still in progress
+func (u TripMongo) SearchActiveTrips(ctx context.Context, query SearchTripQuery) ([]Trip, error) {
+	filter := bson.M{"status": "ACTIVE"}
+	if len(query.RiderIds) > 0 {
+		filter["riderId"] = bson.M{"$in": query.RiderIds}
+	}
+	if query.MaxOrders > 0 {
+		filter["$expr"] = bson.M{"$lt": bson.A{bson.M{"$size": "$orderIds"}, query.MaxOrders}}
+	}
+
+	cursor, err := u.DB.Collection("Trip").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	trips := []Trip{}
+	if err := cursor.All(ctx, &trips); err != nil {
+		return nil, err
+	}
+	return trips, nil
+}
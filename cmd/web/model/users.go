@@ -17,11 +17,13 @@ type User struct {
 	Name        string             `json:"name" bson:"name"`
 	EmailId     string             `json:"email_id" bson:"emailId"`
 	PhoneNumber string             `json:"phone_number" bson:"phoneNumber"`
+	Verified    bool               `json:"verified" bson:"verified"` // phone number proven with an otp
 	CreatedAt   time.Time          `json:"created_at" bson:"createdAt"`
 	UpdatedAt   time.Time          `json:"updated_at" bson:"updatedAt"`
 
 	// default address fields
 	Address       string   `json:"address" bson:"address"`
+	Pincode       string   `json:"pincode,omitempty" bson:"pincode,omitempty"`
 	Location      Location `json:"location" bson:"location"`
 	AverageRating float64  `json:"averageRating" bson:"averageRating"`
 
@@ -40,6 +42,8 @@ type UserRepository interface {
 	CreateUser(ctx context.Context, user User) (User, error)
 	UpdateUser(ctx context.Context, user User) error
 	GetUser(ctx context.Context, id primitive.ObjectID) (User, error)
+	GetUserByPhone(ctx context.Context, phoneNumber string) (User, error)
+	SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error
 	DeleteUser(ctx context.Context, id primitive.ObjectID) error
 	UpdateAverageRating(ctx context.Context, id primitive.ObjectID, rating float64) error
 }
@@ -57,30 +61,24 @@ type UserMongoDb struct {
 func (u UserMongoDb) CreateUser(ctx context.Context, user User) (User, error) {
 	insertedId, err := u.DB.Collection("User").InsertOne(ctx, user)
 	if err != nil {
-		return User{}, err
+		return User{}, duplicateKeyError(err, "user already exists")
 	}
 	if insertedId == nil {
 		return User{}, errors.Join(errors2.ServerError, errors.New("empty inserted id"))
 	}
 	id, _ := (insertedId.InsertedID).(primitive.ObjectID)
 	user.Id = id
+	auditCreated(ctx, u.DB, "User", id, user)
 	return user, nil
 }
 
 func (u UserMongoDb) UpdateUser(ctx context.Context, user User) error {
 	// todo instead of whole object set, we can use individual fields set
-	updateResult, err := u.DB.Collection("User").UpdateOne(ctx, bson.M{"_id": user.Id}, bson.M{"$set": user})
+	modified, err := auditedUpdate(ctx, u.DB, "User", AuditUpdate, user.Id, user)
 	if err != nil {
 		return err
 	}
-
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
-	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
@@ -92,7 +90,21 @@ func (u UserMongoDb) GetUser(ctx context.Context, id primitive.ObjectID) (User,
 	err := u.DB.Collection("User").FindOne(ctx, bson.M{"_id": id}).Decode(&user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return user, errors.Join(errors2.ClientError, errors.New("no such entry"))
+			return user, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
+		}
+		return user, errors.Join(errors2.ServerError, err)
+	}
+	return user, nil
+}
+
+// GetUserByPhone user registered with the phone number which is not deleted, used on login
+func (u UserMongoDb) GetUserByPhone(ctx context.Context, phoneNumber string) (User, error) {
+	var user User
+	filter := bson.M{"phoneNumber": phoneNumber, "status": bson.M{"$ne": "DELETED"}}
+	err := u.DB.Collection("User").FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return user, errors.Join(errors2.NotFoundError, errors.New("no such entry"))
 		}
 		return user, errors.Join(errors2.ServerError, err)
 	}
@@ -101,17 +113,11 @@ func (u UserMongoDb) GetUser(ctx context.Context, id primitive.ObjectID) (User,
 
 // DeleteUser if we want to delete a user, for now we are marking it as deleted, to delete as a whole we need to delete all records for that person
 func (u UserMongoDb) DeleteUser(ctx context.Context, id primitive.ObjectID) error {
-	updateResult, err := u.DB.Collection("User").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"status": "DELETED", "updatedAt": time.Now()}})
+	modified, err := auditedUpdate(ctx, u.DB, "User", AuditDelete, id, bson.M{"status": "DELETED", "updatedAt": time.Now()})
 	if err != nil {
-		return nil
-	}
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
+		return err
 	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
@@ -119,20 +125,19 @@ func (u UserMongoDb) DeleteUser(ctx context.Context, id primitive.ObjectID) erro
 }
 
 func (u UserMongoDb) UpdateAverageRating(ctx context.Context, id primitive.ObjectID, rating float64) error {
-	updateResult, err := u.DB.Collection("Rider").UpdateByID(ctx, id, bson.M{"$set": bson.M{"averageRating": rating}})
+	modified, err := auditedUpdate(ctx, u.DB, "User", AuditUpdate, id, bson.M{"averageRating": rating})
 	if err != nil {
 		return err
 	}
-
-	if updateResult == nil {
-		return errors.Join(errors2.ServerError, errors.New("no update result"))
-	}
-	if updateResult.MatchedCount != 1 {
-		return errors.Join(errors2.ClientError, errors.New("no matching document"))
-	}
-	if updateResult.ModifiedCount != 1 {
+	if !modified {
 		return errors.Join(errors2.ServerError, errors.New("update failed"))
 	}
 
 	return nil
 }
+
+// SetPhoneVerified marks the phone number of the user as verified by an otp
+func (u UserMongoDb) SetPhoneVerified(ctx context.Context, id primitive.ObjectID) error {
+	_, err := auditedUpdate(ctx, u.DB, "User", AuditUpdate, id, bson.M{"verified": true, "updatedAt": time.Now()})
+	return err
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+)
+
+// senders selectable with the sms sender flag
+const (
+	LogSender    = "log"     // logs messages with codes redacted
+	DevLogSender = "dev-log" // logs messages in full, for local runs where the otp has to be read from the log
+)
+
+// SMSSender sends text messages, implemented by the sms provider in production
+type SMSSender interface {
+	Send(ctx context.Context, phoneNumber string, message string) error
+}
+
+// NewSMSSender sender of the name given to the sms sender flag
+func NewSMSSender(name string) (SMSSender, error) {
+	switch name {
+	case LogSender:
+		return LogSMSSender{}, nil
+	case DevLogSender:
+		return LogSMSSender{ShowCodes: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown sms sender %q", name)
+	}
+}
+
+// codePattern otps and other codes in a message
+var codePattern = regexp.MustCompile(`\d{4,}`)
+
+// LogSMSSender logs messages instead of sending them until an sms provider is plugged in, nothing is kept.
+// Codes are redacted so logs do not hand out otps, unless ShowCodes is set.
+type LogSMSSender struct {
+	ShowCodes bool
+}
+
+func (s LogSMSSender) Send(ctx context.Context, phoneNumber string, message string) error {
+	if !s.ShowCodes {
+		message = codePattern.ReplaceAllString(message, "[REDACTED]")
+	}
+	slog.InfoContext(ctx, "sms", "phone_number", phoneNumber, "message", message)
+	return nil
+}
+
+// InMemorySMSSender keeps messages per phone number instead of sending them, for tests
+type InMemorySMSSender struct {
+	messages map[string][]string
+	lock     sync.Mutex
+}
+
+func NewInMemorySMSSender() *InMemorySMSSender {
+	return &InMemorySMSSender{messages: map[string][]string{}}
+}
+
+func (s *InMemorySMSSender) Send(_ context.Context, phoneNumber string, message string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages[phoneNumber] = append(s.messages[phoneNumber], message)
+	return nil
+}
+
+// Messages sent to the phone number, oldest first
+func (s *InMemorySMSSender) Messages(phoneNumber string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.messages[phoneNumber]...)
+}
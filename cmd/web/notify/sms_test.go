@@ -0,0 +1,43 @@
+package notify_test
+
+import (
+	"bytes"
+	"context"
+	"food-eats/cmd/web/notify"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemorySMSSender(t *testing.T) {
+	var sender notify.SMSSender = notify.NewInMemorySMSSender()
+	assert.NoError(t, sender.Send(context.TODO(), "+919999999999", "first"))
+	assert.NoError(t, sender.Send(context.TODO(), "+919999999999", "second"))
+
+	messages := sender.(*notify.InMemorySMSSender).Messages("+919999999999")
+	assert.Equal(t, []string{"first", "second"}, messages)
+	assert.Empty(t, sender.(*notify.InMemorySMSSender).Messages("+918888888888"))
+}
+
+func TestLogSMSSender(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	message := "482913 is your food eats otp, valid for 5 minutes. Do not share it with anyone."
+
+	sender, err := notify.NewSMSSender(notify.LogSender)
+	assert.NoError(t, err)
+	assert.NoError(t, sender.Send(context.TODO(), "+919999999999", message))
+	assert.NotContains(t, logs.String(), "482913")
+	assert.Contains(t, logs.String(), "[REDACTED] is your food eats otp, valid for 5 minutes")
+
+	logs.Reset()
+	sender, err = notify.NewSMSSender(notify.DevLogSender)
+	assert.NoError(t, err)
+	assert.NoError(t, sender.Send(context.TODO(), "+919999999999", message))
+	assert.Contains(t, logs.String(), "482913")
+
+	_, err = notify.NewSMSSender("carrier-pigeon")
+	assert.Error(t, err)
+}
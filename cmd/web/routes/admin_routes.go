@@ -0,0 +1,181 @@
+package routes
+
+import (
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/db"
+	"food-eats/cmd/web/handlers"
+	"food-eats/cmd/web/model"
+	"github.com/labstack/echo/v4"
+	"github.com/patrickmn/go-cache"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+)
+
+// AdminApplication contains the field dependencies for AdminApplication
+type AdminApplication struct {
+	MongoDb *mongo.Database
+	SM      model.WebSocketManager
+	Cache   *cache.Cache
+}
+
+func (ua *AdminApplication) adminParam() handlers.AdminParam {
+	return handlers.AdminParam{
+		UserRepo:       model.UserRepository(model.UserMongoRepo(ua.MongoDb)),
+		RiderRepo:      model.RiderRepository(model.RiderMongoRepo(ua.MongoDb)),
+		RestaurantRepo: model.RestaurantRepository(model.RestaurantMongoRepo(ua.MongoDb)),
+		OrderRepo:      model.OrderRepository(model.OrderMongoRepo(ua.MongoDb)),
+		TripRepo:       model.TripRepository(model.TripMongoRepo(ua.MongoDb)),
+		AdminRepo:      model.AdminRepository(model.AdminMongoRepo(ua.MongoDb)),
+		AuditRepo:      model.AuditRepository(model.AuditMongoRepo(ua.MongoDb)),
+		Cache:          ua.Cache,
+		SM:             ua.SM,
+	}
+}
+
+// SuspendAccount suspending a user, rider or restaurant
+func (ua *AdminApplication) SuspendAccount(c echo.Context) error {
+	req := new(handlers.SuspendAccountRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	if err := req.SuspendAccount(ctx, ua.adminParam()); err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// ReinstateAccount lifting the suspension of a user, rider or restaurant
+func (ua *AdminApplication) ReinstateAccount(c echo.Context) error {
+	req := new(handlers.SuspendAccountRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	if err := req.ReinstateAccount(ctx, ua.adminParam()); err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// CancelOrder force cancelling an order
+func (ua *AdminApplication) CancelOrder(c echo.Context) error {
+	req := new(handlers.CancelOrderRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.CancelledBy = principalOf(c).Id
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	param := ua.adminParam()
+	param.RedisConn = redisConn
+
+	order, err := req.CancelOrder(ctx, param)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+// ReassignRider moving an order to another rider
+func (ua *AdminApplication) ReassignRider(c echo.Context) error {
+	req := new(handlers.ReassignRiderRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	order, err := req.ReassignRider(ctx, ua.adminParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+// GetEntity viewing any user, rider, restaurant, order or admin
+func (ua *AdminApplication) GetEntity(c echo.Context) error {
+	req := new(handlers.GetEntityRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	record, err := req.GetEntity(ctx, ua.adminParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, record)
+}
+
+// GetAuditLogs viewing the changes made to users, riders, restaurants, orders and ratings
+func (ua *AdminApplication) GetAuditLogs(c echo.Context) error {
+	req := new(handlers.GetAuditLogsRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	logs, err := req.GetAuditLogs(ctx, ua.adminParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, logs)
+}
+
+// CreateAgent registering a support agent or admin
+func (ua *AdminApplication) CreateAgent(c echo.Context) error {
+	req := new(handlers.CreateAgentRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	record, err := req.CreateAgent(ctx, ua.adminParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusCreated, record)
+}
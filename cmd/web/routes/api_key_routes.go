@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/handlers"
+	"food-eats/cmd/web/model"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+)
+
+// ApiKeyApplication contains the field dependencies for ApiKeyApplication
+type ApiKeyApplication struct {
+	MongoDb *mongo.Database
+}
+
+func (ua *ApiKeyApplication) apiKeyParam() handlers.ApiKeyParam {
+	return handlers.ApiKeyParam{
+		Repository: model.ApiKeyRepository(model.ApiKeyMongoRepo(ua.MongoDb)),
+	}
+}
+
+// CreateApiKey creating an api key for a partner system
+func (ua *ApiKeyApplication) CreateApiKey(c echo.Context) error {
+	req := new(handlers.CreateApiKeyRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.CreatedBy = principalOf(c)
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	key, err := req.CreateApiKey(ctx, ua.apiKeyParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusCreated, key)
+}
+
+// RotateApiKey replacing an api key with a new secret
+func (ua *ApiKeyApplication) RotateApiKey(c echo.Context) error {
+	req := new(handlers.ApiKeyRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.Principal = principalOf(c)
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	key, err := req.RotateApiKey(ctx, ua.apiKeyParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusCreated, key)
+}
+
+// RevokeApiKey revoking an api key
+func (ua *ApiKeyApplication) RevokeApiKey(c echo.Context) error {
+	req := new(handlers.ApiKeyRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.Principal = principalOf(c)
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	if err := req.RevokeApiKey(ctx, ua.apiKeyParam()); err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// GetApiKeys listing the api keys of the restaurant
+func (ua *ApiKeyApplication) GetApiKeys(c echo.Context) error {
+	req := &handlers.GetApiKeysRequest{RestaurantId: principalOf(c).ActingRestaurant()}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	keys, err := req.GetApiKeys(ctx, ua.apiKeyParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// GetApiKeyUsage requests made with an api key
+func (ua *ApiKeyApplication) GetApiKeyUsage(c echo.Context) error {
+	req := new(handlers.GetApiKeyUsageRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.Principal = principalOf(c)
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	usage, err := req.GetApiKeyUsage(ctx, ua.apiKeyParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
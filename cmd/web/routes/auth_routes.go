@@ -0,0 +1,182 @@
+package routes
+
+import (
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/db"
+	"food-eats/cmd/web/handlers"
+	"food-eats/cmd/web/model"
+	"food-eats/cmd/web/notify"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"strings"
+)
+
+// AuthApplication contains the field dependencies for AuthApplication
+type AuthApplication struct {
+	MongoDb *mongo.Database
+	Tokens  *auth.TokenManager
+	SMS     notify.SMSSender
+}
+
+// principalOf principal put into the request context by the auth middleware
+func principalOf(c echo.Context) auth.Principal {
+	principal, _ := auth.PrincipalFromContext(c.Request().Context())
+	return principal
+}
+
+// callerOf id and lower case type the caller acts as, staff and api keys act as their restaurant
+func callerOf(c echo.Context) (primitive.ObjectID, string) {
+	principal := principalOf(c)
+	if !principal.RestaurantId.IsZero() {
+		return principal.RestaurantId, strings.ToLower(auth.RestaurantPrincipal)
+	}
+	return principal.Id, strings.ToLower(principal.Type)
+}
+
+func (ua *AuthApplication) authParam() handlers.AuthParam {
+	return handlers.AuthParam{
+		UserRepo:       model.UserRepository(model.UserMongoRepo(ua.MongoDb)),
+		RiderRepo:      model.RiderRepository(model.RiderMongoRepo(ua.MongoDb)),
+		RestaurantRepo: model.RestaurantRepository(model.RestaurantMongoRepo(ua.MongoDb)),
+		AdminRepo:      model.AdminRepository(model.AdminMongoRepo(ua.MongoDb)),
+		StaffRepo:      model.RestaurantStaffRepository(model.RestaurantStaffMongoRepo(ua.MongoDb)),
+		Tokens:         ua.Tokens,
+		SMS:            ua.SMS,
+	}
+}
+
+// SendLoginOtp sending an otp to log in with
+func (ua *AuthApplication) SendLoginOtp(c echo.Context) error {
+	req := new(handlers.SendLoginOtpRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	param := ua.authParam()
+	param.RedisConn = redisConn
+
+	response, err := req.SendLoginOtp(ctx, param)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// Login logging in with the otp, access and refresh token are returned
+func (ua *AuthApplication) Login(c echo.Context) error {
+	req := new(handlers.LoginRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	param := ua.authParam()
+	param.RedisConn = redisConn
+
+	tokens, err := req.Login(ctx, param)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, nil, c)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// RefreshToken new tokens for a refresh token, before the access token expires
+func (ua *AuthApplication) RefreshToken(c echo.Context) error {
+	req := new(handlers.RefreshTokenRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	tokens, err := req.Refresh(ctx, ua.authParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, nil, c)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// SendPhoneOtp sending an otp to verify the phone number of the logged in user, rider or restaurant
+func (ua *AuthApplication) SendPhoneOtp(c echo.Context) error {
+	req := &handlers.SendPhoneOtpRequest{Principal: principalOf(c)}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	param := ua.authParam()
+	param.RedisConn = redisConn
+
+	response, err := req.SendPhoneOtp(ctx, param)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// VerifyPhone verifying the phone number with the otp
+func (ua *AuthApplication) VerifyPhone(c echo.Context) error {
+	req := new(handlers.VerifyPhoneRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.Principal = principalOf(c)
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer db.Close(redisConn)
+
+	param := ua.authParam()
+	param.RedisConn = redisConn
+
+	if err := req.VerifyPhone(ctx, param); err != nil {
+		return custom_errors.ParseError(ctx, err, nil, c)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"food-eats/cmd/web/auth"
 	"food-eats/cmd/web/custom-errors"
 	"food-eats/cmd/web/db"
 	"food-eats/cmd/web/handlers"
@@ -13,7 +14,8 @@ import (
 // OrderApplication contains the field dependencies for OrderApplication
 type OrderApplication struct {
 	MongoDb *mongo.Database
-	SM      *model.SocketManager
+	SM      model.WebSocketManager
+	Tokens  *auth.TokenManager
 }
 
 // CreateOrder route for registering a order
@@ -22,6 +24,7 @@ func (ua *OrderApplication) CreateOrder(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.UserId = principalOf(c).Id
 	if err := c.Validate(req); err != nil {
 		return err
 	}
@@ -45,6 +48,7 @@ func (ua *OrderApplication) CreateOrder(c echo.Context) error {
 		RiderRepo:      riderRepo,
 		UserRepo:       userRepo,
 		RedisConn:      redisConn,
+		SM:             ua.SM,
 	}
 
 	record, err := req.CreateOrder(ctx, repo)
@@ -98,6 +102,7 @@ func (ua *OrderApplication) GetRestaurantPendingOrder(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).ActingRestaurant()
 	if err := c.Validate(req); err != nil {
 		return err
 	}
@@ -133,6 +138,7 @@ func (ua *OrderApplication) AcceptOrder(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.RestaurantId = principalOf(c).ActingRestaurant()
 	if err := c.Validate(req); err != nil {
 		return err
 	}
@@ -148,12 +154,14 @@ func (ua *OrderApplication) AcceptOrder(c echo.Context) error {
 	restaurantRepo := model.RestaurantRepository(model.RestaurantMongoRepo(ua.MongoDb))
 	riderRepo := model.RiderRepository(model.RiderMongoRepo(ua.MongoDb))
 	userRepo := model.UserRepository(model.UserMongoRepo(ua.MongoDb))
+	tripRepo := model.TripRepository(model.TripMongoRepo(ua.MongoDb))
 
 	repo := handlers.OrderParam{
 		OrderRepo:      orderRepo,
 		RestaurantRepo: restaurantRepo,
 		RiderRepo:      riderRepo,
 		UserRepo:       userRepo,
+		TripRepo:       tripRepo,
 		SM:             ua.SM,
 		RedisConn:      redisConn,
 	}
@@ -173,6 +181,7 @@ func (ua *OrderApplication) SearchOrder(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id, req.Type = callerOf(c)
 	if err := c.Validate(req); err != nil {
 		return err
 	}
@@ -183,9 +192,6 @@ func (ua *OrderApplication) SearchOrder(c echo.Context) error {
 		return err
 	}
 	defer db.Close(redisConn)
-	if req.Type != "user" && req.Type != "restaurant" && req.Type != "rider" {
-		return c.JSON(http.StatusBadRequest, "unsupported type")
-	}
 
 	orderRepo := model.OrderMongoRepo(ua.MongoDb)
 	restaurantRepo := model.RestaurantRepository(model.RestaurantMongoRepo(ua.MongoDb))
@@ -208,3 +214,218 @@ func (ua *OrderApplication) SearchOrder(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, response)
 }
+
+// GetDeliveryOtp user getting the otp to be shared with the rider on delivery
+func (ua *OrderApplication) GetDeliveryOtp(c echo.Context) error {
+	req := new(handlers.GetDeliveryOtpRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.UserId = principalOf(c).Id
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	repo := handlers.OrderParam{
+		OrderRepo: model.OrderRepository(model.OrderMongoRepo(ua.MongoDb)),
+	}
+
+	response, err := req.GetDeliveryOtp(ctx, repo)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// CancelOrder user cancelling an order the restaurant has not accepted yet
+func (ua *OrderApplication) CancelOrder(c echo.Context) error {
+	req := new(handlers.CancelOwnOrderRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.UserId = principalOf(c).Id
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	repo := handlers.OrderParam{
+		OrderRepo: model.OrderRepository(model.OrderMongoRepo(ua.MongoDb)),
+		SM:        ua.SM,
+	}
+
+	order, err := req.CancelOrder(ctx, repo)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+// MarkFoodReady restaurant marking an order ready for pickup
+func (ua *OrderApplication) MarkFoodReady(c echo.Context) error {
+	req := new(handlers.FoodReadyRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.RestaurantId = principalOf(c).ActingRestaurant()
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	repo := handlers.OrderParam{
+		OrderRepo: model.OrderRepository(model.OrderMongoRepo(ua.MongoDb)),
+		SM:        ua.SM,
+	}
+
+	err := req.MarkFoodReady(ctx, repo)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// GetOrderRoute route travelled by the rider for an order
+func (ua *OrderApplication) GetOrderRoute(c echo.Context) error {
+	req := new(handlers.GetOrderRouteRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.Principal = principalOf(c)
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	repo := handlers.OrderParam{
+		OrderRepo:    model.OrderRepository(model.OrderMongoRepo(ua.MongoDb)),
+		LocationRepo: model.LocationHistoryRepository(model.LocationHistoryMongoRepo(ua.MongoDb)),
+	}
+
+	response, err := req.GetOrderRoute(ctx, repo)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// ReplayOrder timeline of an order for support staff
+func (ua *OrderApplication) ReplayOrder(c echo.Context) error {
+	req := new(handlers.ReplayOrderRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	repo := handlers.OrderParam{
+		OrderRepo:    model.OrderRepository(model.OrderMongoRepo(ua.MongoDb)),
+		LocationRepo: model.LocationHistoryRepository(model.LocationHistoryMongoRepo(ua.MongoDb)),
+	}
+
+	response, err := req.ReplayOrder(ctx, repo)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// ShareOrder user creating a link for others to track the order
+func (ua *OrderApplication) ShareOrder(c echo.Context) error {
+	req := new(handlers.ShareOrderRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.UserId = principalOf(c).Id
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	redisConn, err := db.RedisConnFromPool()
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	repo := handlers.OrderParam{
+		OrderRepo: model.OrderRepository(model.OrderMongoRepo(ua.MongoDb)),
+		RedisConn: redisConn,
+	}
+
+	response, err := req.ShareOrder(ctx, repo)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusCreated, response)
+}
+
+// TrackOrderStream user tracking an order over server-sent events, for clients behind proxies breaking websockets.
+// EventSource can not set headers either, so the token is taken like on websocket upgrades.
+func (ua *OrderApplication) TrackOrderStream(c echo.Context) error {
+	principal, err := ua.Tokens.Authenticate(c.Request(), auth.UserPrincipal)
+	if err != nil {
+		return custom_errors.Respond(c.Request().Context(), c, custom_errors.Unauthorized, err.Error())
+	}
+	req := new(handlers.TrackOrderStreamRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.UserId = principal.Id
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+	if req.LastSeq == "" {
+		req.LastSeq = c.Request().Header.Get("Last-Event-ID")
+	}
+
+	ctx := c.Request().Context()
+
+	repo := handlers.OrderParam{
+		OrderRepo: model.OrderRepository(model.OrderMongoRepo(ua.MongoDb)),
+	}
+	order, err := req.TrackedOrder(ctx, repo)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	w := c.Response()
+	client, err := model.NewEventStreamClient(w)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, err.Error())
+	}
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	// registered for the order, so the stream only gets the updates of this order
+	ua.SM.RegisterOrderStream(order.Id, client)
+	handlers.ResumeOrderStream(ctx, ua.SM, client, order.Id, req.LastSeq)
+	req.SendOrderStatus(client, order)
+
+	select {
+	case <-ctx.Done():
+	case <-client.Done():
+	}
+	ua.SM.UnregisterOrderStream(order.Id, client)
+	client.Wait()
+	return nil
+}
@@ -20,8 +20,9 @@ func (ua *RatingApplication) CreateNewRating(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.RatingGiver, req.RatingGiverType = callerOf(c)
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -55,7 +56,7 @@ func (ua *RatingApplication) GetRating(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -4,6 +4,7 @@ import (
 	custom_errors "food-eats/cmd/web/custom-errors"
 	"food-eats/cmd/web/handlers"
 	"food-eats/cmd/web/model"
+	"food-eats/cmd/web/notify"
 	"github.com/labstack/echo/v4"
 	"github.com/patrickmn/go-cache"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -14,6 +15,7 @@ import (
 type RestaurantApplication struct {
 	MongoDb *mongo.Database
 	Cache   *cache.Cache
+	SMS     notify.SMSSender
 }
 
 // CreateRestaurant route for registering a restaurant
@@ -23,7 +25,7 @@ func (ua *RestaurantApplication) CreateRestaurant(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -48,8 +50,9 @@ func (ua *RestaurantApplication) UpdateRestaurant(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).ActingRestaurant()
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -70,6 +73,144 @@ func (ua *RestaurantApplication) UpdateRestaurant(c echo.Context) error {
 	return c.JSON(http.StatusOK, nil)
 }
 
+// UpdateMenu route for replacing the menu of the restaurant
+func (ua *RestaurantApplication) UpdateMenu(c echo.Context) error {
+	req := new(handlers.UpdateMenuRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.Id = principalOf(c).ActingRestaurant()
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	restaurantParams := handlers.RestaurantParam{
+		Repository: model.RestaurantRepository(model.RestaurantMongoRepo(ua.MongoDb)),
+		Cache:      ua.Cache,
+	}
+
+	if err := req.UpdateMenu(ctx, restaurantParams); err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// UpdatePayout route for changing the payout details of the restaurant
+func (ua *RestaurantApplication) UpdatePayout(c echo.Context) error {
+	req := new(handlers.UpdatePayoutRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.Id = principalOf(c).ActingRestaurant()
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	restaurantParams := handlers.RestaurantParam{
+		Repository: model.RestaurantRepository(model.RestaurantMongoRepo(ua.MongoDb)),
+		Cache:      ua.Cache,
+	}
+
+	// not logging the request, it holds the account number
+	if err := req.UpdatePayout(ctx, restaurantParams); err != nil {
+		return custom_errors.ParseError(ctx, err, nil, c)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// GetPayout route for the payout details of the restaurant
+func (ua *RestaurantApplication) GetPayout(c echo.Context) error {
+	req := &handlers.GetRestaurantRequest{Id: principalOf(c).ActingRestaurant()}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	restaurantParams := handlers.RestaurantParam{
+		Repository: model.RestaurantRepository(model.RestaurantMongoRepo(ua.MongoDb)),
+	}
+
+	payout, err := req.GetPayout(ctx, restaurantParams)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, payout)
+}
+
+// InviteStaff route for inviting staff to the restaurant
+func (ua *RestaurantApplication) InviteStaff(c echo.Context) error {
+	req := new(handlers.InviteStaffRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.InvitedBy = principalOf(c)
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	staff, err := req.InviteStaff(ctx, ua.staffParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusCreated, staff)
+}
+
+// RemoveStaff route for removing staff or a pending invitation of the restaurant
+func (ua *RestaurantApplication) RemoveStaff(c echo.Context) error {
+	req := new(handlers.RemoveStaffRequest)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.RemovedBy = principalOf(c)
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	if err := req.RemoveStaff(ctx, ua.staffParam()); err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// GetStaff route listing the staff of the restaurant
+func (ua *RestaurantApplication) GetStaff(c echo.Context) error {
+	req := &handlers.GetStaffRequest{RestaurantId: principalOf(c).ActingRestaurant()}
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	staff, err := req.GetStaff(ctx, ua.staffParam())
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	return c.JSON(http.StatusOK, staff)
+}
+
+func (ua *RestaurantApplication) staffParam() handlers.StaffParam {
+	return handlers.StaffParam{
+		StaffRepo:      model.RestaurantStaffRepository(model.RestaurantStaffMongoRepo(ua.MongoDb)),
+		RestaurantRepo: model.RestaurantRepository(model.RestaurantMongoRepo(ua.MongoDb)),
+		SMS:            ua.SMS,
+	}
+}
+
 // GetRestaurant getting a restaurant
 func (ua *RestaurantApplication) GetRestaurant(c echo.Context) error {
 	req := new(handlers.GetRestaurantRequest)
@@ -77,7 +218,7 @@ func (ua *RestaurantApplication) GetRestaurant(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -104,8 +245,9 @@ func (ua *RestaurantApplication) DeleteRestaurant(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).ActingRestaurant()
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -135,7 +277,7 @@ func (ua *RestaurantApplication) SearchRestaurant(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
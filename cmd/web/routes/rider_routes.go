@@ -46,6 +46,7 @@ func (ua *RiderApplication) UpdateRider(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).Id
 	if err := c.Validate(req); err != nil {
 		return err
 	}
@@ -72,6 +73,7 @@ func (ua *RiderApplication) GetRider(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).Id
 	if err := c.Validate(req); err != nil {
 		return err
 	}
@@ -98,6 +100,7 @@ func (ua *RiderApplication) DeleteRider(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).Id
 	if err := c.Validate(req); err != nil {
 		return err
 	}
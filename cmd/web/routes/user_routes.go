@@ -12,7 +12,7 @@ import (
 // UserApplication contains the field dependencies for UserApplication
 type UserApplication struct {
 	MongoDb *mongo.Database
-	SM      *model.SocketManager
+	SM      model.WebSocketManager
 }
 
 // CreateUser route for registering a user
@@ -22,7 +22,7 @@ func (ua *UserApplication) CreateUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -47,8 +47,9 @@ func (ua *UserApplication) UpdateUser(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).Id
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -73,8 +74,9 @@ func (ua *UserApplication) GetUser(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).Id
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -99,8 +101,9 @@ func (ua *UserApplication) DeleteUser(c echo.Context) error {
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	req.Id = principalOf(c).Id
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	ctx := c.Request().Context()
@@ -1,8 +1,11 @@
 package routes
 
 import (
-	"encoding/json"
+	"errors"
+	"food-eats/cmd/web/auth"
+	"food-eats/cmd/web/custom-errors"
 	"food-eats/cmd/web/handlers"
+	"food-eats/cmd/web/middelwares"
 	"food-eats/cmd/web/model"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
@@ -19,92 +22,100 @@ type WsApplication struct {
 	SM        model.WebSocketManager
 	RedisConn *redis.Conn
 	OR        handlers.OrderParam
+	Geofence  handlers.GeofenceConfig
+	Tokens    *auth.TokenManager
+	upgrader  websocket.Upgrader
 }
 
-func NewWsApplication(mongodb *mongo.Database, sm model.WebSocketManager) *WsApplication {
+// NewWsApplication browsers can open sockets only from allowedOrigins, apps without an origin are always allowed
+func NewWsApplication(mongodb *mongo.Database, sm model.WebSocketManager, geofence handlers.GeofenceConfig, tokens *auth.TokenManager, allowedOrigins []string) *WsApplication {
 	return &WsApplication{
-		Mongodb: mongodb,
-		SM:      sm,
+		Mongodb:  mongodb,
+		SM:       sm,
+		Geofence: geofence,
+		Tokens:   tokens,
+		// to convert a tcp to web socket connection
+		upgrader: websocket.Upgrader{
+			CheckOrigin: auth.OriginChecker(allowedOrigins),
+		},
 	}
 }
 
-// to convert a tcp to web socket connection
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all connections
-		return true
-	},
-}
-
+// ConnectRiderWebSocket the rider is taken from the token of the upgrade request and bound to the connection,
+// messages on the connection are always processed as this rider
 func (ua *WsApplication) ConnectRiderWebSocket(c echo.Context) error {
-	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	principal, err := ua.Tokens.Authenticate(c.Request(), auth.RiderPrincipal)
 	if err != nil {
-		return err
+		return custom_errors.Respond(c.Request().Context(), c, custom_errors.Unauthorized, err.Error())
 	}
-	defer conn.Close()
-
-	// Read the first message to get the rider_id
-	_, msg, err := conn.ReadMessage()
-	if err != nil {
-		return err
+	req := new(handlers.RiderWebSocketReq)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
-
-	// Parse the first message to get the rider_id
-	var firstMsg handlers.RiderWebSocketReq
-	if err := json.Unmarshal(msg, &firstMsg); err != nil {
+	if err := c.Validate(req); err != nil {
 		return err
 	}
 
-	// Validate the rider_id
-	if firstMsg.RiderId == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Rider ID is required")
-	}
-
-	// Convert RiderId to ObjectID
-	oId, err := primitive.ObjectIDFromHex(firstMsg.RiderId)
+	conn, err := ua.upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		return err
 	}
+	client := model.NewWebSocketClient(conn)
+	defer client.Close()
+	oId := principal.Id
 
 	// mongo dependencies
 	restaurantRepo := model.RestaurantRepository(model.RestaurantMongoRepo(ua.Mongodb))
 	riderRepo := model.RiderRepository(model.RiderMongoRepo(ua.Mongodb))
 	orderRepo := model.OrderRepository(model.OrderMongoRepo(ua.Mongodb))
+	tripRepo := model.TripRepository(model.TripMongoRepo(ua.Mongodb))
+	locationRepo := model.LocationHistoryRepository(model.LocationHistoryMongoRepo(ua.Mongodb))
 
 	orderParam := handlers.OrderParam{
 		OrderRepo:      model.OrderRepository(orderRepo),
 		RestaurantRepo: restaurantRepo,
 		RiderRepo:      riderRepo,
+		TripRepo:       tripRepo,
+		LocationRepo:   locationRepo,
+		Geofence:       ua.Geofence,
 	}
 	ua.OR = orderParam
 
-	// Register the rider with WebSocket client
-	ua.SM.RegisterRider(oId, model.NewWebSocketClient(conn))
+	// Register the rider with WebSocket client, removing it again once the connection is closed or dead
+	ua.SM.RegisterRider(oId, client)
+	defer ua.SM.UnregisterRider(oId, client)
+
+	// the principal is kept on the context so changes made over the connection are audited with it
+	ctx := middleware.WithPrincipal(c.Request().Context(), principal)
 
-	ctx := c.Request().Context()
+	// sending what the rider missed while reconnecting
+	handlers.ResumeRider(ctx, ua.SM, client, oId, req.LastSeq)
 
+	limiter := handlers.NewRiderLimiter(oId)
 	for {
 		// Read subsequent messages from WebSocket
-		_, msg, err := conn.ReadMessage()
+		msg, err := client.ReadMessage()
 		if err != nil {
-			log.Println("Error reading message:", err)
+			logReadError(c, "rider", oId, err)
 			break
 		}
 		slog.Info("Received message", "msg", msg)
-		handlers.ProcessRiderMessage(ua.SM, ua.OR, oId, msg, ctx)
+		if err := handlers.ProcessRiderMessage(ua.SM, ua.OR, limiter, oId, msg, ctx); err != nil {
+			slog.WarnContext(ctx, "closing rider connection", "rider", oId, "remote_addr", c.RealIP(), "error", err.Error())
+			break
+		}
 	}
 
 	return nil
 }
 
+// ConnectUserWebSocket the user is taken from the token of the upgrade request and bound to the connection
 func (ua *WsApplication) ConnectUserWebSocket(c echo.Context) error {
-	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	principal, err := ua.Tokens.Authenticate(c.Request(), auth.UserPrincipal)
 	if err != nil {
-		return err
+		return custom_errors.Respond(c.Request().Context(), c, custom_errors.Unauthorized, err.Error())
 	}
-	defer conn.Close()
-
-	req := new(handlers.RiderWebSocketReq)
+	req := new(handlers.UserWebSocketReq)
 	if err := c.Bind(req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
@@ -112,25 +123,114 @@ func (ua *WsApplication) ConnectUserWebSocket(c echo.Context) error {
 		return err
 	}
 
-	oId, err := primitive.ObjectIDFromHex(req.RiderId)
+	conn, err := ua.upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		return err
 	}
+	client := model.NewWebSocketClient(conn)
+	defer client.Close()
+	oId := principal.Id
+
+	ua.SM.RegisterUser(oId, client)
+	defer ua.SM.UnregisterUser(oId, client)
 
-	ua.SM.RegisterUser(oId, model.NewWebSocketClient(conn))
+	ctx := middleware.WithPrincipal(c.Request().Context(), principal)
+	handlers.ResumeUser(ctx, ua.SM, client, oId, req.LastSeq)
 
-	//ctx := c.Request().Context()
+	orderParam := handlers.OrderParam{
+		OrderRepo: model.OrderRepository(model.OrderMongoRepo(ua.Mongodb)),
+	}
 
+	limiter := handlers.NewUserLimiter(oId)
 	for {
-		_, msg, err := conn.ReadMessage()
+		msg, err := client.ReadMessage()
 		if err != nil {
-			log.Println("Error reading message:", err)
+			logReadError(c, "user", oId, err)
 			break
 		}
 		slog.Info("Received message:", "msg", msg)
-		// no need of user message consumption
-		//handlers.ProcessUserMessage(ua.SM, req.RiderId, msg, ctx)
+		if err := handlers.ProcessUserMessage(ua.SM, orderParam, limiter, oId, msg, ctx); err != nil {
+			slog.WarnContext(ctx, "closing user connection", "user", oId, "remote_addr", c.RealIP(), "error", err.Error())
+			break
+		}
+	}
+
+	return nil
+}
+
+// ConnectRestaurantWebSocket restaurant dashboards get new orders and rider updates pushed instead of polling,
+// the restaurant is taken from the token of the upgrade request, of the restaurant or of one of its staff
+func (ua *WsApplication) ConnectRestaurantWebSocket(c echo.Context) error {
+	principal, err := ua.Tokens.Authenticate(c.Request(), auth.RestaurantPrincipal, auth.StaffPrincipal)
+	if err != nil {
+		return custom_errors.Respond(c.Request().Context(), c, custom_errors.Unauthorized, err.Error())
+	}
+	if !principal.Can(auth.PermRestaurantOrders) {
+		return custom_errors.Respond(c.Request().Context(), c, custom_errors.ForbiddenError, auth.ErrForbidden.Error())
+	}
+	req := new(handlers.RestaurantWebSocketReq)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	req.RestaurantId = principal.ActingRestaurant()
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	ctx := middleware.WithPrincipal(c.Request().Context(), principal)
+
+	// checking the restaurant before upgrading, so errors can still be sent as http responses
+	restaurantParam := handlers.RestaurantParam{
+		Repository: model.RestaurantRepository(model.RestaurantMongoRepo(ua.Mongodb)),
+	}
+	restaurant, err := req.ConnectRestaurant(ctx, restaurantParam)
+	if err != nil {
+		return custom_errors.ParseError(ctx, err, req, c)
+	}
+
+	conn, err := ua.upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return err
+	}
+	client := model.NewWebSocketClient(conn)
+	defer client.Close()
+
+	ua.SM.RegisterRestaurant(restaurant.Id, client)
+	defer ua.SM.UnregisterRestaurant(restaurant.Id, client)
+	handlers.ResumeRestaurant(ctx, ua.SM, client, restaurant.Id, req.LastSeq)
+
+	limiter := handlers.NewRestaurantLimiter(restaurant.Id)
+	for {
+		msg, err := client.ReadMessage()
+		if err != nil {
+			logReadError(c, "restaurant", restaurant.Id, err)
+			break
+		}
+		if err := handlers.ProcessRestaurantMessage(ua.SM, limiter, restaurant.Id, msg, ctx); err != nil {
+			slog.WarnContext(ctx, "closing restaurant connection", "restaurant", restaurant.Id, "remote_addr", c.RealIP(), "error", err.Error())
+			break
+		}
 	}
 
 	return nil
 }
+
+// logReadError connections closed for sending too large messages are logged as abuse
+func logReadError(c echo.Context, recipient string, id primitive.ObjectID, err error) {
+	if errors.Is(err, websocket.ErrReadLimit) {
+		slog.WarnContext(c.Request().Context(), "closing connection sending too large message",
+			"recipient", recipient, "id", id, "remote_addr", c.RealIP())
+		return
+	}
+	log.Println("Error reading message:", err)
+}
+
+// GetWebSocketStats live websocket connections of this instance
+func (ua *WsApplication) GetWebSocketStats(c echo.Context) error {
+	return c.JSON(http.StatusOK, ua.SM.Stats())
+}
+
+// GetMessageSchemas JSON Schema of every websocket message type
+func (ua *WsApplication) GetMessageSchemas(c echo.Context) error {
+	return c.JSON(http.StatusOK, handlers.GetMessageSchemas())
+}
@@ -0,0 +1,155 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"food-eats/cmd/web/custom-errors"
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var (
+	// pincodeRegex indian postal code, six digits not starting with 0
+	pincodeRegex = regexp.MustCompile(`^[1-9][0-9]{5}$`)
+	// menuItemIdRegex ids restaurants give their menu items, like paneer-tikka or item_12
+	menuItemIdRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+)
+
+// Validator validates requests with the built-in rules and the rules of food eats
+//
+//	objectid     primitive.ObjectID which is set, or a string holding a valid object id
+//	pincode      indian pincode
+//	menu_item_id id of a menu item, lower case letters, digits, - and _
+type Validator struct {
+	validate *validator.Validate
+}
+
+// New validator with the custom rules registered
+func New() *Validator {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(jsonName)
+	mustRegister(validate, "objectid", isObjectId)
+	mustRegister(validate, "pincode", matches(pincodeRegex))
+	mustRegister(validate, "menu_item_id", matches(menuItemIdRegex))
+	return &Validator{validate: validate}
+}
+
+func mustRegister(validate *validator.Validate, tag string, fn validator.Func) {
+	if err := validate.RegisterValidation(tag, fn); err != nil {
+		panic(err)
+	}
+}
+
+// Validate returns custom_errors.ValidationErrors listing every invalid field
+func (v *Validator) Validate(i interface{}) error {
+	err := v.validate.Struct(i)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		if err != nil {
+			// not a struct, a bug in the route
+			return errors.Join(custom_errors.ServerError, err)
+		}
+		return nil
+	}
+
+	fields := make(custom_errors.ValidationErrors, len(validationErrors))
+	for i, fieldError := range validationErrors {
+		fields[i] = custom_errors.FieldError{
+			Field:    withoutStruct(fieldError.StructNamespace()),
+			JsonName: withoutStruct(fieldError.Namespace()),
+			Rule:     fieldError.Tag(),
+			Param:    fieldError.Param(),
+			Message:  message(fieldError),
+		}
+	}
+	return fields
+}
+
+// jsonName name of the field in the body or the query, fields filled from the token keep their go name
+func jsonName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "query"} {
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// withoutStruct drops the request type from the namespace, CreateOrderRequest.Items[0].Id becomes Items[0].Id
+func withoutStruct(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func isObjectId(fl validator.FieldLevel) bool {
+	switch value := fl.Field().Interface().(type) {
+	case primitive.ObjectID:
+		return !value.IsZero()
+	case string:
+		return primitive.IsValidObjectID(value)
+	}
+	return false
+}
+
+func matches(regex *regexp.Regexp) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return fl.Field().Kind() == reflect.String && regex.MatchString(fl.Field().String())
+	}
+}
+
+// message readable reason of the failed rule
+func message(fieldError validator.FieldError) string {
+	param := fieldError.Param()
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	case "objectid":
+		return "must be a valid id"
+	case "pincode":
+		return "must be a 6 digit pincode"
+	case "menu_item_id":
+		return "must be lower case letters, digits, - or _"
+	case "email":
+		return "must be a valid email"
+	case "e164", "startswith":
+		return "must be a phone number starting with +91"
+	case "latitude", "longitude":
+		return "must be a valid " + fieldError.Tag()
+	case "oneof":
+		return "must be one of " + param
+	case "unique":
+		return "must not contain duplicates"
+	case "min", "gte":
+		if isCollection(fieldError.Kind()) || fieldError.Kind() == reflect.String {
+			return "must have at least " + param + " " + unit(fieldError.Kind())
+		}
+		return "must be at least " + param
+	case "max", "lte":
+		if isCollection(fieldError.Kind()) || fieldError.Kind() == reflect.String {
+			return "must have at most " + param + " " + unit(fieldError.Kind())
+		}
+		return "must be at most " + param
+	case "gt":
+		return "must be greater than " + param
+	case "lt":
+		return "must be less than " + param
+	}
+	return fmt.Sprintf("failed the %s rule", fieldError.Tag())
+}
+
+func isCollection(kind reflect.Kind) bool {
+	return kind == reflect.Slice || kind == reflect.Array || kind == reflect.Map
+}
+
+func unit(kind reflect.Kind) string {
+	if kind == reflect.String {
+		return "characters"
+	}
+	return "entries"
+}
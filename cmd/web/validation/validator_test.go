@@ -0,0 +1,92 @@
+package validation_test
+
+import (
+	"errors"
+	"food-eats/cmd/web/custom-errors"
+	"food-eats/cmd/web/handlers"
+	"food-eats/cmd/web/model"
+	"food-eats/cmd/web/validation"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestValidate(t *testing.T) {
+	v := validation.New()
+
+	valid := &handlers.CreateOrderRequest{
+		UserId:       primitive.NewObjectID(),
+		RestaurantId: primitive.NewObjectID(),
+		Items:        []model.Item{{Id: "paneer-tikka", Name: "Paneer Tikka", Price: 249}},
+	}
+	assert.NoError(t, v.Validate(valid))
+
+	err := v.Validate(&handlers.CreateOrderRequest{
+		Items: []model.Item{{Id: "Paneer Tikka", Name: "Paneer Tikka", Price: 249}},
+	})
+	assert.ErrorIs(t, err, custom_errors.ValidationError)
+	assert.ErrorIs(t, err, custom_errors.ClientError)
+
+	var fields custom_errors.ValidationErrors
+	assert.True(t, errors.As(err, &fields))
+	assert.Equal(t, custom_errors.ValidationErrors{
+		{Field: "RestaurantId", JsonName: "restaurant_id", Rule: "objectid", Message: "must be a valid id"},
+		{Field: "Items[0].Id", JsonName: "items[0].id", Rule: "menu_item_id", Message: "must be lower case letters, digits, - or _"},
+	}, fields)
+}
+
+func TestCustomRules(t *testing.T) {
+	v := validation.New()
+	tests := []struct {
+		name  string
+		req   interface{}
+		field string
+		rule  string
+	}{
+		{
+			name: "pincode starting with 0",
+			req: &handlers.CreateUserRequest{Name: "a", EmailId: "a@b.in", PhoneNumber: "+919999999999",
+				Latitude: "12.9", Longitude: "77.5", Address: "a", Pincode: "012345"},
+			field: "pincode",
+			rule:  "pincode",
+		},
+		{
+			name: "duplicate menu item ids",
+			req: &handlers.UpdateMenuRequest{Id: primitive.NewObjectID(), Menu: model.Menu{Items: []model.Item{
+				{Id: "fries", Name: "Fries", Price: 79},
+				{Id: "fries", Name: "Large Fries", Price: 99},
+			}}},
+			field: "menu.items",
+			rule:  "unique",
+		},
+		{
+			name:  "rating receiver type",
+			req:   &handlers.RatingRequest{RatingGiver: primitive.NewObjectID(), RatingReceiver: primitive.NewObjectID(), OrderId: primitive.NewObjectID(), RatingGiverType: "user", RatingReceiverType: "admin", Rating: 4},
+			field: "rating_receiver_type",
+			rule:  "oneof",
+		},
+		{
+			name:  "search order status",
+			req:   &handlers.SearchOrderRequest{Id: primitive.NewObjectID(), Type: "user", Status: "LOST"},
+			field: "status",
+			rule:  "oneof",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields custom_errors.ValidationErrors
+			assert.True(t, errors.As(v.Validate(tt.req), &fields))
+			assert.Len(t, fields, 1)
+			assert.Equal(t, tt.field, fields[0].JsonName)
+			assert.Equal(t, tt.rule, fields[0].Rule)
+		})
+	}
+
+	// a valid pincode and no pincode at all
+	user := &handlers.CreateUserRequest{Name: "a", EmailId: "a@b.in", PhoneNumber: "+919999999999",
+		Latitude: "12.9", Longitude: "77.5", Address: "a", Pincode: "560001"}
+	assert.NoError(t, v.Validate(user))
+	user.Pincode = ""
+	assert.NoError(t, v.Validate(user))
+}